@@ -0,0 +1,452 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// directory of rendered cards, a temporary directory by default
+	CARD_CACHE_DIR = os.Getenv("CARD_CACHE_DIR")
+)
+
+const (
+	// bump to invalidate cached cards when layout change
+	cardVersion = "1"
+	// minimum and maximum `wrap` columns
+	cardMinWrap = 10
+	cardMaxWrap = 80
+	// scales of quote text, font pixels are scaled to squares
+	cardMinScale = 2
+	cardMaxScale = 12
+)
+
+// cardSize is an image size preset
+type cardSize struct {
+	Width  int
+	Height int
+}
+
+var cardSizes = map[string]cardSize{
+	"square":    {1080, 1080},
+	"twitter":   {1200, 675},
+	"opengraph": {1200, 630},
+}
+
+// cardTheme is a color preset
+type cardTheme struct {
+	Background color.RGBA
+	Text       color.RGBA
+	Accent     color.RGBA
+}
+
+var cardThemes = map[string]cardTheme{
+	"light": {color.RGBA{0xfa, 0xfa, 0xf7, 0xff}, color.RGBA{0x22, 0x22, 0x22, 0xff}, color.RGBA{0x88, 0x88, 0x88, 0xff}},
+	"dark":  {color.RGBA{0x1e, 0x1e, 0x24, 0xff}, color.RGBA{0xee, 0xee, 0xee, 0xff}, color.RGBA{0x99, 0x99, 0xa5, 0xff}},
+}
+
+// cardOptions are the query parameters of cards
+type cardOptions struct {
+	Size  string
+	Theme string
+	// maximum characters per line, 0 wrap to the card width
+	Wrap int
+	// hex colors overriding the theme, e.g. `1e1e24`
+	Background string
+	Text       string
+	Accent     string
+	// CSS font family of SVG cards
+	Font string
+}
+
+var (
+	hexColorRegexp = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	// font families, e.g. `Georgia, 'Times New Roman', serif`
+	fontFamilyRegexp = regexp.MustCompile(`^[A-Za-z0-9 ,'-]{1,100}$`)
+)
+
+// parseHexColor parse `rgb` or `rrggbb` color, optionally prefixed by `#`
+func parseHexColor(value string) (color.RGBA, error) {
+	if !hexColorRegexp.MatchString(value) {
+		return color.RGBA{}, errors.New("invalid color " + strconv.Quote(value) + ", expected rgb or rrggbb hex color")
+	}
+	value = strings.TrimPrefix(value, "#")
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+	b, _ := hex.DecodeString(value)
+	return color.RGBA{b[0], b[1], b[2], 0xff}, nil
+}
+
+// colors return theme colors with overrides
+func (opts cardOptions) colors() cardTheme {
+	theme := cardThemes[opts.Theme]
+	for _, override := range []struct {
+		value string
+		color *color.RGBA
+	}{
+		{opts.Background, &theme.Background},
+		{opts.Text, &theme.Text},
+		{opts.Accent, &theme.Accent},
+	} {
+		if c, err := parseHexColor(override.value); err == nil {
+			*override.color = c
+		}
+	}
+	return theme
+}
+
+// cardOptionsFromRequest read `size`, `theme` and `wrap` query parameters
+func cardOptionsFromRequest(r *http.Request) (cardOptions, error) {
+	query := r.URL.Query()
+	opts := cardOptions{Size: "opengraph", Theme: "light"}
+	if value := query.Get("size"); value != "" {
+		if _, ok := cardSizes[value]; !ok {
+			return opts, errors.New("size must be square, twitter or opengraph")
+		}
+		opts.Size = value
+	}
+	if value := query.Get("theme"); value != "" {
+		if _, ok := cardThemes[value]; !ok {
+			return opts, errors.New("theme must be light or dark")
+		}
+		opts.Theme = value
+	}
+	if value := query.Get("wrap"); value != "" {
+		wrap, err := strconv.Atoi(value)
+		if err != nil || wrap < cardMinWrap || wrap > cardMaxWrap {
+			return opts, fmt.Errorf("wrap must be an integer between %d and %d", cardMinWrap, cardMaxWrap)
+		}
+		opts.Wrap = wrap
+	}
+	for _, param := range []struct {
+		name  string
+		value *string
+	}{
+		{"bg", &opts.Background},
+		{"color", &opts.Text},
+		{"accent", &opts.Accent},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		if _, err := parseHexColor(value); err != nil {
+			return opts, errors.New(param.name + ": " + err.Error())
+		}
+		*param.value = strings.ToLower(strings.TrimPrefix(value, "#"))
+	}
+	if value := query.Get("font"); value != "" {
+		if !fontFamilyRegexp.MatchString(value) {
+			return opts, errors.New("font must be a list of font families, e.g. Georgia, serif")
+		}
+		opts.Font = value
+	}
+	return opts, nil
+}
+
+// cached check card of options is cached. Cards with custom colors or font
+// are rendered on every request, otherwise anyone could fill the cache
+// directory with colors combinations
+func (opts cardOptions) cached() bool {
+	return opts.Background == "" && opts.Text == "" && opts.Accent == "" && opts.Font == ""
+}
+
+// query return options as query string, used to link cards
+func (opts cardOptions) query() string {
+	query := "size=" + opts.Size + "&theme=" + opts.Theme
+	if opts.Wrap != 0 {
+		query += "&wrap=" + strconv.Itoa(opts.Wrap)
+	}
+	for _, param := range [][2]string{{"bg", opts.Background}, {"color", opts.Text}, {"accent", opts.Accent}, {"font", opts.Font}} {
+		if param[1] != "" {
+			query += "&" + param[0] + "=" + url.QueryEscape(param[1])
+		}
+	}
+	return query
+}
+
+// cardText is a line of text. X and Y are the top left corner, every font
+// pixel is a Scale x Scale square
+type cardText struct {
+	X     int
+	Y     int
+	Scale int
+	Text  string
+	Color color.RGBA
+}
+
+// cardLayout is a card ready to be rendered
+type cardLayout struct {
+	// unique id of the card, e.g. to prefix SVG element ids
+	Id         string
+	Width      int
+	Height     int
+	Background color.RGBA
+	Texts      []cardText
+	// accessible title and description of the card
+	Title       string
+	Description string
+	Font        string
+}
+
+// wrapWords wrap text to lines of at most columns characters. Words longer
+// than a line are split
+func wrapWords(text string, columns int) []string {
+	var lines []string
+	line := ""
+	length := 0
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+		for len(runes) > columns {
+			if line != "" {
+				lines = append(lines, line)
+				line, length = "", 0
+			}
+			lines = append(lines, string(runes[:columns]))
+			runes = runes[columns:]
+		}
+		switch {
+		case line == "":
+			line, length = string(runes), len(runes)
+		case length+1+len(runes) <= columns:
+			line += " " + string(runes)
+			length += 1 + len(runes)
+		default:
+			lines = append(lines, line)
+			line, length = string(runes), len(runes)
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// layoutCard place quote text, author name and company on a card. Quote
+// text use the largest scale that fit. Texts are converted to characters
+// the renderer can draw
+func layoutCard(quote *Quote, opts cardOptions, convert func(string) string) *cardLayout {
+	size := cardSizes[opts.Size]
+	theme := opts.colors()
+	layout := &cardLayout{
+		Width:       size.Width,
+		Height:      size.Height,
+		Background:  theme.Background,
+		Title:       "Quote by " + quote.Author.Name,
+		Description: quote.Content + " — " + quote.Author.Name,
+		Font:        opts.Font,
+	}
+	padding := size.Width / 12
+	width := size.Width - 2*padding
+
+	// decorative quote mark
+	markScale := size.Width / 80
+	layout.Texts = append(layout.Texts, cardText{padding - markScale, padding - markScale, markScale, convert("“"), theme.Accent})
+	top := padding + fontGlyphHeight*markScale/2
+
+	// author name and company at the bottom
+	authorScale := size.Width / 300
+	bottom := size.Height - padding
+	company := convert(quote.Author.Company)
+	if company != "" {
+		bottom -= fontCellHeight * (authorScale - 1)
+		layout.Texts = append(layout.Texts, cardText{padding, bottom, authorScale - 1, company, theme.Accent})
+	}
+	bottom -= fontCellHeight * authorScale
+	layout.Texts = append(layout.Texts, cardText{padding, bottom, authorScale, convert("— " + quote.Author.Name), theme.Text})
+	bottom -= 2 * fontCellHeight * authorScale
+
+	// quote text, vertically centered between the mark and the author
+	text := convert(quote.Content)
+	height := bottom - top
+	var lines []string
+	scale := cardMaxScale
+	for ; ; scale-- {
+		columns := width / (fontCellWidth * scale)
+		if opts.Wrap != 0 && opts.Wrap < columns {
+			columns = opts.Wrap
+		}
+		lines = wrapWords(text, columns)
+		if len(lines)*fontCellHeight*scale <= height || scale == cardMinScale {
+			break
+		}
+	}
+	// text too long even at the smallest scale
+	if max := height / (fontCellHeight * scale); len(lines) > max && max > 0 {
+		lines = lines[:max]
+		last := []rune(lines[max-1])
+		if len(last) > 3 {
+			last = last[:len(last)-3]
+		}
+		lines[max-1] = strings.TrimSpace(string(last)) + convert("…")
+	}
+	y := top + (height-len(lines)*fontCellHeight*scale)/2
+	for _, line := range lines {
+		layout.Texts = append(layout.Texts, cardText{padding, y, scale, line, theme.Text})
+		y += fontCellHeight * scale
+	}
+	return layout
+}
+
+// cardRenderer render a card layout to an image format
+type cardRenderer struct {
+	ContentType string
+	// convert texts to characters the renderer can draw
+	Convert func(text string) string
+	Render  func(layout *cardLayout) ([]byte, error)
+}
+
+// renderers by file extension
+var cardRenderers = map[string]cardRenderer{
+	"png": {"image/png", fontText, renderCardPNG},
+	"svg": {"image/svg+xml", strings.TrimSpace, renderCardSVG},
+}
+
+// cardCacheDir return directory of rendered cards
+func cardCacheDir() string {
+	if CARD_CACHE_DIR != "" {
+		return CARD_CACHE_DIR
+	}
+	return filepath.Join(os.TempDir(), "wisdom-cards")
+}
+
+// cardKey identify a rendered card, it change when quote or options change
+func cardKey(quote *Quote, opts cardOptions, format string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%s\x00%s\x00%s",
+		cardVersion, format, opts.query(), quote.Id, quote.Content, quote.Author.Name, quote.Author.Company)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// renderCard return a rendered card from the disk cache, or render and
+// cache it. Only cards of size and theme presets are cached. Cache errors
+// are logged, the card is still rendered
+func renderCard(quote *Quote, opts cardOptions, format string) ([]byte, string, error) {
+	key := cardKey(quote, opts, format)
+	path := filepath.Join(cardCacheDir(), key[:2], key+"."+format)
+	if opts.cached() {
+		if b, err := ioutil.ReadFile(path); err == nil {
+			return b, key, nil
+		}
+	}
+
+	renderer := cardRenderers[format]
+	layout := layoutCard(quote, opts, renderer.Convert)
+	layout.Id = "wisdom-card-" + key[:12]
+	b, err := renderer.Render(layout)
+	if err != nil {
+		return nil, "", err
+	}
+	if !opts.cached() {
+		return b, key, nil
+	}
+	// write to a temporary file and rename, so readers never see a partial
+	// card
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("renderCard.MkdirAll: %s", err)
+		return b, key, nil
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), key+".tmp")
+	if err != nil {
+		log.Printf("renderCard.TempFile: %s", err)
+		return b, key, nil
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		log.Printf("renderCard.Write: %s", err)
+		os.Remove(tmp.Name())
+	}
+	return b, key, nil
+}
+
+// cardFormat return the format of request path extension
+func cardFormat(r *http.Request) string {
+	return strings.TrimPrefix(filepath.Ext(r.URL.Path), ".")
+}
+
+// writeCard render and write the card of quote
+func writeCard(w http.ResponseWriter, r *http.Request, quote *Quote, cacheable bool, tag string) *apiError {
+	opts, err := cardOptionsFromRequest(r)
+	if err != nil {
+		return invalidParameter(tag+".cardOptionsFromRequest", err)
+	}
+	format := cardFormat(r)
+	b, key, err := renderCard(quote, opts, format)
+	if err != nil {
+		return &apiError{
+			tag + ".renderCard",
+			err,
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+
+	w.Header().Set("Content-Type", cardRenderers[format].ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if format == "svg" {
+		// opened directly, the SVG can't run scripts or load anything
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	}
+	if !cacheable {
+		// random card, link the card of the quote
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Location", "/v1/quotes/"+strconv.Itoa(quote.Id)+"/card."+format+"?"+opts.query())
+	} else {
+		etag := `"` + key + `"`
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+	return nil
+}
+
+// /v1/quotes/{id}/card.png endpoint. card of a quote
+func quoteCardHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	quote, err := dbUtils.QuoteById(id)
+	if err != nil {
+		return databaseError("quoteCardHandler.QuoteById", err)
+	}
+	return writeCard(w, r, quote, true, "quoteCardHandler")
+}
+
+// /v1/random/card.png endpoint. card of a random quote, optionally with
+// `tag` and/or by `author`
+func randomCardHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	tag_label, twitter_username, apiErr := quoteFilters(r, dbUtils, "randomCardHandler")
+	if apiErr != nil {
+		return apiErr
+	}
+	quote, err := dbUtils.RandomQuoteFiltered(tag_label, twitter_username)
+	if err != nil {
+		return databaseError("randomCardHandler.RandomQuoteFiltered", err)
+	}
+	return writeCard(w, r, quote, false, "randomCardHandler")
+}
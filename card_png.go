@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/png"
+)
+
+// renderCardPNG draw a card with the built-in font
+func renderCardPNG(layout *cardLayout) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, layout.Width, layout.Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(layout.Background), image.Point{}, draw.Src)
+	for _, text := range layout.Texts {
+		drawText(img, text)
+	}
+	var buf bytes.Buffer
+	encoder := &png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawText draw every font pixel of text as a square
+func drawText(img *image.RGBA, text cardText) {
+	src := image.NewUniform(text.Color)
+	x := text.X
+	for i := 0; i < len(text.Text); i++ {
+		glyph := fontGlyph(text.Text[i])
+		for col, bits := range glyph {
+			for row := 0; row < fontGlyphHeight; row++ {
+				if bits&(1<<uint(row)) == 0 {
+					continue
+				}
+				px := x + col*text.Scale
+				py := text.Y + row*text.Scale
+				draw.Draw(img, image.Rect(px, py, px+text.Scale, py+text.Scale), src, image.Point{}, draw.Src)
+			}
+		}
+		x += fontCellWidth * text.Scale
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+)
+
+// font of SVG cards when the request has no `font`
+const cardDefaultFont = "Georgia, 'Times New Roman', serif"
+
+// svgColor return the #rrggbb notation of c
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// svgEscape return text escaped for XML text and attribute values
+func svgEscape(text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
+
+// renderCardSVG write a card as SVG text. The SVG has no script, style
+// sheet or external reference, so it is safe to inline in HTML. Element
+// ids are prefixed by the card id so several cards can be inlined in the
+// same page
+func renderCardSVG(layout *cardLayout) ([]byte, error) {
+	font := layout.Font
+	if font == "" {
+		font = cardDefaultFont
+	}
+	title := layout.Id + "-title"
+	desc := layout.Id + "-desc"
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-labelledby="%s %s">`,
+		layout.Width, layout.Height, layout.Width, layout.Height, title, desc)
+	fmt.Fprintf(&buf, `<title id="%s">%s</title>`, title, svgEscape(layout.Title))
+	fmt.Fprintf(&buf, `<desc id="%s">%s</desc>`, desc, svgEscape(layout.Description))
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/>`, svgColor(layout.Background))
+	fmt.Fprintf(&buf, `<g font-family="%s" aria-hidden="true">`, svgEscape(font))
+	for _, text := range layout.Texts {
+		// texts are laid out with the metrics of the built-in font, the
+		// baseline is below the 7th row of glyphs
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" font-size="%d" fill="%s" xml:space="preserve">%s</text>`,
+			text.X, text.Y+(fontGlyphHeight-2)*text.Scale, (fontCellHeight-1)*text.Scale, svgColor(text.Color), svgEscape(text.Text))
+	}
+	buf.WriteString("</g></svg>\n")
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,79 @@
+// slack-replay send recorded Slack payloads to the wisdom Slack endpoints.
+// Every payload is signed with SLACK_SIGNING_SECRET like Slack does, and
+// the response is printed.
+//
+//	SLACK_SIGNING_SECRET=... go run ./cmd/slack-replay -url http://localhost:8080/slack/commands data/slack/command.form
+//	SLACK_SIGNING_SECRET=... go run ./cmd/slack-replay -url http://localhost:8080/slack/interactions data/slack/interaction.form
+//
+// Use -bad-signature to check requests with an invalid signature are
+// rejected.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	SLACK_SIGNING_SECRET = os.Getenv("SLACK_SIGNING_SECRET")
+)
+
+// signRequest return X-Slack-Signature header value of body sent at
+// timestamp. HMAC is computed over `v0:<timestamp>:<body>`
+func signRequest(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func main() {
+	target := flag.String("url", "http://localhost:8080/slack/commands", "endpoint URL")
+	bad := flag.Bool("bad-signature", false, "send an invalid signature")
+	flag.Parse()
+	if SLACK_SIGNING_SECRET == "" {
+		log.Fatal("SLACK_SIGNING_SECRET is required")
+	}
+	if flag.NArg() == 0 {
+		log.Fatal("usage: slack-replay [-url URL] [-bad-signature] payload.form...")
+	}
+
+	for _, path := range flag.Args() {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// recorded payloads are saved with a trailing newline
+		body = bytes.TrimRight(body, "\r\n")
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		signature := signRequest(SLACK_SIGNING_SECRET, timestamp, body)
+		if *bad {
+			signature = signRequest(SLACK_SIGNING_SECRET+"x", timestamp, body)
+		}
+
+		req, err := http.NewRequest("POST", *target, bytes.NewReader(body))
+		if err != nil {
+			log.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		req.Header.Set("X-Slack-Signature", signature)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatal(err)
+		}
+		response, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		fmt.Printf("%s: %s\n%s\n", path, resp.Status, response)
+	}
+}
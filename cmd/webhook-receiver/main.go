@@ -0,0 +1,99 @@
+// webhook-receiver is a local receiver to test wisdom webhooks. It verify
+// the X-Wisdom-Signature header of every delivery and log the payload.
+//
+//	WEBHOOK_SECRET=whsec_... go run ./cmd/webhook-receiver
+//
+// Deliveries with an invalid signature are responded with 401, so they are
+// retried and end in the dead-letter after repeated failures.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	WEBHOOK_SECRET = os.Getenv("WEBHOOK_SECRET")
+)
+
+// deliveries signed longer ago are rejected to prevent replay
+const signatureTolerance = 5 * time.Minute
+
+// verifySignature check `t=<unix timestamp>,v1=<hex HMAC-SHA256>` header
+// value. HMAC is computed over `<timestamp>.<body>`
+func verifySignature(secret, header string, body []byte, now time.Time) error {
+	var timestamp int64
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			if signature, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return errors.New("malformed signature header")
+	}
+	signed := time.Unix(timestamp, 0)
+	if now.Sub(signed) > signatureTolerance || signed.Sub(now) > signatureTolerance {
+		return errors.New("signature timestamp out of tolerance")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if hmac.Equal(signature, expected) {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+func main() {
+	addr := flag.String("addr", ":8081", "listen address")
+	flag.Parse()
+	if WEBHOOK_SECRET == "" {
+		log.Fatal("WEBHOOK_SECRET is required")
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "can't read body", http.StatusBadRequest)
+			return
+		}
+		delivery := r.Header.Get("X-Wisdom-Delivery")
+		event := r.Header.Get("X-Wisdom-Event")
+		if err := verifySignature(WEBHOOK_SECRET, r.Header.Get("X-Wisdom-Signature"), body, time.Now()); err != nil {
+			log.Printf("delivery %s %s rejected: %s", delivery, event, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		log.Printf("delivery %s %s verified: %s", delivery, event, body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Printf("Listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
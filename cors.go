@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// comma separated list of allowed origins, `*` allow any origin.
+	// CORS disabled if empty
+	CORS_ALLOWED_ORIGINS = os.Getenv("CORS_ALLOWED_ORIGINS")
+	// comma separated list of allowed methods
+	CORS_ALLOWED_METHODS = os.Getenv("CORS_ALLOWED_METHODS")
+	// comma separated list of allowed request headers
+	CORS_ALLOWED_HEADERS = os.Getenv("CORS_ALLOWED_HEADERS")
+	// how long (in seconds) preflight response can be cached
+	CORS_MAX_AGE = os.Getenv("CORS_MAX_AGE")
+)
+
+const (
+	corsDefaultMethods = "GET, HEAD, OPTIONS"
+	corsDefaultHeaders = "Accept, Content-Type"
+	corsDefaultMaxAge  = 86400
+)
+
+// corsConfig represent CORS configuration
+type corsConfig struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	MaxAge         int
+}
+
+// cors is CORS configuration loaded from environment variables
+var cors = newCorsConfig(CORS_ALLOWED_ORIGINS, CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS, CORS_MAX_AGE)
+
+// splitList split comma separated list and trim every item
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// newCorsConfig create CORS configuration, empty values replaced by default
+func newCorsConfig(origins, methods, headers, maxAge string) *corsConfig {
+	if methods == "" {
+		methods = corsDefaultMethods
+	}
+	if headers == "" {
+		headers = corsDefaultHeaders
+	}
+	age, err := strconv.Atoi(maxAge)
+	if err != nil || age < 0 {
+		age = corsDefaultMaxAge
+	}
+	return &corsConfig{
+		AllowedOrigins: splitList(origins),
+		AllowedMethods: splitList(methods),
+		AllowedHeaders: splitList(headers),
+		MaxAge:         age,
+	}
+}
+
+// enabled return true if at least one origin is allowed
+func (c *corsConfig) enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// allowOrigin return value for Access-Control-Allow-Origin header, empty
+// string if origin is not allowed
+func (c *corsConfig) allowOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// allowMethod check method is in allowed methods
+func (c *corsConfig) allowMethod(method string) bool {
+	for _, allowed := range c.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders check every requested headers are in allowed headers
+func (c *corsConfig) allowHeaders(headers string) bool {
+	for _, header := range splitList(headers) {
+		found := false
+		for _, allowed := range c.AllowedHeaders {
+			if strings.EqualFold(allowed, header) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// isPreflight check the request is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == "OPTIONS" &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// handle add CORS headers to the response. It return true if request is a
+// preflight request and response already written
+func (c *corsConfig) handle(w http.ResponseWriter, r *http.Request) bool {
+	if !c.enabled() {
+		return false
+	}
+
+	// response vary by origin, so caches don't serve response for one
+	// origin to another
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	allowOrigin := c.allowOrigin(origin)
+
+	if !isPreflight(r) {
+		if allowOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		}
+		return false
+	}
+
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	if allowOrigin == "" ||
+		!c.allowMethod(r.Header.Get("Access-Control-Request-Method")) ||
+		!c.allowHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
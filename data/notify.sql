@@ -0,0 +1,46 @@
+-- Notify wisdom_changes channel on every change of quotes, authors and tags.
+-- Payload is a JSON object, e.g. {"table":"quotes","action":"INSERT","id":13}
+-- Changes of quotes_tags are notified as an UPDATE of the quote.
+CREATE OR REPLACE FUNCTION wisdom_notify_change() RETURNS trigger AS $$
+DECLARE
+    row_id integer;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        row_id := OLD.id;
+    ELSE
+        row_id := NEW.id;
+    END IF;
+    PERFORM pg_notify('wisdom_changes', json_build_object('table', TG_TABLE_NAME, 'action', TG_OP, 'id', row_id)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION wisdom_notify_quotes_tags_change() RETURNS trigger AS $$
+DECLARE
+    row_id integer;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        row_id := OLD.quote_id;
+    ELSE
+        row_id := NEW.quote_id;
+    END IF;
+    PERFORM pg_notify('wisdom_changes', json_build_object('table', 'quotes', 'action', 'UPDATE', 'id', row_id)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS quotes_notify_change ON quotes;
+CREATE TRIGGER quotes_notify_change AFTER INSERT OR UPDATE OR DELETE ON quotes
+    FOR EACH ROW EXECUTE PROCEDURE wisdom_notify_change();
+
+DROP TRIGGER IF EXISTS authors_notify_change ON authors;
+CREATE TRIGGER authors_notify_change AFTER INSERT OR UPDATE OR DELETE ON authors
+    FOR EACH ROW EXECUTE PROCEDURE wisdom_notify_change();
+
+DROP TRIGGER IF EXISTS tags_notify_change ON tags;
+CREATE TRIGGER tags_notify_change AFTER INSERT OR UPDATE OR DELETE ON tags
+    FOR EACH ROW EXECUTE PROCEDURE wisdom_notify_change();
+
+DROP TRIGGER IF EXISTS quotes_tags_notify_change ON quotes_tags;
+CREATE TRIGGER quotes_tags_notify_change AFTER INSERT OR UPDATE OR DELETE ON quotes_tags
+    FOR EACH ROW EXECUTE PROCEDURE wisdom_notify_quotes_tags_change();
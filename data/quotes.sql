@@ -5,5 +5,6 @@ CREATE TABLE IF NOT EXISTS quotes (
     post_id text UNIQUE NOT NULL,
     content text NOT NULL,
     permalink text NOT NULL,
-    picture_url text NOT NULL
+    picture_url text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
 );
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- Creation time of quotes, used by feeds. Existing quotes get the time of
+-- the migration.
+ALTER TABLE quotes ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS quotes_created_at ON quotes (created_at DESC, id DESC);
@@ -0,0 +1,11 @@
+-- Short links of quotes and filtered random queries. code is derived from
+-- the target, so the same target always get the same code.
+CREATE TABLE IF NOT EXISTS short_links (
+    code text PRIMARY KEY,
+    quote_id integer REFERENCES quotes(id) ON DELETE CASCADE,
+    tag_label text NOT NULL DEFAULT '',
+    twitter_username text NOT NULL DEFAULT '',
+    clicks bigint NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    last_clicked_at timestamptz
+);
@@ -0,0 +1,127 @@
+-- Webhook subscriptions. events is a comma separated list of event names,
+-- e.g. 'quotes.created,authors.*' or '*' for every event.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text NOT NULL DEFAULT '*',
+    active boolean NOT NULL DEFAULT true,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Delivery log. status is pending, delivered or dead. Pending deliveries
+-- are retried at next_attempt_at, dead deliveries are kept until retried
+-- through the API.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    webhook_id integer NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event text NOT NULL,
+    payload text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    response_code integer,
+    error text,
+    next_attempt_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    delivered_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);
+
+-- Changes to deliver, queued by triggers so every change is queued once
+-- whatever the number of instances. Instances claim events with
+-- FOR UPDATE SKIP LOCKED, create their deliveries and delete them in the
+-- same transaction. Changes are only queued while a webhook is active.
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id BIGSERIAL PRIMARY KEY,
+    table_name text NOT NULL,
+    action text NOT NULL,
+    row_id integer NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Deliveries of a change event, a webhook get at most one delivery per
+-- event. Deliveries without event are pings
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS event_id bigint;
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_id ON webhook_deliveries (webhook_id, event_id);
+
+-- Object of a change as the API respond it, snapshotted when the change is
+-- queued so deliveries don't depend on later changes. Deleted objects have
+-- no data, their payload only has their id
+ALTER TABLE webhook_events ADD COLUMN IF NOT EXISTS data json;
+
+CREATE OR REPLACE FUNCTION wisdom_webhook_event_data(event_table text, event_row_id integer) RETURNS json AS $$
+    SELECT CASE event_table
+    WHEN 'quotes' THEN (
+        SELECT json_build_object(
+            'id', quotes.id,
+            'post_id', quotes.post_id,
+            'author', (SELECT json_build_object('id', authors.id, 'avatar_url', COALESCE(authors.avatar_url, ''),
+                'name', authors.name, 'company', COALESCE(authors.company_name, ''),
+                'twitter_username', COALESCE(authors.twitter_username, ''))
+                FROM authors WHERE authors.id = quotes.author_id),
+            'content', quotes.content,
+            'permalink', quotes.permalink,
+            'picture_url', quotes.picture_url,
+            'tags', (SELECT json_agg(json_build_object('id', tags.id, 'label', tags.label) ORDER BY tags.id)
+                FROM quotes_tags JOIN tags ON tags.id = quotes_tags.tag_id WHERE quotes_tags.quote_id = quotes.id))
+        FROM quotes WHERE quotes.id = event_row_id)
+    WHEN 'authors' THEN (
+        SELECT json_build_object('id', authors.id, 'avatar_url', COALESCE(authors.avatar_url, ''),
+            'name', authors.name, 'company', COALESCE(authors.company_name, ''),
+            'twitter_username', COALESCE(authors.twitter_username, ''))
+        FROM authors WHERE authors.id = event_row_id)
+    WHEN 'tags' THEN (
+        SELECT json_build_object('id', tags.id, 'label', tags.label)
+        FROM tags WHERE tags.id = event_row_id)
+    END
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION wisdom_queue_webhook_event() RETURNS trigger AS $$
+DECLARE
+    event_table text := TG_TABLE_NAME;
+    event_action text := TG_OP;
+    event_row_id integer;
+    event_data json;
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM webhooks WHERE active) THEN
+        RETURN NULL;
+    END IF;
+    -- changes of quotes_tags are an UPDATE of the quote
+    IF TG_TABLE_NAME = 'quotes_tags' THEN
+        event_table := 'quotes';
+        event_action := 'UPDATE';
+        IF TG_OP = 'DELETE' THEN
+            event_row_id := OLD.quote_id;
+        ELSE
+            event_row_id := NEW.quote_id;
+        END IF;
+    ELSIF TG_OP = 'DELETE' THEN
+        event_row_id := OLD.id;
+    ELSE
+        event_row_id := NEW.id;
+    END IF;
+    IF event_action <> 'DELETE' THEN
+        event_data := wisdom_webhook_event_data(event_table, event_row_id);
+    END IF;
+    INSERT INTO webhook_events (table_name, action, row_id, data) VALUES (event_table, event_action, event_row_id, event_data);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS quotes_queue_webhook_event ON quotes;
+CREATE TRIGGER quotes_queue_webhook_event AFTER INSERT OR UPDATE OR DELETE ON quotes
+    FOR EACH ROW EXECUTE PROCEDURE wisdom_queue_webhook_event();
+
+DROP TRIGGER IF EXISTS authors_queue_webhook_event ON authors;
+CREATE TRIGGER authors_queue_webhook_event AFTER INSERT OR UPDATE OR DELETE ON authors
+    FOR EACH ROW EXECUTE PROCEDURE wisdom_queue_webhook_event();
+
+DROP TRIGGER IF EXISTS tags_queue_webhook_event ON tags;
+CREATE TRIGGER tags_queue_webhook_event AFTER INSERT OR UPDATE OR DELETE ON tags
+    FOR EACH ROW EXECUTE PROCEDURE wisdom_queue_webhook_event();
+
+DROP TRIGGER IF EXISTS quotes_tags_queue_webhook_event ON quotes_tags;
+CREATE TRIGGER quotes_tags_queue_webhook_event AFTER INSERT OR UPDATE OR DELETE ON quotes_tags
+    FOR EACH ROW EXECUTE PROCEDURE wisdom_queue_webhook_event();
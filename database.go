@@ -0,0 +1,964 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errQuoteNotFound  = errors.New("quote not found")
+	errAuthorNotFound = errors.New("author not found")
+	errTagNotFound    = errors.New("tag not found")
+
+	errWebhookNotFound  = errors.New("webhook not found")
+	errDeliveryNotFound = errors.New("webhook delivery not found")
+
+	errShortLinkNotFound = errors.New("short link not found")
+)
+
+const (
+	quoteColumns  = "id, author_id, post_id, content, permalink, picture_url"
+	authorColumns = "id, avatar_url, name, company_name, twitter_username"
+	tagColumns    = "id, label"
+
+	// quotes with tag label $1, every quotes when $1 is empty
+	quoteTagFilter = "($1 = '' OR EXISTS (SELECT 1 FROM quotes_tags JOIN tags ON tags.id = quotes_tags.tag_id " +
+		"WHERE quotes_tags.quote_id = quotes.id AND tags.label = $1))"
+
+	webhookColumns  = "id, url, secret, events, active, created_at"
+	deliveryColumns = "id, webhook_id, event, payload, status, attempts, response_code, error, next_attempt_at, created_at, delivered_at"
+
+	shortLinkColumns = "code, quote_id, tag_label, twitter_username, clicks, created_at, last_clicked_at"
+)
+
+// DatabaseUtils represent database utility that used by handler
+type DatabaseUtils struct {
+	DB                               *sql.DB
+	StatementRandom                  *sql.Stmt
+	StatementAuthorById              *sql.Stmt
+	StatementTagIdsByQuoteId         *sql.Stmt
+	StatementTagById                 *sql.Stmt
+	StatementAuthors                 *sql.Stmt
+	StatementAuthorByTwitterUsername *sql.Stmt
+	StatementQuotesByAuthorId        *sql.Stmt
+	StatementTags                    *sql.Stmt
+	StatementQuoteById               *sql.Stmt
+	StatementQuotesPage              *sql.Stmt
+	StatementQuotesCount             *sql.Stmt
+	StatementAuthorsPage             *sql.Stmt
+	StatementAuthorsCount            *sql.Stmt
+	StatementTagsPage                *sql.Stmt
+	StatementTagsCount               *sql.Stmt
+	StatementQuotesByAuthorIdPage    *sql.Stmt
+	StatementQuotesByAuthorIdCount   *sql.Stmt
+	StatementRandomFiltered          *sql.Stmt
+	StatementSearchQuotes            *sql.Stmt
+	StatementTagByLabel              *sql.Stmt
+	StatementQuotesCountByTag        *sql.Stmt
+	StatementQuoteByPermalink        *sql.Stmt
+}
+
+// NewDatabaseUtils prepare every statement used by handlers
+func NewDatabaseUtils(db *sql.DB) (*DatabaseUtils, error) {
+	dbUtils := &DatabaseUtils{DB: db}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&dbUtils.StatementRandom, "SELECT " + quoteColumns + " FROM quotes ORDER BY RANDOM() LIMIT 1"},
+		{&dbUtils.StatementAuthorById, "SELECT " + authorColumns + " FROM authors WHERE id = $1"},
+		{&dbUtils.StatementTagIdsByQuoteId, "SELECT tag_id FROM quotes_tags WHERE quote_id = $1"},
+		{&dbUtils.StatementTagById, "SELECT " + tagColumns + " FROM tags WHERE id = $1"},
+		{&dbUtils.StatementAuthors, "SELECT " + authorColumns + " FROM authors ORDER BY id"},
+		{&dbUtils.StatementAuthorByTwitterUsername, "SELECT " + authorColumns + " FROM authors WHERE twitter_username = $1"},
+		{&dbUtils.StatementQuotesByAuthorId, "SELECT " + quoteColumns + " FROM quotes WHERE author_id = $1 ORDER BY id"},
+		{&dbUtils.StatementTags, "SELECT " + tagColumns + " FROM tags ORDER BY id"},
+		{&dbUtils.StatementQuoteById, "SELECT " + quoteColumns + " FROM quotes WHERE id = $1"},
+		{&dbUtils.StatementQuotesPage, "SELECT " + quoteColumns + " FROM quotes ORDER BY id LIMIT $1 OFFSET $2"},
+		{&dbUtils.StatementQuotesCount, "SELECT COUNT(*) FROM quotes"},
+		{&dbUtils.StatementAuthorsPage, "SELECT " + authorColumns + " FROM authors ORDER BY id LIMIT $1 OFFSET $2"},
+		{&dbUtils.StatementAuthorsCount, "SELECT COUNT(*) FROM authors"},
+		{&dbUtils.StatementTagsPage, "SELECT " + tagColumns + " FROM tags ORDER BY id LIMIT $1 OFFSET $2"},
+		{&dbUtils.StatementTagsCount, "SELECT COUNT(*) FROM tags"},
+		{&dbUtils.StatementQuotesByAuthorIdPage, "SELECT " + quoteColumns + " FROM quotes WHERE author_id = $1 ORDER BY id LIMIT $2 OFFSET $3"},
+		{&dbUtils.StatementQuotesByAuthorIdCount, "SELECT COUNT(*) FROM quotes WHERE author_id = $1"},
+		{&dbUtils.StatementRandomFiltered, "SELECT " + prefixColumns("quotes", quoteColumns) + " FROM quotes " +
+			"JOIN authors ON authors.id = quotes.author_id " +
+			"WHERE ($1 = '' OR EXISTS (SELECT 1 FROM quotes_tags JOIN tags ON tags.id = quotes_tags.tag_id WHERE quotes_tags.quote_id = quotes.id AND tags.label = $1)) " +
+			"AND ($2 = '' OR authors.twitter_username = $2) " +
+			"ORDER BY RANDOM() LIMIT 1"},
+		{&dbUtils.StatementSearchQuotes, "SELECT " + prefixColumns("quotes", quoteColumns) + " FROM quotes " +
+			"JOIN authors ON authors.id = quotes.author_id " +
+			"WHERE quotes.content ILIKE $1 OR authors.name ILIKE $1 " +
+			"ORDER BY quotes.id LIMIT $2 OFFSET $3"},
+		{&dbUtils.StatementTagByLabel, "SELECT " + tagColumns + " FROM tags WHERE label = $1"},
+		{&dbUtils.StatementQuotesCountByTag, "SELECT COUNT(*) FROM quotes WHERE " + quoteTagFilter},
+		{&dbUtils.StatementQuoteByPermalink, "SELECT " + quoteColumns + " FROM quotes WHERE permalink = $1"},
+	}
+	for _, s := range statements {
+		stmt, err := db.Prepare(s.query)
+		if err != nil {
+			return nil, err
+		}
+		*s.stmt = stmt
+	}
+	return dbUtils, nil
+}
+
+// prefixColumns prefix every column with table name
+func prefixColumns(table, columns string) string {
+	prefixed := strings.Split(columns, ", ")
+	for i, column := range prefixed {
+		prefixed[i] = table + "." + column
+	}
+	return strings.Join(prefixed, ", ")
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanQuote scan a quote row. author & tags are filled by fillQuotes
+func scanQuote(row scanner) (*Quote, int, error) {
+	var quote_id, quote_author_id int
+	var post_id, content, permalink, picture_url string
+	err := row.Scan(&quote_id, &quote_author_id, &post_id, &content, &permalink, &picture_url)
+	if err != nil {
+		return nil, 0, err
+	}
+	quote := &Quote{
+		Id:         quote_id,
+		PostId:     post_id,
+		Content:    content,
+		Permalink:  permalink,
+		PictureUrl: picture_url,
+	}
+	return quote, quote_author_id, nil
+}
+
+// scanAuthor scan an author row, NULL columns become empty string
+func scanAuthor(row scanner) (Author, error) {
+	var author Author
+	var author_id int
+	var avatar_url, name, company_name, twitter_username sql.NullString
+	err := row.Scan(&author_id, &avatar_url, &name, &company_name, &twitter_username)
+	if err != nil {
+		return author, err
+	}
+	author.Id = author_id
+	author.Name = name.String
+	author.AvatarUrl = avatar_url.String
+	author.Company = company_name.String
+	author.Twitter = twitter_username.String
+	return author, nil
+}
+
+// scanTag scan a tag row
+func scanTag(row scanner) (Tag, error) {
+	var tag Tag
+	err := row.Scan(&tag.Id, &tag.Label)
+	return tag, err
+}
+
+// queryQuotes run a quotes query and fill author & tags of every quote
+func (d *DatabaseUtils) queryQuotes(stmt *sql.Stmt, args ...interface{}) ([]*Quote, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quotes []*Quote
+	var author_ids []int
+	for rows.Next() {
+		quote, author_id, err := scanQuote(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, quote)
+		author_ids = append(author_ids, author_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return quotes, d.fillQuotes(quotes, author_ids)
+}
+
+// queryQuote run a single quote query and fill its author & tags
+func (d *DatabaseUtils) queryQuote(stmt *sql.Stmt, args ...interface{}) (*Quote, error) {
+	quote, author_id, err := scanQuote(stmt.QueryRow(args...))
+	if err == sql.ErrNoRows {
+		return nil, errQuoteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return quote, d.fillQuotes([]*Quote{quote}, []int{author_id})
+}
+
+// fillQuotes load authors and tags of quotes in two queries, author_ids[i]
+// is the author id of quotes[i]
+func (d *DatabaseUtils) fillQuotes(quotes []*Quote, author_ids []int) error {
+	if len(quotes) == 0 {
+		return nil
+	}
+	authors, err := d.AuthorsByIds(author_ids)
+	if err != nil {
+		return err
+	}
+	quote_ids := make([]int, len(quotes))
+	for i, quote := range quotes {
+		quote_ids[i] = quote.Id
+	}
+	tags, err := d.TagsByQuoteIds(quote_ids)
+	if err != nil {
+		return err
+	}
+	for i, quote := range quotes {
+		author, ok := authors[author_ids[i]]
+		if !ok {
+			return errAuthorNotFound
+		}
+		quote.Author = author
+		quote.Tags = tags[quote.Id]
+	}
+	return nil
+}
+
+// placeholders return `$1, $2, ...` and its arguments for an IN clause
+func placeholders(ids []int) (string, []interface{}) {
+	return placeholdersFrom(ids, 1)
+}
+
+// placeholdersFrom return `$first, $first+1, ...` and its arguments for an
+// IN clause following other parameters. Duplicate ids are passed once, so
+// batches of objects sharing ids stay under the parameters limit
+func placeholdersFrom(ids []int, first int) (string, []interface{}) {
+	var params []string
+	var args []interface{}
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		params = append(params, "$"+strconv.Itoa(first+len(args)))
+		args = append(args, id)
+	}
+	return strings.Join(params, ", "), args
+}
+
+// intArray return a Postgres array literal of ids, e.g. `{1,2,3}`
+func intArray(ids []int) string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strconv.Itoa(id)
+	}
+	return "{" + strings.Join(values, ",") + "}"
+}
+
+// AuthorsByIds return authors indexed by id in a single query
+func (d *DatabaseUtils) AuthorsByIds(ids []int) (map[int]Author, error) {
+	authors := make(map[int]Author)
+	if len(ids) == 0 {
+		return authors, nil
+	}
+	params, args := placeholders(ids)
+	rows, err := d.DB.Query("SELECT "+authorColumns+" FROM authors WHERE id IN ("+params+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		author, err := scanAuthor(rows)
+		if err != nil {
+			return nil, err
+		}
+		authors[author.Id] = author
+	}
+	return authors, rows.Err()
+}
+
+// TagsByQuoteIds return tags indexed by quote id in a single query
+func (d *DatabaseUtils) TagsByQuoteIds(ids []int) (map[int][]Tag, error) {
+	tags := make(map[int][]Tag)
+	if len(ids) == 0 {
+		return tags, nil
+	}
+	params, args := placeholders(ids)
+	rows, err := d.DB.Query("SELECT quotes_tags.quote_id, tags.id, tags.label FROM quotes_tags "+
+		"JOIN tags ON tags.id = quotes_tags.tag_id "+
+		"WHERE quotes_tags.quote_id IN ("+params+") ORDER BY tags.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var quote_id int
+		var tag Tag
+		if err := rows.Scan(&quote_id, &tag.Id, &tag.Label); err != nil {
+			return nil, err
+		}
+		tags[quote_id] = append(tags[quote_id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// RandomQuote return a random quote
+func (d *DatabaseUtils) RandomQuote() (*Quote, error) {
+	return d.queryQuote(d.StatementRandom)
+}
+
+// RandomQuoteFiltered return a random quote with given tag label and/or by
+// author with given twitter username. Empty filter is ignored
+func (d *DatabaseUtils) RandomQuoteFiltered(tag_label, twitter_username string) (*Quote, error) {
+	return d.queryQuote(d.StatementRandomFiltered, tag_label, twitter_username)
+}
+
+// QuoteById return a quote by its id
+func (d *DatabaseUtils) QuoteById(id int) (*Quote, error) {
+	return d.queryQuote(d.StatementQuoteById, id)
+}
+
+// QuoteByPermalink return a quote by its Tumblr permalink
+func (d *DatabaseUtils) QuoteByPermalink(permalink string) (*Quote, error) {
+	return d.queryQuote(d.StatementQuoteByPermalink, permalink)
+}
+
+// Quotes return a page of quotes and total number of quotes
+func (d *DatabaseUtils) Quotes(limit, offset int) ([]*Quote, int, error) {
+	var total int
+	if err := d.StatementQuotesCount.QueryRow().Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	quotes, err := d.queryQuotes(d.StatementQuotesPage, limit, offset)
+	return quotes, total, err
+}
+
+// QuotesOfTheDays return the quote of every day, with given tag label if
+// not empty, with the same queries whatever the number of days. The quote is
+// picked by a hash of the date so it is the same for every request of the
+// day, it only change when quotes are added or removed
+func (d *DatabaseUtils) QuotesOfTheDays(days []time.Time, tag_label string) ([]*Quote, error) {
+	var total int
+	if err := d.StatementQuotesCountByTag.QueryRow(tag_label).Scan(&total); err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, errQuoteNotFound
+	}
+	positions := make([]int, len(days))
+	for i, day := range days {
+		h := fnv.New64a()
+		h.Write([]byte(day.Format("2006-01-02")))
+		positions[i] = int(h.Sum64() % uint64(total))
+	}
+	// position of quotes ordered by id, like an OFFSET
+	quotes, err := d.quotesByIds("SELECT position, "+quoteColumns+" FROM ("+
+		"SELECT "+quoteColumns+", ROW_NUMBER() OVER (ORDER BY id) - 1 AS position FROM quotes WHERE "+quoteTagFilter+
+		") AS numbered WHERE position IN ($IDS)", positions, tag_label)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Quote, len(days))
+	for i, position := range positions {
+		// quotes removed since they were counted
+		if len(quotes[position]) == 0 {
+			return nil, errQuoteNotFound
+		}
+		result[i] = quotes[position][0]
+	}
+	return result, nil
+}
+
+// NewestQuotes return newest quotes and their creation time, with given tag
+// label and/or by author with given twitter username. Empty filter is
+// ignored. created_at is added by data/quotes_created_at.sql, so the query
+// is not prepared
+func (d *DatabaseUtils) NewestQuotes(tag_label, twitter_username string, limit int) ([]*Quote, []time.Time, error) {
+	rows, err := d.DB.Query("SELECT quotes.created_at, "+prefixColumns("quotes", quoteColumns)+" FROM quotes "+
+		"JOIN authors ON authors.id = quotes.author_id "+
+		"WHERE ($1 = '' OR EXISTS (SELECT 1 FROM quotes_tags JOIN tags ON tags.id = quotes_tags.tag_id WHERE quotes_tags.quote_id = quotes.id AND tags.label = $1)) "+
+		"AND ($2 = '' OR authors.twitter_username = $2) "+
+		"ORDER BY quotes.created_at DESC, quotes.id DESC LIMIT $3", tag_label, twitter_username, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var quotes []*Quote
+	var author_ids []int
+	var created []time.Time
+	for rows.Next() {
+		var created_at time.Time
+		var quote_author_id int
+		quote := &Quote{}
+		err := rows.Scan(&created_at, &quote.Id, &quote_author_id, &quote.PostId, &quote.Content, &quote.Permalink, &quote.PictureUrl)
+		if err != nil {
+			return nil, nil, err
+		}
+		quotes = append(quotes, quote)
+		author_ids = append(author_ids, quote_author_id)
+		created = append(created, created_at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return quotes, created, d.fillQuotes(quotes, author_ids)
+}
+
+// QuotesByAuthorId return every quotes by author
+func (d *DatabaseUtils) QuotesByAuthorId(author_id int) ([]*Quote, error) {
+	return d.queryQuotes(d.StatementQuotesByAuthorId, author_id)
+}
+
+// QuotesByTwitterUsername return every quotes by author with given twitter
+// username
+func (d *DatabaseUtils) QuotesByTwitterUsername(twitter_username string) ([]*Quote, error) {
+	author, err := d.AuthorByTwitterUsername(twitter_username)
+	if err != nil {
+		return nil, err
+	}
+	return d.QuotesByAuthorId(author.Id)
+}
+
+// QuotesByAuthorIdPage return a page of quotes by author and total number
+// of quotes by author
+func (d *DatabaseUtils) QuotesByAuthorIdPage(author_id, limit, offset int) ([]*Quote, int, error) {
+	var total int
+	if err := d.StatementQuotesByAuthorIdCount.QueryRow(author_id).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	quotes, err := d.queryQuotes(d.StatementQuotesByAuthorIdPage, author_id, limit, offset)
+	return quotes, total, err
+}
+
+// SearchQuotes return quotes which content or author name contains query
+func (d *DatabaseUtils) SearchQuotes(query string, limit, offset int) ([]*Quote, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	return d.queryQuotes(d.StatementSearchQuotes, pattern, limit, offset)
+}
+
+// escape LIKE wildcards, backslash is the default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// quotesByIds run a quotes query that select `group_id` column followed by
+// quote columns for every id in ids, and return quotes grouped by group_id.
+// params are the first parameters of the query, followed by ids
+func (d *DatabaseUtils) quotesByIds(query string, ids []int, params ...interface{}) (map[int][]*Quote, error) {
+	grouped := make(map[int][]*Quote)
+	if len(ids) == 0 {
+		return grouped, nil
+	}
+	id_params, args := placeholdersFrom(ids, len(params)+1)
+	rows, err := d.DB.Query(strings.Replace(query, "$IDS", id_params, 1), append(params, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quotes []*Quote
+	var author_ids, group_ids []int
+	for rows.Next() {
+		var group_id, quote_author_id int
+		quote := &Quote{}
+		err := rows.Scan(&group_id, &quote.Id, &quote_author_id, &quote.PostId, &quote.Content, &quote.Permalink, &quote.PictureUrl)
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, quote)
+		author_ids = append(author_ids, quote_author_id)
+		group_ids = append(group_ids, group_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := d.fillQuotes(quotes, author_ids); err != nil {
+		return nil, err
+	}
+	for i, quote := range quotes {
+		grouped[group_ids[i]] = append(grouped[group_ids[i]], quote)
+	}
+	return grouped, nil
+}
+
+// QuotesByAuthorIds return quotes indexed by author id
+func (d *DatabaseUtils) QuotesByAuthorIds(ids []int) (map[int][]*Quote, error) {
+	return d.quotesByIds("SELECT quotes.author_id, "+prefixColumns("quotes", quoteColumns)+" FROM quotes "+
+		"WHERE quotes.author_id IN ($IDS) ORDER BY quotes.id", ids)
+}
+
+// QuotesByTagIds return quotes indexed by tag id
+func (d *DatabaseUtils) QuotesByTagIds(ids []int) (map[int][]*Quote, error) {
+	return d.quotesByIds("SELECT quotes_tags.tag_id, "+prefixColumns("quotes", quoteColumns)+" FROM quotes "+
+		"JOIN quotes_tags ON quotes_tags.quote_id = quotes.id "+
+		"WHERE quotes_tags.tag_id IN ($IDS) ORDER BY quotes.id", ids)
+}
+
+// QuotesPage define a page of the quotes of every author or tag
+type QuotesPage struct {
+	Limit   int
+	Offset  int
+	Random  bool
+	Exclude []int
+}
+
+// pagedQuotesQuery return a query selecting a page of quotes per group,
+// group is an expression of quote and joined tables
+func pagedQuotesQuery(group, from string, page QuotesPage) string {
+	order := "quotes.id"
+	if page.Random {
+		order = "RANDOM()"
+	}
+	return "SELECT group_id, " + quoteColumns + " FROM (" +
+		"SELECT " + group + " AS group_id, " + prefixColumns("quotes", quoteColumns) + ", " +
+		"ROW_NUMBER() OVER (PARTITION BY " + group + " ORDER BY " + order + ") AS position " +
+		"FROM " + from + " AND NOT quotes.id = ANY($3::int[])) AS paged " +
+		"WHERE position > $1 AND position <= $1 + $2 ORDER BY group_id, position"
+}
+
+// QuotesByAuthorIdsPage return a page of quotes of every author indexed by
+// author id, pages are selected by the database
+func (d *DatabaseUtils) QuotesByAuthorIdsPage(ids []int, page QuotesPage) (map[int][]*Quote, error) {
+	query := pagedQuotesQuery("quotes.author_id", "quotes WHERE quotes.author_id IN ($IDS)", page)
+	return d.quotesByIds(query, ids, page.Offset, page.Limit, intArray(page.Exclude))
+}
+
+// QuotesByTagIdsPage return a page of quotes of every tag indexed by tag
+// id, pages are selected by the database
+func (d *DatabaseUtils) QuotesByTagIdsPage(ids []int, page QuotesPage) (map[int][]*Quote, error) {
+	query := pagedQuotesQuery("quotes_tags.tag_id", "quotes JOIN quotes_tags ON quotes_tags.quote_id = quotes.id "+
+		"WHERE quotes_tags.tag_id IN ($IDS)", page)
+	return d.quotesByIds(query, ids, page.Offset, page.Limit, intArray(page.Exclude))
+}
+
+// TagsByAuthorIds return distinct tags of quotes by author indexed by author
+// id in a single query
+func (d *DatabaseUtils) TagsByAuthorIds(ids []int) (map[int][]Tag, error) {
+	tags := make(map[int][]Tag)
+	if len(ids) == 0 {
+		return tags, nil
+	}
+	params, args := placeholders(ids)
+	rows, err := d.DB.Query("SELECT DISTINCT quotes.author_id, tags.id, tags.label FROM quotes "+
+		"JOIN quotes_tags ON quotes_tags.quote_id = quotes.id "+
+		"JOIN tags ON tags.id = quotes_tags.tag_id "+
+		"WHERE quotes.author_id IN ("+params+") ORDER BY tags.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var author_id int
+		var tag Tag
+		if err := rows.Scan(&author_id, &tag.Id, &tag.Label); err != nil {
+			return nil, err
+		}
+		tags[author_id] = append(tags[author_id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// queryAuthors run an authors query
+func (d *DatabaseUtils) queryAuthors(stmt *sql.Stmt, args ...interface{}) ([]Author, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var authors []Author
+	for rows.Next() {
+		author, err := scanAuthor(rows)
+		if err != nil {
+			return nil, err
+		}
+		authors = append(authors, author)
+	}
+	return authors, rows.Err()
+}
+
+// Authors return every authors
+func (d *DatabaseUtils) Authors() ([]Author, error) {
+	return d.queryAuthors(d.StatementAuthors)
+}
+
+// AuthorsPage return a page of authors and total number of authors
+func (d *DatabaseUtils) AuthorsPage(limit, offset int) ([]Author, int, error) {
+	var total int
+	if err := d.StatementAuthorsCount.QueryRow().Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	authors, err := d.queryAuthors(d.StatementAuthorsPage, limit, offset)
+	return authors, total, err
+}
+
+// AuthorById return an author by its id
+func (d *DatabaseUtils) AuthorById(id int) (Author, error) {
+	author, err := scanAuthor(d.StatementAuthorById.QueryRow(id))
+	if err == sql.ErrNoRows {
+		return author, errAuthorNotFound
+	}
+	return author, err
+}
+
+// AuthorByTwitterUsername return an author by its twitter username
+func (d *DatabaseUtils) AuthorByTwitterUsername(twitter_username string) (Author, error) {
+	author, err := scanAuthor(d.StatementAuthorByTwitterUsername.QueryRow(twitter_username))
+	if err == sql.ErrNoRows {
+		return author, errAuthorNotFound
+	}
+	return author, err
+}
+
+// queryTags run a tags query
+func (d *DatabaseUtils) queryTags(stmt *sql.Stmt, args ...interface{}) ([]Tag, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tags []Tag
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// Tags return every tags
+func (d *DatabaseUtils) Tags() ([]Tag, error) {
+	return d.queryTags(d.StatementTags)
+}
+
+// TagsPage return a page of tags and total number of tags
+func (d *DatabaseUtils) TagsPage(limit, offset int) ([]Tag, int, error) {
+	var total int
+	if err := d.StatementTagsCount.QueryRow().Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	tags, err := d.queryTags(d.StatementTagsPage, limit, offset)
+	return tags, total, err
+}
+
+// TagById return a tag by its id
+func (d *DatabaseUtils) TagById(id int) (Tag, error) {
+	tag, err := scanTag(d.StatementTagById.QueryRow(id))
+	if err == sql.ErrNoRows {
+		return tag, errTagNotFound
+	}
+	return tag, err
+}
+
+// TagByLabel return a tag by its label
+func (d *DatabaseUtils) TagByLabel(label string) (Tag, error) {
+	tag, err := scanTag(d.StatementTagByLabel.QueryRow(label))
+	if err == sql.ErrNoRows {
+		return tag, errTagNotFound
+	}
+	return tag, err
+}
+
+// Webhooks tables are created by data/webhooks.sql. Their queries are not
+// prepared by NewDatabaseUtils so the API still start without them
+
+// scanWebhook scan a webhook row, events are stored comma separated
+func scanWebhook(row scanner) (*Webhook, error) {
+	webhook := &Webhook{}
+	var events string
+	err := row.Scan(&webhook.Id, &webhook.Url, &webhook.Secret, &events, &webhook.Active, &webhook.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	webhook.Events = strings.Split(events, ",")
+	return webhook, nil
+}
+
+// queryWebhooks run a webhooks query
+func (d *DatabaseUtils) queryWebhooks(query string, args ...interface{}) ([]*Webhook, error) {
+	rows, err := d.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// Webhooks return every webhooks
+func (d *DatabaseUtils) Webhooks() ([]*Webhook, error) {
+	return d.queryWebhooks("SELECT " + webhookColumns + " FROM webhooks ORDER BY id")
+}
+
+// ActiveWebhooks return every active webhooks
+func (d *DatabaseUtils) ActiveWebhooks() ([]*Webhook, error) {
+	return d.queryWebhooks("SELECT " + webhookColumns + " FROM webhooks WHERE active ORDER BY id")
+}
+
+// WebhookById return a webhook by its id
+func (d *DatabaseUtils) WebhookById(id int) (*Webhook, error) {
+	return scanWebhook(d.DB.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE id = $1", id))
+}
+
+// CreateWebhook insert a webhook
+func (d *DatabaseUtils) CreateWebhook(url, secret string, events []string, active bool) (*Webhook, error) {
+	return scanWebhook(d.DB.QueryRow("INSERT INTO webhooks (url, secret, events, active) VALUES ($1, $2, $3, $4) "+
+		"RETURNING "+webhookColumns, url, secret, strings.Join(events, ","), active))
+}
+
+// UpdateWebhook update url, events and active flag of a webhook
+func (d *DatabaseUtils) UpdateWebhook(id int, url string, events []string, active bool) (*Webhook, error) {
+	return scanWebhook(d.DB.QueryRow("UPDATE webhooks SET url = $2, events = $3, active = $4 WHERE id = $1 "+
+		"RETURNING "+webhookColumns, id, url, strings.Join(events, ","), active))
+}
+
+// DeleteWebhook delete a webhook and its deliveries
+func (d *DatabaseUtils) DeleteWebhook(id int) error {
+	result, err := d.DB.Exec("DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return errWebhookNotFound
+	}
+	return nil
+}
+
+// scanDelivery scan a webhook delivery row
+func scanDelivery(row scanner) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var payload string
+	var response_code sql.NullInt64
+	var delivery_error sql.NullString
+	var next_attempt_at, delivered_at nullTime
+	err := row.Scan(&delivery.Id, &delivery.WebhookId, &delivery.Event, &payload, &delivery.Status, &delivery.Attempts,
+		&response_code, &delivery_error, &next_attempt_at, &delivery.CreatedAt, &delivered_at)
+	if err == sql.ErrNoRows {
+		return nil, errDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = json.RawMessage(payload)
+	delivery.ResponseCode = int(response_code.Int64)
+	delivery.Error = delivery_error.String
+	delivery.NextAttemptAt = next_attempt_at.ptr()
+	delivery.DeliveredAt = delivered_at.ptr()
+	return delivery, nil
+}
+
+// nullTime scan a nullable timestamp
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (n *nullTime) Scan(value interface{}) error {
+	n.Time, n.Valid = value.(time.Time)
+	return nil
+}
+
+// ptr return nil for NULL
+func (n nullTime) ptr() *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Time
+}
+
+// WebhookDeliveries return deliveries of a webhook, newest first. Empty
+// status return every status
+func (d *DatabaseUtils) WebhookDeliveries(webhook_id int, status string, limit, offset int) ([]*WebhookDelivery, error) {
+	rows, err := d.DB.Query("SELECT "+deliveryColumns+" FROM webhook_deliveries "+
+		"WHERE webhook_id = $1 AND ($2 = '' OR status = $2) ORDER BY id DESC LIMIT $3 OFFSET $4", webhook_id, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// WebhookDeliveryById return a delivery of a webhook by its id
+func (d *DatabaseUtils) WebhookDeliveryById(webhook_id, id int) (*WebhookDelivery, error) {
+	return scanDelivery(d.DB.QueryRow("SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE webhook_id = $1 AND id = $2", webhook_id, id))
+}
+
+// CreateWebhookDelivery queue a delivery for immediate attempt
+func (d *DatabaseUtils) CreateWebhookDelivery(webhook_id int, event string, payload []byte) (*WebhookDelivery, error) {
+	return scanDelivery(d.DB.QueryRow("INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at) "+
+		"VALUES ($1, $2, $3, now()) RETURNING "+deliveryColumns, webhook_id, event, string(payload)))
+}
+
+// ProcessWebhookEvents claim up to limit change events queued by the
+// triggers of data/webhooks.sql, skipping events claimed by other
+// instances. process is called for every event with a function creating
+// its deliveries, then events are deleted. Everything is done in one
+// transaction so an event is processed once. It return the number of
+// events processed
+func (d *DatabaseUtils) ProcessWebhookEvents(limit int, process func(event *webhookEvent, deliver func(webhook_id int, name string, payload []byte) error) error) (int, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// events without data, deleted objects or queued before data was
+	// snapshotted, only have the object id
+	rows, err := tx.Query("SELECT id, table_name, action, row_id, COALESCE(data, json_build_object('id', row_id)), created_at FROM webhook_events "+
+		"ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", limit)
+	if err != nil {
+		return 0, err
+	}
+	var events []*webhookEvent
+	for rows.Next() {
+		event := &webhookEvent{}
+		var data string
+		if err := rows.Scan(&event.Id, &event.Change.Table, &event.Change.Action, &event.Change.Id, &data, &event.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		event.Data = json.RawMessage(data)
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, event := range events {
+		deliver := func(webhook_id int, name string, payload []byte) error {
+			// a webhook get one delivery per event
+			_, err := tx.Exec("INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, next_attempt_at) "+
+				"VALUES ($1, $2, $3, $4, now()) ON CONFLICT (webhook_id, event_id) DO NOTHING", webhook_id, event.Id, name, string(payload))
+			return err
+		}
+		if err := process(event, deliver); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("DELETE FROM webhook_events WHERE id = $1", event.Id); err != nil {
+			return 0, err
+		}
+	}
+	return len(events), tx.Commit()
+}
+
+// RetryWebhookDelivery queue a delivery again for immediate attempt, its
+// attempts are reset
+func (d *DatabaseUtils) RetryWebhookDelivery(webhook_id, id int) (*WebhookDelivery, error) {
+	return scanDelivery(d.DB.QueryRow("UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = now() "+
+		"WHERE webhook_id = $1 AND id = $2 RETURNING "+deliveryColumns, webhook_id, id))
+}
+
+// ClaimWebhookDeliveries return up to limit pending deliveries which are
+// due with url and secret of their webhook. Claimed deliveries are leased
+// so other instances skip them until lease expire
+func (d *DatabaseUtils) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]*webhookAttempt, error) {
+	rows, err := d.DB.Query("WITH due AS ("+
+		"UPDATE webhook_deliveries SET next_attempt_at = now() + $2 * interval '1 second' WHERE id IN ("+
+		"SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= now() "+
+		"ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED) "+
+		"RETURNING id, webhook_id, event, payload, attempts) "+
+		"SELECT due.id, due.event, due.payload, due.attempts, webhooks.url, webhooks.secret FROM due "+
+		"JOIN webhooks ON webhooks.id = due.webhook_id", limit, int(lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var attempts []*webhookAttempt
+	for rows.Next() {
+		attempt := &webhookAttempt{}
+		var payload string
+		if err := rows.Scan(&attempt.DeliveryId, &attempt.Event, &payload, &attempt.Attempts, &attempt.Url, &attempt.Secret); err != nil {
+			return nil, err
+		}
+		attempt.Payload = []byte(payload)
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// RecordWebhookDelivered record a successful attempt
+func (d *DatabaseUtils) RecordWebhookDelivered(id, response_code int) error {
+	_, err := d.DB.Exec("UPDATE webhook_deliveries SET status = 'delivered', attempts = attempts + 1, response_code = $2, "+
+		"error = NULL, next_attempt_at = NULL, delivered_at = now() WHERE id = $1", id, response_code)
+	return err
+}
+
+// RecordWebhookFailed record a failed attempt. The delivery is retried
+// after retryIn, or dead-lettered when dead is true
+func (d *DatabaseUtils) RecordWebhookFailed(id, response_code int, delivery_error string, retryIn time.Duration, dead bool) error {
+	status := "pending"
+	if dead {
+		status = "dead"
+	}
+	_, err := d.DB.Exec("UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1, response_code = NULLIF($3, 0), "+
+		"error = $4, next_attempt_at = CASE WHEN $2 = 'dead' THEN NULL ELSE now() + $5 * interval '1 second' END WHERE id = $1",
+		id, status, response_code, delivery_error, int(retryIn.Seconds()))
+	return err
+}
+
+// Short links table is created by data/short_links.sql, its queries are not
+// prepared either
+
+// scanShortLink scan a short link row
+func scanShortLink(row scanner) (*ShortLink, error) {
+	link := &ShortLink{}
+	var quote_id sql.NullInt64
+	var last_clicked_at nullTime
+	err := row.Scan(&link.Code, &quote_id, &link.Tag, &link.Author, &link.Clicks, &link.CreatedAt, &last_clicked_at)
+	if err == sql.ErrNoRows {
+		return nil, errShortLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	link.QuoteId = int(quote_id.Int64)
+	link.LastClickedAt = last_clicked_at.ptr()
+	return link, nil
+}
+
+// ShortLinkByCode return a short link by its code
+func (d *DatabaseUtils) ShortLinkByCode(code string) (*ShortLink, error) {
+	return scanShortLink(d.DB.QueryRow("SELECT "+shortLinkColumns+" FROM short_links WHERE code = $1", code))
+}
+
+// CreateShortLink insert a short link, or return the existing link with the
+// same code
+func (d *DatabaseUtils) CreateShortLink(link *ShortLink) (*ShortLink, error) {
+	quote_id := sql.NullInt64{Int64: int64(link.QuoteId), Valid: link.QuoteId != 0}
+	// the no-op update return the existing row
+	return scanShortLink(d.DB.QueryRow("INSERT INTO short_links (code, quote_id, tag_label, twitter_username) VALUES ($1, $2, $3, $4) "+
+		"ON CONFLICT (code) DO UPDATE SET code = EXCLUDED.code RETURNING "+shortLinkColumns,
+		link.Code, quote_id, link.Tag, link.Author))
+}
+
+// ClickShortLink count a click of a short link and return it
+func (d *DatabaseUtils) ClickShortLink(code string) (*ShortLink, error) {
+	return scanShortLink(d.DB.QueryRow("UPDATE short_links SET clicks = clicks + 1, last_clicked_at = now() WHERE code = $1 "+
+		"RETURNING "+shortLinkColumns, code))
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// zone of the DNS server, e.g. `wisdom.internal`. DNS server disabled if
+	// empty
+	DNS_ZONE = os.Getenv("DNS_ZONE")
+	// TCP and UDP port of the DNS server, default 53
+	DNS_PORT = os.Getenv("DNS_PORT")
+	// queries per minute per client IP, default 60
+	DNS_RATE_LIMIT = os.Getenv("DNS_RATE_LIMIT")
+)
+
+const (
+	dnsDefaultPort      = "53"
+	dnsDefaultRateLimit = 60
+	dnsHeaderLength     = 12
+	// UDP payload size without EDNS, see RFC 1035 section 4.2.1
+	dnsMinUDPSize = 512
+	// largest UDP payload advertised and sent, avoiding IP fragmentation
+	dnsMaxUDPSize = 1232
+	dnsTCPTimeout = 10 * time.Second
+	// TTL of names always answered with the same quote
+	dnsQuoteTTL = 3600
+	// TTL of the SOA record and of negative answers, see RFC 2308
+	dnsNegativeTTL = 300
+	// longest TXT text, so records fit in 16 bits RDLENGTH and TCP length
+	dnsMaxTextLength = 4096
+
+	dnsTypeSOA  = 6
+	dnsTypeTXT  = 16
+	dnsTypeOPT  = 41
+	dnsTypeANY  = 255
+	dnsClassIN  = 1
+	dnsClassANY = 255
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeFormErr  = 1
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotImp   = 4
+	dnsRcodeRefused  = 5
+	// extended rcode, upper 8 bits in the OPT record
+	dnsRcodeBadVers = 16
+)
+
+var errDNSFormat = errors.New("malformed DNS message")
+
+// dnsQuestion is the question of a query, with its EDNS options
+type dnsQuestion struct {
+	Id      uint16
+	Flags   uint16
+	Name    string
+	Type    uint16
+	Class   uint16
+	EDNS    bool
+	UDPSize int
+	// EDNS version of the query
+	Version uint8
+}
+
+// dnsReadName read a domain name at offset and return the offset after
+// it. Compression pointers are followed
+func dnsReadName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	length := 0
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errDNSFormat
+		}
+		n := int(msg[offset])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xc0 == 0xc0:
+			if offset+1 >= len(msg) || jumps > 10 {
+				return "", 0, errDNSFormat
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+			jumps++
+		case n > 63:
+			return "", 0, errDNSFormat
+		default:
+			if offset+1+n > len(msg) {
+				return "", 0, errDNSFormat
+			}
+			length += n + 1
+			if length > 255 {
+				return "", 0, errDNSFormat
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+n]))
+			offset += 1 + n
+		}
+	}
+}
+
+// dnsParseQuery parse header, question and OPT record of a query
+func dnsParseQuery(msg []byte) (*dnsQuestion, error) {
+	if len(msg) < dnsHeaderLength {
+		return nil, errDNSFormat
+	}
+	q := &dnsQuestion{
+		Id:      binary.BigEndian.Uint16(msg),
+		Flags:   binary.BigEndian.Uint16(msg[2:]),
+		UDPSize: dnsMinUDPSize,
+	}
+	if binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return q, errDNSFormat
+	}
+	name, offset, err := dnsReadName(msg, dnsHeaderLength)
+	if err != nil || offset+4 > len(msg) {
+		return q, errDNSFormat
+	}
+	q.Name = name
+	q.Type = binary.BigEndian.Uint16(msg[offset:])
+	q.Class = binary.BigEndian.Uint16(msg[offset+2:])
+	offset += 4
+
+	// skip answer and authority records, find OPT in additional records
+	counts := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:]))
+	additional := int(binary.BigEndian.Uint16(msg[10:]))
+	for i := 0; i < counts+additional; i++ {
+		_, offset, err = dnsReadName(msg, offset)
+		if err != nil || offset+10 > len(msg) {
+			return q, errDNSFormat
+		}
+		rr_type := binary.BigEndian.Uint16(msg[offset:])
+		rr_class := binary.BigEndian.Uint16(msg[offset+2:])
+		rr_ttl := binary.BigEndian.Uint32(msg[offset+4:])
+		rdlength := int(binary.BigEndian.Uint16(msg[offset+8:]))
+		offset += 10 + rdlength
+		if offset > len(msg) {
+			return q, errDNSFormat
+		}
+		if i >= counts && rr_type == dnsTypeOPT {
+			q.EDNS = true
+			q.Version = uint8(rr_ttl >> 16)
+			if int(rr_class) > q.UDPSize {
+				q.UDPSize = int(rr_class)
+			}
+		}
+	}
+	if q.UDPSize > dnsMaxUDPSize {
+		q.UDPSize = dnsMaxUDPSize
+	}
+	return q, nil
+}
+
+// dnsTXT return TXT record data, text split in strings of at most 255
+// bytes without splitting UTF-8 sequences
+func dnsTXT(text string) []byte {
+	var rdata []byte
+	for len(text) > 0 {
+		n := len(text)
+		if n > 255 {
+			n = 255
+			for n > 0 && !utf8.RuneStart(text[n]) {
+				n--
+			}
+		}
+		rdata = append(rdata, byte(n))
+		rdata = append(rdata, text[:n]...)
+		text = text[n:]
+	}
+	return rdata
+}
+
+// dnsQuestionName is a compression pointer to the question name of a
+// response
+var dnsQuestionName = []byte{0xc0, dnsHeaderLength}
+
+// dnsEncodeName return name in wire format, without compression
+func dnsEncodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(name, ".") {
+		if label != "" {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+// dnsRR return a resource record of class IN, name is in wire format
+func dnsRR(name []byte, rr_type uint16, ttl uint32, rdata []byte) []byte {
+	rr := make([]byte, len(name)+10, len(name)+10+len(rdata))
+	copy(rr, name)
+	binary.BigEndian.PutUint16(rr[len(name):], rr_type)
+	binary.BigEndian.PutUint16(rr[len(name)+2:], dnsClassIN)
+	binary.BigEndian.PutUint32(rr[len(name)+4:], ttl)
+	binary.BigEndian.PutUint16(rr[len(name)+8:], uint16(len(rdata)))
+	return append(rr, rdata...)
+}
+
+// dnsResponse build the response of query q with answer and authority
+// records. msg is the query, its question section is copied
+func dnsResponse(msg []byte, q *dnsQuestion, rcode int, answers, authority [][]byte) []byte {
+	// header and question of the query
+	question_end := dnsHeaderLength
+	if _, end, err := dnsReadName(msg, dnsHeaderLength); err == nil && end+4 <= len(msg) {
+		question_end = end + 4
+	}
+	resp := make([]byte, question_end, question_end+512)
+	copy(resp, msg[:question_end])
+
+	// QR, AA, opcode and RD of the query
+	flags := uint16(0x8400) | (q.Flags & 0x7900) | uint16(rcode&0x0f)
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	qdcount := uint16(0)
+	if question_end > dnsHeaderLength {
+		qdcount = 1
+	}
+	binary.BigEndian.PutUint16(resp[4:], qdcount)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(resp[8:], uint16(len(authority)))
+	binary.BigEndian.PutUint16(resp[10:], 0)
+
+	for _, rr := range answers {
+		resp = append(resp, rr...)
+	}
+	for _, rr := range authority {
+		resp = append(resp, rr...)
+	}
+
+	if q.EDNS {
+		opt := make([]byte, 11)
+		// root name, type, UDP payload size, extended rcode, version 0
+		binary.BigEndian.PutUint16(opt[1:], dnsTypeOPT)
+		binary.BigEndian.PutUint16(opt[3:], dnsMaxUDPSize)
+		opt[5] = byte(rcode >> 4)
+		resp = append(resp, opt...)
+		binary.BigEndian.PutUint16(resp[10:], 1)
+	}
+	return resp
+}
+
+// dnsTruncate return the response without answers and with the TC flag, so
+// the client retry over TCP
+func dnsTruncate(msg []byte, q *dnsQuestion, rcode int) []byte {
+	resp := dnsResponse(msg, q, rcode, nil, nil)
+	resp[2] |= 0x02
+	return resp
+}
+
+// dnsServer answer TXT queries of a zone with quotes:
+//
+//	random.<zone>                      random quote
+//	<twitter_username>.author.<zone>   random quote by author
+//	<tag>.tag.<zone>                   random quote with tag
+//	<id>.quote.<zone>                  quote by id
+//
+// author.<zone>, tag.<zone> and quote.<zone> exist without records. The
+// zone apex has a SOA record, sent with negative answers
+type dnsServer struct {
+	zone    string
+	dbUtils *DatabaseUtils
+	limiter *ipLimiter
+}
+
+// newDNSServer create a server of zone rate limited with DNS_RATE_LIMIT
+func newDNSServer(zone string, dbUtils *DatabaseUtils) *dnsServer {
+	rate, err := strconv.Atoi(DNS_RATE_LIMIT)
+	if err != nil || rate < 1 {
+		rate = dnsDefaultRateLimit
+	}
+	zone = strings.ToLower(strings.Trim(zone, "."))
+	return &dnsServer{zone, dbUtils, newIPLimiter(rate, rate)}
+}
+
+// soa return the SOA record of the zone. Its minimum is the TTL of
+// negative answers, see RFC 2308 section 5
+func (s *dnsServer) soa() []byte {
+	rdata := append(dnsEncodeName(s.zone), dnsEncodeName("hostmaster."+s.zone)...)
+	timers := make([]byte, 20)
+	// serial, refresh, retry, expire and minimum
+	binary.BigEndian.PutUint32(timers, 1)
+	binary.BigEndian.PutUint32(timers[4:], 3600)
+	binary.BigEndian.PutUint32(timers[8:], 600)
+	binary.BigEndian.PutUint32(timers[12:], 86400)
+	binary.BigEndian.PutUint32(timers[16:], dnsNegativeTTL)
+	return dnsRR(dnsEncodeName(s.zone), dnsTypeSOA, dnsNegativeTTL, append(rdata, timers...))
+}
+
+// lookup return the quote text of a name relative to the zone and its TTL
+func (s *dnsServer) lookup(name string) (string, uint32, error) {
+	labels := strings.Split(name, ".")
+	var quote *Quote
+	var err error
+	var ttl uint32
+	switch {
+	case len(labels) == 1 && strings.EqualFold(labels[0], "random"):
+		quote, err = s.dbUtils.RandomQuote()
+	case len(labels) == 2 && strings.EqualFold(labels[1], "author"):
+		// resolvers may randomize the case of names
+		quote, err = s.dbUtils.RandomQuoteFiltered("", labels[0])
+		if err == errQuoteNotFound && labels[0] != strings.ToLower(labels[0]) {
+			quote, err = s.dbUtils.RandomQuoteFiltered("", strings.ToLower(labels[0]))
+		}
+	case len(labels) == 2 && strings.EqualFold(labels[1], "tag"):
+		quote, err = s.dbUtils.RandomQuoteFiltered(labels[0], "")
+		if err == errQuoteNotFound && labels[0] != strings.ToLower(labels[0]) {
+			quote, err = s.dbUtils.RandomQuoteFiltered(strings.ToLower(labels[0]), "")
+		}
+	case len(labels) == 2 && strings.EqualFold(labels[1], "quote"):
+		id, convErr := strconv.Atoi(labels[0])
+		if convErr != nil {
+			return "", 0, errQuoteNotFound
+		}
+		quote, err = s.dbUtils.QuoteById(id)
+		ttl = dnsQuoteTTL
+	default:
+		return "", 0, errQuoteNotFound
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return dnsText(quote), ttl, nil
+}
+
+// dnsText return quote in ASCII, so dig doesn't escape it, truncated to
+// dnsMaxTextLength
+func dnsText(quote *Quote) string {
+	author := ` - ` + fontText(quote.Author.Name)
+	content := fontText(quote.Content)
+	if max := dnsMaxTextLength - len(author) - 5; len(content) > max {
+		content = truncateASCII(content, max)
+		if i := strings.LastIndexByte(content, ' '); i > 0 {
+			content = content[:i]
+		}
+		content += "..."
+	}
+	return `"` + content + `"` + author
+}
+
+// answer return the response of a query message, nil when the message
+// must not be answered. UDP responses larger than the client accept are
+// truncated
+func (s *dnsServer) answer(msg []byte, tcp bool) []byte {
+	q, err := dnsParseQuery(msg)
+	if q == nil {
+		return nil
+	}
+	if q.Flags&0x8000 != 0 {
+		// response, never answer
+		return nil
+	}
+	if err != nil {
+		return dnsResponse(msg, q, dnsRcodeFormErr, nil, nil)
+	}
+	if opcode := (q.Flags >> 11) & 0x0f; opcode != 0 {
+		return dnsResponse(msg, q, dnsRcodeNotImp, nil, nil)
+	}
+	if q.EDNS && q.Version > 0 {
+		return dnsResponse(msg, q, dnsRcodeBadVers, nil, nil)
+	}
+
+	name := strings.TrimSuffix(q.Name, ".")
+	// names are case insensitive, only ASCII letters have a case
+	lower := strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, name)
+	if (q.Class != dnsClassIN && q.Class != dnsClassANY) || (lower != s.zone && !strings.HasSuffix(lower, "."+s.zone)) {
+		return dnsResponse(msg, q, dnsRcodeRefused, nil, nil)
+	}
+	// negative answers have the SOA record in the authority section
+	noData := dnsResponse(msg, q, dnsRcodeSuccess, nil, [][]byte{s.soa()})
+	if lower == s.zone {
+		// zone apex only has the SOA record
+		if q.Type == dnsTypeSOA || q.Type == dnsTypeANY {
+			return dnsResponse(msg, q, dnsRcodeSuccess, [][]byte{s.soa()}, nil)
+		}
+		return noData
+	}
+	relative := lower[:len(lower)-len(s.zone)-1]
+	if relative == "author" || relative == "tag" || relative == "quote" {
+		// empty non-terminals, names exist below them, see RFC 8020
+		return noData
+	}
+	text, ttl, err := s.lookup(name[:len(name)-len(s.zone)-1])
+	switch err {
+	case nil:
+	case errQuoteNotFound:
+		return dnsResponse(msg, q, dnsRcodeNXDomain, nil, [][]byte{s.soa()})
+	default:
+		log.Printf("dns %s: %s", q.Name, err)
+		return dnsResponse(msg, q, dnsRcodeServFail, nil, nil)
+	}
+	if q.Type != dnsTypeTXT && q.Type != dnsTypeANY {
+		// name exists, without records of this type
+		return noData
+	}
+	resp := dnsResponse(msg, q, dnsRcodeSuccess, [][]byte{dnsRR(dnsQuestionName, dnsTypeTXT, ttl, dnsTXT(text))}, nil)
+	if !tcp && len(resp) > q.UDPSize {
+		return dnsTruncate(msg, q, dnsRcodeSuccess)
+	}
+	return resp
+}
+
+// serveUDP answer queries over UDP. Rate limited clients are not answered
+func (s *dnsServer) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		if !s.limiter.allow(addrIP(addr)) {
+			continue
+		}
+		msg := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.answer(msg, false); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+// serveTCP answer queries over TCP, messages are prefixed by their length.
+// A connection can send several queries
+func (s *dnsServer) serveTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(dnsTCPTimeout))
+				var length uint16
+				if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+					return
+				}
+				msg := make([]byte, length)
+				if _, err := io.ReadFull(conn, msg); err != nil {
+					return
+				}
+				if !s.limiter.allow(addrIP(conn.RemoteAddr())) {
+					return
+				}
+				resp := s.answer(msg, true)
+				if resp == nil {
+					return
+				}
+				out := make([]byte, 2, 2+len(resp))
+				binary.BigEndian.PutUint16(out, uint16(len(resp)))
+				if _, err := conn.Write(append(out, resp...)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// serveDNS listen on TCP and UDP port
+func serveDNS(port, zone string, dbUtils *DatabaseUtils) error {
+	if port == "" {
+		port = dnsDefaultPort
+	}
+	s := newDNSServer(zone, dbUtils)
+	packetConn, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		packetConn.Close()
+		return err
+	}
+	log.Printf("DNS listening on :%s (udp, tcp) for zone %s", port, s.zone)
+	errs := make(chan error, 2)
+	go func() { errs <- s.serveUDP(packetConn) }()
+	go func() { errs <- s.serveTCP(listener) }()
+	return <-errs
+}
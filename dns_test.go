@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// dnsQuery return a query message of name, with an OPT record advertising
+// udp_size when udp_size is not 0
+func dnsQuery(name string, q_type uint16, udp_size uint16, version uint8) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	msg = append(msg, dnsEncodeName(name)...)
+	msg = append(msg, byte(q_type>>8), byte(q_type), 0, dnsClassIN)
+	if udp_size != 0 {
+		msg[11] = 1
+		opt := make([]byte, 11)
+		binary.BigEndian.PutUint16(opt[1:], dnsTypeOPT)
+		binary.BigEndian.PutUint16(opt[3:], udp_size)
+		opt[6] = version
+		msg = append(msg, opt...)
+	}
+	return msg
+}
+
+// dnsHeader return rcode, including the extended rcode of the OPT record,
+// and the answer, authority and additional counts of a response
+func dnsHeader(t *testing.T, resp []byte) (rcode int, counts [3]int) {
+	if len(resp) < dnsHeaderLength {
+		t.Fatalf("response too short: %x", resp)
+	}
+	rcode = int(resp[3] & 0x0f)
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(resp[6+2*i:]))
+	}
+	if counts[2] == 1 {
+		// OPT record is last, extended rcode is after type and class
+		rcode |= int(resp[len(resp)-6]) << 4
+	}
+	return rcode, counts
+}
+
+func TestDNSParseQuery(t *testing.T) {
+	q, err := dnsParseQuery(dnsQuery("Random.Wisdom.Test", dnsTypeTXT, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Id != 0x1234 || q.Flags != 0x0100 || q.Name != "Random.Wisdom.Test" || q.Type != dnsTypeTXT || q.Class != dnsClassIN || q.EDNS || q.UDPSize != dnsMinUDPSize {
+		t.Errorf("dnsParseQuery() = %+v", q)
+	}
+
+	tests := []struct {
+		udp_size uint16
+		want     int
+	}{
+		{100, dnsMinUDPSize},
+		{1232, 1232},
+		{4096, dnsMaxUDPSize},
+	}
+	for _, test := range tests {
+		q, err := dnsParseQuery(dnsQuery("random.wisdom.test", dnsTypeTXT, test.udp_size, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !q.EDNS || q.Version != 1 || q.UDPSize != test.want {
+			t.Errorf("UDP size %d: dnsParseQuery() = %+v, want EDNS version 1 and UDP size %d", test.udp_size, q, test.want)
+		}
+	}
+
+	valid := dnsQuery("random.wisdom.test", dnsTypeTXT, 0, 0)
+	malformed := map[string][]byte{
+		"short header":       valid[:10],
+		"truncated name":     valid[:16],
+		"missing type":       valid[:len(valid)-2],
+		"no question":        append([]byte{0, 1, 0, 0, 0, 0}, valid[6:]...),
+		"long label":         append(append([]byte{}, valid[:12]...), 64),
+		"pointer loop":       append(append([]byte{}, valid[:12]...), 0xc0, 12, 0, 16, 0, 1),
+		"missing additional": append(append([]byte{}, valid[:11]...), append([]byte{1}, valid[12:]...)...),
+	}
+	for desc, msg := range malformed {
+		if _, err := dnsParseQuery(msg); err == nil {
+			t.Errorf("%s: dnsParseQuery() didn't fail", desc)
+		}
+	}
+}
+
+func TestDNSTXT(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	utf := strings.Repeat("a", 254) + "é"
+	tests := []struct {
+		text string
+		want []byte
+	}{
+		{"", nil},
+		{"hello", append([]byte{5}, "hello"...)},
+		{long, append(append([]byte{255}, long[:255]...), append([]byte{45}, long[255:]...)...)},
+		// é is 2 bytes, it is not split between strings
+		{utf, append(append([]byte{254}, utf[:254]...), append([]byte{2}, "é"...)...)},
+	}
+	for _, test := range tests {
+		if got := dnsTXT(test.text); !bytes.Equal(got, test.want) {
+			t.Errorf("dnsTXT(%q) = %x, want %x", test.text, got, test.want)
+		}
+	}
+}
+
+func TestDNSResponse(t *testing.T) {
+	msg := dnsQuery("random.wisdom.test", dnsTypeTXT, 0, 0)
+	q, err := dnsParseQuery(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := dnsRR(dnsQuestionName, dnsTypeTXT, 60, dnsTXT("hi"))
+	resp := dnsResponse(msg, q, dnsRcodeSuccess, [][]byte{answer}, nil)
+
+	want := append([]byte{0x12, 0x34, 0x85, 0x00, 0, 1, 0, 1, 0, 0, 0, 0}, msg[12:]...)
+	// pointer to the question name, TXT, IN, TTL 60, RDLENGTH 3
+	want = append(want, 0xc0, 12, 0, 16, 0, 1, 0, 0, 0, 60, 0, 3, 2, 'h', 'i')
+	if !bytes.Equal(resp, want) {
+		t.Errorf("dnsResponse() =\n%x\nwant\n%x", resp, want)
+	}
+
+	truncated := dnsTruncate(msg, q, dnsRcodeSuccess)
+	if truncated[2]&0x02 == 0 || binary.BigEndian.Uint16(truncated[6:]) != 0 {
+		t.Errorf("dnsTruncate() = %x, want TC flag and no answer", truncated)
+	}
+}
+
+func TestDNSServerAnswer(t *testing.T) {
+	s := &dnsServer{zone: "wisdom.test"}
+	tests := []struct {
+		desc   string
+		msg    []byte
+		rcode  int
+		counts [3]int
+	}{
+		{"apex TXT", dnsQuery("wisdom.test", dnsTypeTXT, 0, 0), dnsRcodeSuccess, [3]int{0, 1, 0}},
+		{"apex SOA", dnsQuery("Wisdom.Test", dnsTypeSOA, 0, 0), dnsRcodeSuccess, [3]int{1, 0, 0}},
+		{"author empty non-terminal", dnsQuery("author.wisdom.test", dnsTypeTXT, 0, 0), dnsRcodeSuccess, [3]int{0, 1, 0}},
+		// A record
+		{"tag empty non-terminal", dnsQuery("TAG.wisdom.test", 1, 0, 0), dnsRcodeSuccess, [3]int{0, 1, 0}},
+		{"quote empty non-terminal", dnsQuery("quote.wisdom.test", dnsTypeTXT, 1232, 0), dnsRcodeSuccess, [3]int{0, 1, 1}},
+		{"unknown name", dnsQuery("nope.wisdom.test", dnsTypeTXT, 0, 0), dnsRcodeNXDomain, [3]int{0, 1, 0}},
+		{"invalid quote id", dnsQuery("x.quote.wisdom.test", dnsTypeTXT, 0, 0), dnsRcodeNXDomain, [3]int{0, 1, 0}},
+		{"other zone", dnsQuery("random.example.com", dnsTypeTXT, 0, 0), dnsRcodeRefused, [3]int{0, 0, 0}},
+		{"EDNS version 1", dnsQuery("random.wisdom.test", dnsTypeTXT, 1232, 1), dnsRcodeBadVers, [3]int{0, 0, 1}},
+		{"malformed", dnsQuery("random.wisdom.test", dnsTypeTXT, 0, 0)[:20], dnsRcodeFormErr, [3]int{0, 0, 0}},
+	}
+	for _, test := range tests {
+		resp := s.answer(test.msg, false)
+		rcode, counts := dnsHeader(t, resp)
+		if rcode != test.rcode || counts != test.counts {
+			t.Errorf("%s: rcode %d, counts %v, want rcode %d, counts %v", test.desc, rcode, counts, test.rcode, test.counts)
+		}
+	}
+
+	// SOA of negative answers, its TTL and minimum are the negative TTL
+	resp := s.answer(dnsQuery("nope.wisdom.test", dnsTypeTXT, 0, 0), false)
+	soa := s.soa()
+	if !bytes.HasSuffix(resp, soa) {
+		t.Errorf("NXDOMAIN response %x doesn't end with SOA %x", resp, soa)
+	}
+	name := dnsEncodeName("wisdom.test")
+	if binary.BigEndian.Uint16(soa[len(name):]) != dnsTypeSOA || binary.BigEndian.Uint32(soa[len(name)+4:]) != dnsNegativeTTL || binary.BigEndian.Uint32(soa[len(soa)-4:]) != dnsNegativeTTL {
+		t.Errorf("soa() = %x", soa)
+	}
+
+	// responses and other opcodes
+	response := dnsQuery("random.wisdom.test", dnsTypeTXT, 0, 0)
+	response[2] |= 0x80
+	if resp := s.answer(response, false); resp != nil {
+		t.Errorf("answer of a response = %x, want nil", resp)
+	}
+	notify := dnsQuery("wisdom.test", dnsTypeSOA, 0, 0)
+	notify[2] = 4 << 3
+	if rcode, _ := dnsHeader(t, s.answer(notify, false)); rcode != dnsRcodeNotImp {
+		t.Errorf("answer of NOTIFY: rcode %d, want %d", rcode, dnsRcodeNotImp)
+	}
+}
+
+func TestDNSText(t *testing.T) {
+	quote := &Quote{Content: "“Simplicity” is prerequisite — for reliability", Author: Author{Name: "Edsger W. Dijkstra"}}
+	if got, want := dnsText(quote), `""Simplicity" is prerequisite - for reliability" - Edsger W. Dijkstra`; got != want {
+		t.Errorf("dnsText() = %q, want %q", got, want)
+	}
+
+	quote.Content = strings.Repeat("word ", 2000)
+	got := dnsText(quote)
+	if len(got) > dnsMaxTextLength || !strings.HasSuffix(got, `word..." - Edsger W. Dijkstra`) {
+		t.Errorf("dnsText() of a long quote has length %d and end %q", len(got), got[len(got)-40:])
+	}
+	for i := 0; i < len(got); i++ {
+		if got[i] >= 0x80 {
+			t.Fatalf("dnsText() is not ASCII at %d: %q", i, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgres channel notified by triggers in data/notify.sql
+const changesChannel = "wisdom_changes"
+
+// number of recent changes kept to resume streams
+const changesBufferSize = 256
+
+// changeEvent is a change of quotes, authors or tags notified by database
+type changeEvent struct {
+	Seq    int64  `json:"-"`
+	Table  string `json:"table"`
+	Action string `json:"action"`
+	Id     int    `json:"id"`
+}
+
+// changeHub broadcast change events to subscribers and keep recent events
+// so a subscriber can resume after reconnecting
+type changeHub struct {
+	mu          sync.Mutex
+	seq         int64
+	recent      []*changeEvent
+	subscribers map[chan *changeEvent]bool
+}
+
+// changes is the hub of database changes
+var changes = newChangeHub()
+
+// shuttingDown is closed when the server is shutting down, long-lived
+// handlers must return
+var shuttingDown = make(chan struct{})
+
+func newChangeHub() *changeHub {
+	return &changeHub{subscribers: make(map[chan *changeEvent]bool)}
+}
+
+// subscribe return a channel of new events and recent events after since
+func (h *changeHub) subscribe(since int64) (chan *changeEvent, []*changeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan *changeEvent, 16)
+	h.subscribers[ch] = true
+	var missed []*changeEvent
+	for _, event := range h.recent {
+		if event.Seq > since {
+			missed = append(missed, event)
+		}
+	}
+	return ch, missed
+}
+
+func (h *changeHub) unsubscribe(ch chan *changeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+// publish assign a sequence number to event and send it to subscribers.
+// Slow subscribers miss the event instead of blocking the hub
+func (h *changeHub) publish(event *changeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	event.Seq = h.seq
+	h.recent = append(h.recent, event)
+	if len(h.recent) > changesBufferSize {
+		h.recent = h.recent[len(h.recent)-changesBufferSize:]
+	}
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// lastSeq return sequence number of the last event
+func (h *changeHub) lastSeq() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.seq
+}
+
+// listenChanges publish notifications of changesChannel to hub
+func listenChanges(url string, hub *changeHub) {
+	listener := pq.NewListener(url, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("listenChanges: %s", err)
+		}
+	})
+	if err := listener.Listen(changesChannel); err != nil {
+		log.Printf("listenChanges.Listen: %s", err)
+		return
+	}
+	log.Printf("Listening on database channel %s", changesChannel)
+
+	for {
+		select {
+		case notification := <-listener.Notify:
+			// nil notification is sent after reconnect
+			if notification == nil {
+				continue
+			}
+			event := &changeEvent{}
+			if err := json.Unmarshal([]byte(notification.Extra), event); err != nil {
+				log.Printf("listenChanges.Unmarshal: %s", err)
+				continue
+			}
+			hub.publish(event)
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/xml"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// public base URL of the API, e.g. `https://wisdomapi.herokuapp.com`.
+	// Built from the Host header of the request when empty
+	PUBLIC_URL = os.Getenv("PUBLIC_URL")
+	// comma separated host names accepted in the Host header when
+	// PUBLIC_URL is empty, default localhost
+	ALLOWED_HOSTS = os.Getenv("ALLOWED_HOSTS")
+)
+
+// host names accepted when ALLOWED_HOSTS is empty
+const defaultAllowedHosts = "localhost,127.0.0.1,::1"
+
+const (
+	feedSize = 50
+	// days of the quote of the day feed
+	qotdFeedDays = 30
+	// feed entries are tagged with this authority, see RFC 4151
+	feedTagAuthority = "wisdomapi.herokuapp.com,2015"
+	feedTitleLength  = 80
+)
+
+// allowedHost check the Host header can be used to build absolute URLs.
+// Responses with absolute URLs are cached by shared caches, any Host would
+// poison them. Host names of ALLOWED_HOSTS are accepted with any port
+func allowedHost(host string) bool {
+	if PUBLIC_URL != "" {
+		return true
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	hosts := ALLOWED_HOSTS
+	if hosts == "" {
+		hosts = defaultAllowedHosts
+	}
+	for _, allowed := range strings.Split(hosts, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed != "" && strings.EqualFold(allowed, strings.Trim(host, "[]")) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseURL return public base URL of the API, without trailing slash. Host
+// of requests is checked by allowedHost before handlers are called
+func baseURL(r *http.Request) string {
+	if PUBLIC_URL != "" {
+		return strings.TrimSuffix(PUBLIC_URL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// feedEntry is a quote of a feed
+type feedEntry struct {
+	Id      string
+	Quote   *Quote
+	Updated time.Time
+	// title prefix, e.g. the date of quote of the day
+	Prefix string
+}
+
+// feed is rendered as RSS 2.0 or Atom 1.0
+type feed struct {
+	Id          string
+	Title       string
+	Description string
+	Link        string
+	Self        string
+	Updated     time.Time
+	Entries     []feedEntry
+}
+
+// quoteTitle return `Author: content` truncated to a feed title
+func quoteTitle(quote *Quote) string {
+	title := quote.Author.Name + ": " + quote.Content
+	if utf8.RuneCountInString(title) <= feedTitleLength {
+		return title
+	}
+	runes := []rune(title)
+	return strings.TrimSpace(string(runes[:feedTitleLength-1])) + "…"
+}
+
+// imageType guess media type of picture from its extension
+func imageType(url string) string {
+	switch strings.ToLower(path.Ext(strings.SplitN(url, "?", 2)[0])) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	case ".svg":
+		return "image/svg+xml"
+	}
+	return "image/jpeg"
+}
+
+// --- RSS 2.0 ---
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Guid        rssGuid       `xml:"guid"`
+	Description string        `xml:"description"`
+	Creator     string        `xml:"dc:creator"`
+	Categories  []string      `xml:"category"`
+	PubDate     string        `xml:"pubDate"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGuid struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// length is required by RSS, 0 when unknown
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func (f *feed) rss() *rssDocument {
+	doc := &rssDocument{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Description,
+			Self:          atomLink{Rel: "self", Href: f.Self, Type: "application/rss+xml"},
+			LastBuildDate: f.Updated.Format(time.RFC1123Z),
+		},
+	}
+	for _, entry := range f.Entries {
+		quote := entry.Quote
+		item := rssItem{
+			Title:       entry.Prefix + quoteTitle(quote),
+			Link:        quote.Permalink,
+			Guid:        rssGuid{"false", entry.Id},
+			Description: quote.Content,
+			Creator:     quote.Author.Name,
+			PubDate:     entry.Updated.Format(time.RFC1123Z),
+		}
+		for _, tag := range quote.Tags {
+			item.Categories = append(item.Categories, tag.Label)
+		}
+		if quote.PictureUrl != "" {
+			item.Enclosure = &rssEnclosure{quote.PictureUrl, "0", imageType(quote.PictureUrl)}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return doc
+}
+
+// --- Atom 1.0 ---
+
+type atomDocument struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Id         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Author     atomAuthor     `xml:"author"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	Uri  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+func (f *feed) atom() *atomDocument {
+	doc := &atomDocument{
+		Id:       f.Id,
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Updated:  f.Updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: f.Self, Type: "application/atom+xml"},
+			{Rel: "alternate", Href: f.Link},
+		},
+	}
+	for _, entry := range f.Entries {
+		quote := entry.Quote
+		atomEntry := atomEntry{
+			Id:      entry.Id,
+			Title:   entry.Prefix + quoteTitle(quote),
+			Updated: entry.Updated.Format(time.RFC3339),
+			Author:  atomAuthor{Name: quote.Author.Name},
+			Links:   []atomLink{{Rel: "alternate", Href: quote.Permalink}},
+			Content: atomContent{"text", quote.Content},
+		}
+		if quote.Author.Twitter != "" {
+			atomEntry.Author.Uri = "https://twitter.com/" + quote.Author.Twitter
+		}
+		if quote.PictureUrl != "" {
+			atomEntry.Links = append(atomEntry.Links, atomLink{"enclosure", quote.PictureUrl, imageType(quote.PictureUrl)})
+		}
+		for _, tag := range quote.Tags {
+			atomEntry.Categories = append(atomEntry.Categories, atomCategory{tag.Label})
+		}
+		doc.Entries = append(doc.Entries, atomEntry)
+	}
+	return doc
+}
+
+// writeFeed write feed in the format of request path extension
+func writeFeed(w http.ResponseWriter, r *http.Request, f *feed, tag string) *apiError {
+	var doc interface{}
+	if strings.HasSuffix(r.URL.Path, ".rss") {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		doc = f.rss()
+	} else {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		doc = f.atom()
+	}
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return &apiError{
+			tag + ".MarshalIndent",
+			err,
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+	w.Header().Set("Cache-Control", "public, max-age=900")
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+	return nil
+}
+
+// newestFeed build a feed of newest quotes matching filters
+func newestFeed(r *http.Request, dbUtils *DatabaseUtils, tag_label, twitter_username string) (*feed, error) {
+	quotes, created, err := dbUtils.NewestQuotes(tag_label, twitter_username, feedSize)
+	if err != nil {
+		return nil, err
+	}
+	base := baseURL(r)
+	f := &feed{
+		Id:          "tag:" + feedTagAuthority + ":" + strings.TrimPrefix(r.URL.Path, "/"),
+		Title:       "Wisdom",
+		Description: "Newest quotes",
+		Link:        "http://gophergala.github.io/wisdom",
+		Self:        base + r.URL.Path,
+		Updated:     time.Unix(0, 0).UTC(),
+	}
+	for i, quote := range quotes {
+		f.Entries = append(f.Entries, feedEntry{
+			Id:      "tag:" + feedTagAuthority + ":quote:" + strconv.Itoa(quote.Id),
+			Quote:   quote,
+			Updated: created[i].UTC(),
+		})
+		if created[i].After(f.Updated) {
+			f.Updated = created[i].UTC()
+		}
+	}
+	return f, nil
+}
+
+// /v1/feed.rss and /v1/feed.atom endpoints. newest quotes
+func feedHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	f, err := newestFeed(r, dbUtils, "", "")
+	if err != nil {
+		return databaseError("feedHandler.newestFeed", err)
+	}
+	return writeFeed(w, r, f, "feedHandler")
+}
+
+// /v1/tag/{label}/feed.rss and /v1/tag/{label}/feed.atom endpoints. newest
+// quotes with tag
+func tagFeedHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	tag, err := dbUtils.TagByLabel(mux.Vars(r)["label"])
+	if err != nil {
+		return databaseError("tagFeedHandler.TagByLabel", err)
+	}
+	f, err := newestFeed(r, dbUtils, tag.Label, "")
+	if err != nil {
+		return databaseError("tagFeedHandler.newestFeed", err)
+	}
+	f.Title = "Wisdom: " + tag.Label
+	f.Description = "Newest quotes tagged " + tag.Label
+	return writeFeed(w, r, f, "tagFeedHandler")
+}
+
+// /v1/author/{twitter_username}/feed.rss and
+// /v1/author/{twitter_username}/feed.atom endpoints. newest quotes by author
+func authorFeedHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	author, err := dbUtils.AuthorByTwitterUsername(mux.Vars(r)["twitter_username"])
+	if err != nil {
+		return databaseError("authorFeedHandler.AuthorByTwitterUsername", err)
+	}
+	f, err := newestFeed(r, dbUtils, "", author.Twitter)
+	if err != nil {
+		return databaseError("authorFeedHandler.newestFeed", err)
+	}
+	f.Title = "Wisdom: " + author.Name
+	f.Description = "Newest quotes by " + author.Name
+	return writeFeed(w, r, f, "authorFeedHandler")
+}
+
+// /v1/qotd.rss and /v1/qotd.atom endpoints. quote of the day of the last
+// days, one entry per day
+func qotdFeedHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	f := &feed{
+		Id:          "tag:" + feedTagAuthority + ":qotd",
+		Title:       "Wisdom: quote of the day",
+		Description: "A quote every day",
+		Link:        "http://gophergala.github.io/wisdom",
+		Self:        baseURL(r) + r.URL.Path,
+		Updated:     today,
+	}
+	days := make([]time.Time, qotdFeedDays)
+	for i := range days {
+		days[i] = today.AddDate(0, 0, -i)
+	}
+	quotes, err := dbUtils.QuotesOfTheDays(days, "")
+	if err != nil {
+		return databaseError("qotdFeedHandler.QuotesOfTheDays", err)
+	}
+	for i, day := range days {
+		f.Entries = append(f.Entries, feedEntry{
+			Id:      "tag:" + feedTagAuthority + ":qotd:" + day.Format("2006-01-02"),
+			Quote:   quotes[i],
+			Updated: day,
+			Prefix:  day.Format("2006-01-02") + " ",
+		})
+	}
+	// quote of the day change at midnight UTC
+	w.Header().Set("Expires", today.AddDate(0, 0, 1).Format(http.TimeFormat))
+	return writeFeed(w, r, f, "qotdFeedHandler")
+}
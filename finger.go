@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+var (
+	// enable the Finger server if not empty
+	FINGER_ENABLED = os.Getenv("FINGER_ENABLED")
+	// port of the Finger server, default 79
+	FINGER_PORT = os.Getenv("FINGER_PORT")
+)
+
+const fingerDefaultPort = "79"
+
+// fingerText convert text to ASCII lines ending with CRLF. Control
+// characters are never sent, see RFC 1288 section 3.3
+func fingerText(text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		b.WriteString(fontText(line) + "\r\n")
+	}
+	return b.String()
+}
+
+// fingerServer answer `finger <twitter_username>@host` with a random quote
+// by the author, and `finger @host` with the list of authors, see RFC 1288
+type fingerServer struct {
+	dbUtils *DatabaseUtils
+}
+
+// respond return the response of a query line
+func (s *fingerServer) respond(query string) string {
+	query = strings.TrimSpace(query)
+	// verbose flag, same response
+	if query == "/W" || strings.HasPrefix(query, "/W ") {
+		query = strings.TrimSpace(strings.TrimPrefix(query, "/W"))
+	}
+	if strings.Contains(query, "@") {
+		return fingerText("finger: forwarding service denied")
+	}
+	response, err := s.query(query)
+	switch err {
+	case nil:
+		return fingerText(response)
+	case errAuthorNotFound, errQuoteNotFound:
+		return fingerText("finger: " + query + ": no such user.")
+	}
+	log.Printf("finger %q: %s", query, err)
+	return fingerText("finger: error happen, try again later")
+}
+
+func (s *fingerServer) query(username string) (string, error) {
+	if username == "" {
+		authors, err := s.dbUtils.Authors()
+		if err != nil {
+			return "", err
+		}
+		lines := []string{"Login                Name"}
+		for _, author := range authors {
+			lines = append(lines, author.Twitter+strings.Repeat(" ", max(1, 21-len(author.Twitter)))+author.Name)
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	author, err := s.dbUtils.AuthorByTwitterUsername(username)
+	if err != nil {
+		return "", err
+	}
+	quote, err := s.dbUtils.RandomQuoteFiltered("", author.Twitter)
+	if err != nil {
+		return "", err
+	}
+	text := "Login: " + author.Twitter + strings.Repeat(" ", max(1, 25-len(author.Twitter))) + "Name: " + author.Name + "\n"
+	if author.Company != "" {
+		text += "Company: " + author.Company + "\n"
+	}
+	text += "Plan:\n" + quotePlain(quote, textDefaultWidth)
+	return text, nil
+}
+
+// serveFinger listen on TCP port
+func serveFinger(port string, dbUtils *DatabaseUtils) error {
+	if port == "" {
+		port = fingerDefaultPort
+	}
+	s := &fingerServer{dbUtils}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	log.Printf("Finger listening on :%s", port)
+	return serveLines("finger", listener, s.respond)
+}
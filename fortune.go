@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// width of fortune text, fortune(6) print fortunes as is
+	fortuneWidth = 72
+	// version of strfile(1) data files
+	fortuneDatVersion = 2
+	fortuneDelimiter  = '%'
+	// quotes loaded per query by export
+	exportPageSize = 500
+)
+
+// fortuneText return quote in fortune(6) form, wrapped text followed by
+// attribution lines of the author and company. A line of only `%` would end
+// the fortune, it is indented
+func fortuneText(quote *Quote) string {
+	var b strings.Builder
+	for _, paragraph := range strings.Split(strings.TrimSpace(plainText(quote.Content)), "\n") {
+		for _, line := range wrapWords(paragraph, fortuneWidth) {
+			if line == string(fortuneDelimiter) {
+				line = " " + line
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	b.WriteString("\t\t-- " + plainText(quote.Author.Name) + "\n")
+	if quote.Author.Company != "" {
+		b.WriteString("\t\t   " + plainText(quote.Author.Company) + "\n")
+	}
+	return b.String()
+}
+
+// fortuneFile write fortunes separated by `%` lines, and return offsets of
+// every fortune followed by the file length, see strfile(1)
+func fortuneFile(w io.Writer, fortunes []string) ([]uint32, error) {
+	var offsets []uint32
+	var offset uint32
+	for _, fortune := range fortunes {
+		offsets = append(offsets, offset)
+		n, err := io.WriteString(w, fortune+string(fortuneDelimiter)+"\n")
+		if err != nil {
+			return nil, err
+		}
+		offset += uint32(n)
+	}
+	return append(offsets, offset), nil
+}
+
+// fortuneDat write the strfile(1) data file of fortunes. Header fields and
+// offsets are big-endian 32 bits integers
+func fortuneDat(w io.Writer, fortunes []string, offsets []uint32) error {
+	var longest, shortest uint32
+	for i, fortune := range fortunes {
+		length := uint32(len(fortune))
+		if length > longest {
+			longest = length
+		}
+		if i == 0 || length < shortest {
+			shortest = length
+		}
+	}
+	header := []interface{}{
+		uint32(fortuneDatVersion),
+		uint32(len(fortunes)),
+		longest,
+		shortest,
+		// flags, not random, ordered or rotated
+		uint32(0),
+		// delimiter and padding
+		[4]byte{fortuneDelimiter},
+		offsets,
+	}
+	for _, field := range header {
+		if err := binary.Write(w, binary.BigEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile create path and write it with write
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(f)
+	err = write(buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runExport is the `export` command, it write every quote to a file
+//
+//	wisdom export --format=fortune --output=wisdom
+//
+// write the `wisdom` fortune file and its `wisdom.dat` index, ready for
+// `fortune wisdom`
+func runExport(dbUtils *DatabaseUtils, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "fortune", "export format, only fortune is supported")
+	output := flags.String("output", "wisdom", "output file, the fortune index is written to <output>.dat")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "fortune" {
+		return errors.New("unsupported format " + *format)
+	}
+
+	var fortunes []string
+	for offset := 0; ; offset += exportPageSize {
+		quotes, _, err := dbUtils.Quotes(exportPageSize, offset)
+		if err != nil {
+			return err
+		}
+		for _, quote := range quotes {
+			fortunes = append(fortunes, fortuneText(quote))
+		}
+		if len(quotes) < exportPageSize {
+			break
+		}
+	}
+
+	var offsets []uint32
+	err := writeFile(*output, func(w io.Writer) error {
+		var err error
+		offsets, err = fortuneFile(w, fortunes)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = writeFile(*output+".dat", func(w io.Writer) error {
+		return fortuneDat(w, fortunes, offsets)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d quotes exported to %s and %s.dat\n", len(fortunes), *output, *output)
+	return nil
+}
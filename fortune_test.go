@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFortuneText(t *testing.T) {
+	tests := []struct {
+		quote *Quote
+		want  string
+	}{
+		{
+			&Quote{Content: "Make something people want.", Author: Author{Name: "Paul Graham", Company: "Y Combinator"}},
+			"Make something people want.\n\t\t-- Paul Graham\n\t\t   Y Combinator\n",
+		},
+		// a line of only % would end the fortune
+		{
+			&Quote{Content: "100\n%\nof the time", Author: Author{Name: "Anonymous"}},
+			"100\n %\nof the time\n\t\t-- Anonymous\n",
+		},
+		{
+			&Quote{Content: strings.Repeat("word ", 20), Author: Author{Name: "Anonymous"}},
+			strings.Repeat("word ", 13) + "word\n" + strings.Repeat("word ", 5) + "word\n\t\t-- Anonymous\n",
+		},
+	}
+	for _, test := range tests {
+		if got := fortuneText(test.quote); got != test.want {
+			t.Errorf("fortuneText(%q) = %q, want %q", test.quote.Content, got, test.want)
+		}
+	}
+}
+
+func TestFortuneFile(t *testing.T) {
+	fortunes := []string{"a\n", "bcd\n"}
+	var file, dat bytes.Buffer
+	offsets, err := fortuneFile(&file, fortunes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := file.String(), "a\n%\nbcd\n%\n"; got != want {
+		t.Errorf("fortuneFile() wrote %q, want %q", got, want)
+	}
+	if err := fortuneDat(&dat, fortunes, offsets); err != nil {
+		t.Fatal(err)
+	}
+	// struct strfile of strfile(1), followed by the offset of every fortune
+	// and the file length
+	want := []byte{
+		0, 0, 0, 2, // str_version
+		0, 0, 0, 2, // str_numstr
+		0, 0, 0, 4, // str_longlen
+		0, 0, 0, 2, // str_shortlen
+		0, 0, 0, 0, // str_flags
+		'%', 0, 0, 0, // str_delim and padding
+		0, 0, 0, 0,
+		0, 0, 0, 4,
+		0, 0, 0, 10,
+	}
+	if !bytes.Equal(dat.Bytes(), want) {
+		t.Errorf("fortuneDat() wrote\n%x\nwant\n%x", dat.Bytes(), want)
+	}
+
+	dat.Reset()
+	offsets, _ = fortuneFile(&file, nil)
+	if err := fortuneDat(&dat, nil, offsets); err != nil {
+		t.Fatal(err)
+	}
+	want = []byte{0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, '%', 0, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(dat.Bytes(), want) {
+		t.Errorf("fortuneDat() of no fortunes wrote %x, want %x", dat.Bytes(), want)
+	}
+}
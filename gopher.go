@@ -0,0 +1,235 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// enable the Gopher server if not empty
+	GOPHER_ENABLED = os.Getenv("GOPHER_ENABLED")
+	// port of the Gopher server, default 70
+	GOPHER_PORT = os.Getenv("GOPHER_PORT")
+	// host name in Gopher menus, default PUBLIC_URL host or localhost
+	GOPHER_HOST = os.Getenv("GOPHER_HOST")
+)
+
+const (
+	gopherDefaultPort = "70"
+	// length of quotes in menus
+	gopherDisplayLength = 70
+)
+
+// gopherServer serve menus of authors and tags, and quotes as text
+// documents, see RFC 1436. Selectors:
+//
+//	""                      root menu
+//	/authors                menu of authors
+//	/author/<twitter>       menu of quotes by author
+//	/tags                   menu of tags
+//	/tag/<label>            menu of quotes with tag
+//	/quote/<id>             quote
+//	/random                 random quote
+type gopherServer struct {
+	host    string
+	port    string
+	dbUtils *DatabaseUtils
+}
+
+// gopherMenu write menu items, see RFC 1436 section 3.8
+type gopherMenu struct {
+	host  string
+	port  string
+	items strings.Builder
+}
+
+// item add an item, tabs and line breaks of display are replaced by spaces
+func (m *gopherMenu) item(item_type byte, display, selector string) {
+	m.items.WriteByte(item_type)
+	m.items.WriteString(plainText(strings.Replace(display, "\n", " ", -1)) + "\t" + selector + "\t" + m.host + "\t" + m.port + "\r\n")
+}
+
+// info add an informational line
+func (m *gopherMenu) info(text string) {
+	m.items.WriteString("i" + plainText(text) + "\tfake\t(NULL)\t0\r\n")
+}
+
+// String return the menu with its last line
+func (m *gopherMenu) String() string {
+	return m.items.String() + ".\r\n"
+}
+
+// gopherError return an error menu
+func gopherError(message string) string {
+	return "3" + message + "\terror\terror.host\t1\r\n.\r\n"
+}
+
+// gopherText return a text document, lines starting with a dot are
+// escaped with another dot
+func gopherText(text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if strings.HasPrefix(line, ".") {
+			line = "." + line
+		}
+		b.WriteString(line + "\r\n")
+	}
+	b.WriteString(".\r\n")
+	return b.String()
+}
+
+// gopherQuote return a quote document, the quote followed by its author
+// and original post
+func gopherQuote(quote *Quote) string {
+	text := quotePlain(quote, textDefaultWidth)
+	if quote.Permalink != "" {
+		text += "\n" + quote.Permalink + "\n"
+	}
+	return gopherText(text)
+}
+
+// menu create an empty menu of the server
+func (s *gopherServer) menu() *gopherMenu {
+	return &gopherMenu{host: s.host, port: s.port}
+}
+
+// quotesMenu return a menu of quotes
+func (s *gopherServer) quotesMenu(title string, quotes []*Quote) string {
+	m := s.menu()
+	m.info(title)
+	m.info("")
+	for _, quote := range quotes {
+		display := quote.Content
+		if runes := []rune(display); len(runes) > gopherDisplayLength {
+			display = strings.TrimSpace(string(runes[:gopherDisplayLength-1])) + "…"
+		}
+		m.item('0', display, "/quote/"+strconv.Itoa(quote.Id))
+	}
+	return m.String()
+}
+
+// respond return the response of a selector
+func (s *gopherServer) respond(selector string) string {
+	// search terms of type 7 items are ignored
+	if i := strings.IndexByte(selector, '\t'); i >= 0 {
+		selector = selector[:i]
+	}
+	response, err := s.selector(selector)
+	switch err {
+	case nil:
+		return response
+	case errQuoteNotFound, errAuthorNotFound, errTagNotFound:
+		return gopherError("'" + selector + "' doesn't exist")
+	}
+	log.Printf("gopher %q: %s", selector, err)
+	return gopherError("error happen, try again later")
+}
+
+func (s *gopherServer) selector(selector string) (string, error) {
+	switch {
+	case selector == "" || selector == "/":
+		m := s.menu()
+		m.info("Wisdom: quotes from tech leaders")
+		m.info("")
+		m.item('0', "Random quote", "/random")
+		m.item('1', "Authors", "/authors")
+		m.item('1', "Tags", "/tags")
+		return m.String(), nil
+
+	case selector == "/random":
+		quote, err := s.dbUtils.RandomQuote()
+		if err != nil {
+			return "", err
+		}
+		return gopherQuote(quote), nil
+
+	case strings.HasPrefix(selector, "/quote/"):
+		id, err := strconv.Atoi(strings.TrimPrefix(selector, "/quote/"))
+		if err != nil {
+			return "", errQuoteNotFound
+		}
+		quote, err := s.dbUtils.QuoteById(id)
+		if err != nil {
+			return "", err
+		}
+		return gopherQuote(quote), nil
+
+	case selector == "/authors":
+		authors, err := s.dbUtils.Authors()
+		if err != nil {
+			return "", err
+		}
+		m := s.menu()
+		m.info("Authors")
+		m.info("")
+		for _, author := range authors {
+			display := author.Name
+			if author.Company != "" {
+				display += ", " + author.Company
+			}
+			m.item('1', display, "/author/"+author.Twitter)
+		}
+		return m.String(), nil
+
+	case strings.HasPrefix(selector, "/author/"):
+		author, err := s.dbUtils.AuthorByTwitterUsername(strings.TrimPrefix(selector, "/author/"))
+		if err != nil {
+			return "", err
+		}
+		quotes, err := s.dbUtils.QuotesByAuthorId(author.Id)
+		if err != nil {
+			return "", err
+		}
+		return s.quotesMenu("Quotes by "+author.Name, quotes), nil
+
+	case selector == "/tags":
+		tags, err := s.dbUtils.Tags()
+		if err != nil {
+			return "", err
+		}
+		m := s.menu()
+		m.info("Tags")
+		m.info("")
+		for _, tag := range tags {
+			m.item('1', tag.Label, "/tag/"+tag.Label)
+		}
+		return m.String(), nil
+
+	case strings.HasPrefix(selector, "/tag/"):
+		tag, err := s.dbUtils.TagByLabel(strings.TrimPrefix(selector, "/tag/"))
+		if err != nil {
+			return "", err
+		}
+		quotes, err := s.dbUtils.QuotesByTagIds([]int{tag.Id})
+		if err != nil {
+			return "", err
+		}
+		return s.quotesMenu("Quotes tagged "+tag.Label, quotes[tag.Id]), nil
+	}
+	return "", errQuoteNotFound
+}
+
+// serveGopher listen on TCP port
+func serveGopher(port string, dbUtils *DatabaseUtils) error {
+	if port == "" {
+		port = gopherDefaultPort
+	}
+	host := GOPHER_HOST
+	if host == "" {
+		host = "localhost"
+		if u, err := url.Parse(PUBLIC_URL); err == nil && u.Hostname() != "" {
+			host = u.Hostname()
+		}
+	}
+	s := &gopherServer{host, port, dbUtils}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	log.Printf("Gopher listening on :%s", port)
+	return serveLines("gopher", listener, s.respond)
+}
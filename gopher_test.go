@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGopherText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"hello\nworld\n", "hello\r\nworld\r\n.\r\n"},
+		// lines starting with a dot are escaped, the last line is a lone dot
+		{".\n", "..\r\n.\r\n"},
+		{"a\n.b\n..c\n. d", "a\r\n..b\r\n...c\r\n.. d\r\n.\r\n"},
+		{"not. a dot\n", "not. a dot\r\n.\r\n"},
+		{"trailing\n\n\n", "trailing\r\n.\r\n"},
+	}
+	for _, test := range tests {
+		if got := gopherText(test.text); got != test.want {
+			t.Errorf("gopherText(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestGopherQuote(t *testing.T) {
+	quote := &Quote{Content: "...and then\n.profit", Author: Author{Name: "Anonymous"}, Permalink: "https://example.com/post/1"}
+	text := gopherQuote(quote)
+	if !strings.HasSuffix(text, "\r\nhttps://example.com/post/1\r\n.\r\n") {
+		t.Errorf("gopherQuote() = %q, want the permalink and a lone dot last", text)
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\r\n"), "\r\n")
+	for _, line := range lines[:len(lines)-1] {
+		if strings.HasPrefix(line, ".") && !strings.HasPrefix(line, "..") {
+			t.Errorf("gopherQuote() has unescaped line %q", line)
+		}
+	}
+}
+
+func TestGopherMenu(t *testing.T) {
+	s := &gopherServer{host: "gopher.example.com", port: "70"}
+	m := s.menu()
+	m.info("Authors")
+	m.item('1', "Paul Graham,\nY Combinator", "/author/paulg")
+	want := "iAuthors\tfake\t(NULL)\t0\r\n" +
+		"1Paul Graham, Y Combinator\t/author/paulg\tgopher.example.com\t70\r\n" +
+		".\r\n"
+	if got := m.String(); got != want {
+		t.Errorf("menu = %q, want %q", got, want)
+	}
+
+	menu := s.quotesMenu("Quotes", []*Quote{{Id: 7, Content: strings.Repeat("a", 100)}})
+	if !strings.Contains(menu, "0"+strings.Repeat("a", gopherDisplayLength-1)+"…\t/quote/7\t") {
+		t.Errorf("quotesMenu() doesn't truncate long quotes: %q", menu)
+	}
+
+	// search terms are ignored, unknown selectors are errors
+	if got := s.respond("\tsearch"); !strings.HasPrefix(got, "iWisdom") {
+		t.Errorf("respond() of the root menu = %q", got)
+	}
+	if got, want := s.respond("/nope"), "3'/nope' doesn't exist\terror\terror.host\t1\r\n.\r\n"; got != want {
+		t.Errorf("respond(\"/nope\") = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,1368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file implement the subset of GraphQL needed by the /graphql
+// endpoint: query operations, variables, aliases, fragments and the
+// @skip/@include directives. Fields are resolved in batch, a resolver
+// receive every parent object at the same depth so relationships are
+// loaded with one query per field instead of one query per object.
+
+// maximum depth of selection sets
+const gqlMaxDepth = 10
+
+// maximum estimated number of objects loaded by a query, the product of the
+// list sizes along every path
+const gqlMaxCost = 10000
+
+// gqlError define structure of GraphQL error
+type gqlError struct {
+	Message   string        `json:"message"`
+	Locations []gqlLocation `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+// gqlLocation is a position in query document
+type gqlLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// --- lexer ---
+
+const (
+	gqlTokenEOF = iota
+	gqlTokenPunct
+	gqlTokenName
+	gqlTokenInt
+	gqlTokenFloat
+	gqlTokenString
+)
+
+type gqlToken struct {
+	kind  int
+	value string
+	loc   gqlLocation
+}
+
+type gqlLexer struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+func (l *gqlLexer) errorf(format string, args ...interface{}) error {
+	return &gqlSyntaxError{fmt.Sprintf(format, args...), gqlLocation{l.line, l.col}}
+}
+
+// gqlSyntaxError is an error in query document
+type gqlSyntaxError struct {
+	message string
+	loc     gqlLocation
+}
+
+func (e *gqlSyntaxError) Error() string {
+	return fmt.Sprintf("Syntax Error: %s (line %d, column %d)", e.message, e.loc.Line, e.loc.Column)
+}
+
+func (l *gqlLexer) advance(n int) {
+	for i := 0; i < n; i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.pos++
+	}
+}
+
+// skipIgnored skip whitespace, commas, comments and BOM
+func (l *gqlLexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.advance(1)
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+		case strings.HasPrefix(l.src[l.pos:], "\ufeff"):
+			l.pos += len("\ufeff")
+		default:
+			return
+		}
+	}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *gqlLexer) next() (gqlToken, error) {
+	l.skipIgnored()
+	loc := gqlLocation{l.line, l.col}
+	if l.pos >= len(l.src) {
+		return gqlToken{gqlTokenEOF, "", loc}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$():=@[]{}|&", c) >= 0:
+		l.advance(1)
+		return gqlToken{gqlTokenPunct, string(c), loc}, nil
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.advance(3)
+		return gqlToken{gqlTokenPunct, "...", loc}, nil
+	case isNameStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && (isNameStart(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.advance(1)
+		}
+		return gqlToken{gqlTokenName, l.src[start:l.pos], loc}, nil
+	case c == '-' || isDigit(c):
+		return l.number(loc)
+	case c == '"':
+		return l.string(loc)
+	}
+	return gqlToken{}, l.errorf("unexpected character %q", c)
+}
+
+func (l *gqlLexer) number(loc gqlLocation) (gqlToken, error) {
+	start := l.pos
+	kind := gqlTokenInt
+	if l.src[l.pos] == '-' {
+		l.advance(1)
+	}
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.advance(1)
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		return gqlToken{}, l.errorf("invalid number")
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = gqlTokenFloat
+		l.advance(1)
+		if digits() == 0 {
+			return gqlToken{}, l.errorf("invalid number")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = gqlTokenFloat
+		l.advance(1)
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.advance(1)
+		}
+		if digits() == 0 {
+			return gqlToken{}, l.errorf("invalid number")
+		}
+	}
+	return gqlToken{kind, l.src[start:l.pos], loc}, nil
+}
+
+func (l *gqlLexer) string(loc gqlLocation) (gqlToken, error) {
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		l.advance(3)
+		end := strings.Index(l.src[l.pos:], `"""`)
+		if end < 0 {
+			return gqlToken{}, l.errorf("unterminated string")
+		}
+		value := l.src[l.pos : l.pos+end]
+		l.advance(end + 3)
+		return gqlToken{gqlTokenString, strings.TrimSpace(value), loc}, nil
+	}
+
+	l.advance(1)
+	var buf bytes.Buffer
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return gqlToken{}, l.errorf("unterminated string")
+		}
+		c := l.src[l.pos]
+		if c == '"' {
+			l.advance(1)
+			return gqlToken{gqlTokenString, buf.String(), loc}, nil
+		}
+		if c != '\\' {
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			buf.WriteRune(r)
+			l.pos += size
+			l.col++
+			continue
+		}
+		if l.pos+1 >= len(l.src) {
+			return gqlToken{}, l.errorf("unterminated string")
+		}
+		escape := l.src[l.pos+1]
+		l.advance(2)
+		switch escape {
+		case '"', '\\', '/':
+			buf.WriteByte(escape)
+		case 'b':
+			buf.WriteByte('\b')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'u':
+			if l.pos+4 > len(l.src) {
+				return gqlToken{}, l.errorf("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+			if err != nil {
+				return gqlToken{}, l.errorf("invalid unicode escape")
+			}
+			buf.WriteRune(rune(code))
+			l.advance(4)
+		default:
+			return gqlToken{}, l.errorf("invalid escape \\%c", escape)
+		}
+	}
+}
+
+// --- parser ---
+
+// gqlDocument is a parsed query document
+type gqlDocument struct {
+	Operations []*gqlOperation
+	Fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	Type       string
+	Name       string
+	Variables  []*gqlVariableDefinition
+	Selections []gqlSelection
+	Loc        gqlLocation
+}
+
+type gqlVariableDefinition struct {
+	Name    string
+	Type    string
+	Default gqlValue
+}
+
+type gqlFragment struct {
+	Name          string
+	TypeCondition string
+	Selections    []gqlSelection
+}
+
+// gqlSelection is *gqlField, *gqlFragmentSpread or *gqlInlineFragment
+type gqlSelection interface{}
+
+type gqlField struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]gqlValue
+	Directives []*gqlDirective
+	Selections []gqlSelection
+	Loc        gqlLocation
+}
+
+// responseKey return alias if any, otherwise field name
+func (f *gqlField) responseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type gqlFragmentSpread struct {
+	Name       string
+	Directives []*gqlDirective
+	Loc        gqlLocation
+}
+
+type gqlInlineFragment struct {
+	TypeCondition string
+	Directives    []*gqlDirective
+	Selections    []gqlSelection
+}
+
+type gqlDirective struct {
+	Name      string
+	Arguments map[string]gqlValue
+}
+
+// gqlValue is an input value literal. Variables are gqlVariable, lists are
+// []gqlValue, objects are map[string]gqlValue and enums are gqlEnum
+type gqlValue interface{}
+
+type gqlVariable string
+
+type gqlEnum string
+
+type gqlParser struct {
+	lexer *gqlLexer
+	token gqlToken
+}
+
+// parseGraphQL parse a query document
+func parseGraphQL(src string) (*gqlDocument, error) {
+	p := &gqlParser{lexer: &gqlLexer{src: src, line: 1, col: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &gqlDocument{Fragments: make(map[string]*gqlFragment)}
+	for p.token.kind != gqlTokenEOF {
+		switch {
+		case p.peek(gqlTokenPunct, "{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &gqlOperation{Type: "query", Selections: selections})
+		case p.peek(gqlTokenName, "fragment"):
+			fragment, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.Fragments[fragment.Name]; ok {
+				return nil, fmt.Errorf("There can be only one fragment named %q.", fragment.Name)
+			}
+			doc.Fragments[fragment.Name] = fragment
+		case p.token.kind == gqlTokenName:
+			operation, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, operation)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, errors.New("Document must contain at least one operation.")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) advance() error {
+	token, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return nil
+}
+
+func (p *gqlParser) peek(kind int, value string) bool {
+	return p.token.kind == kind && p.token.value == value
+}
+
+func (p *gqlParser) unexpected() error {
+	if p.token.kind == gqlTokenEOF {
+		return &gqlSyntaxError{"unexpected end of document", p.token.loc}
+	}
+	return &gqlSyntaxError{fmt.Sprintf("unexpected %q", p.token.value), p.token.loc}
+}
+
+// expect consume a token of given kind and value
+func (p *gqlParser) expect(kind int, value string) error {
+	if !p.peek(kind, value) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+// skip consume the token if it match, return true if consumed
+func (p *gqlParser) skip(kind int, value string) (bool, error) {
+	if !p.peek(kind, value) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.token.kind != gqlTokenName {
+		return "", p.unexpected()
+	}
+	name := p.token.value
+	return name, p.advance()
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	operation := &gqlOperation{Loc: p.token.loc}
+	var err error
+	operation.Type, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+	if operation.Type != "query" && operation.Type != "mutation" && operation.Type != "subscription" {
+		return nil, &gqlSyntaxError{fmt.Sprintf("unexpected %q", operation.Type), operation.Loc}
+	}
+	if p.token.kind == gqlTokenName {
+		operation.Name, _ = p.name()
+	}
+	if p.peek(gqlTokenPunct, "(") {
+		operation.Variables, err = p.variableDefinitions()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	operation.Selections, err = p.selectionSet()
+	return operation, err
+}
+
+func (p *gqlParser) variableDefinitions() ([]*gqlVariableDefinition, error) {
+	var definitions []*gqlVariableDefinition
+	if err := p.expect(gqlTokenPunct, "("); err != nil {
+		return nil, err
+	}
+	for !p.peek(gqlTokenPunct, ")") {
+		if err := p.expect(gqlTokenPunct, "$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(gqlTokenPunct, ":"); err != nil {
+			return nil, err
+		}
+		typ, err := p.typeReference()
+		if err != nil {
+			return nil, err
+		}
+		definition := &gqlVariableDefinition{Name: name, Type: typ}
+		if ok, err := p.skip(gqlTokenPunct, "="); err != nil {
+			return nil, err
+		} else if ok {
+			definition.Default, err = p.value(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions, p.advance()
+}
+
+// typeReference parse a type like `Int`, `String!` or `[Int!]!`
+func (p *gqlParser) typeReference() (string, error) {
+	var typ string
+	if ok, err := p.skip(gqlTokenPunct, "["); err != nil {
+		return "", err
+	} else if ok {
+		inner, err := p.typeReference()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect(gqlTokenPunct, "]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if ok, err := p.skip(gqlTokenPunct, "!"); err != nil {
+		return "", err
+	} else if ok {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *gqlParser) fragment() (*gqlFragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, p.unexpected()
+	}
+	if !p.peek(gqlTokenName, "on") {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	typeCondition, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &gqlFragment{name, typeCondition, selections}, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect(gqlTokenPunct, "{"); err != nil {
+		return nil, err
+	}
+	var selections []gqlSelection
+	for !p.peek(gqlTokenPunct, "}") {
+		selection, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, p.unexpected()
+	}
+	return selections, p.advance()
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	if p.peek(gqlTokenPunct, "...") {
+		loc := p.token.loc
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.token.kind == gqlTokenName && p.token.value != "on" {
+			name, _ := p.name()
+			directives, err := p.directives()
+			if err != nil {
+				return nil, err
+			}
+			return &gqlFragmentSpread{name, directives, loc}, nil
+		}
+		fragment := &gqlInlineFragment{}
+		if p.peek(gqlTokenName, "on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			var err error
+			fragment.TypeCondition, err = p.name()
+			if err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		fragment.Directives, err = p.directives()
+		if err != nil {
+			return nil, err
+		}
+		fragment.Selections, err = p.selectionSet()
+		return fragment, err
+	}
+
+	field := &gqlField{Loc: p.token.loc}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(gqlTokenPunct, ":"); err != nil {
+		return nil, err
+	} else if ok {
+		field.Alias = name
+		name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+	field.Name = name
+	field.Arguments, err = p.arguments()
+	if err != nil {
+		return nil, err
+	}
+	field.Directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(gqlTokenPunct, "{") {
+		field.Selections, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (p *gqlParser) arguments() (map[string]gqlValue, error) {
+	arguments := make(map[string]gqlValue)
+	if ok, err := p.skip(gqlTokenPunct, "("); err != nil || !ok {
+		return arguments, err
+	}
+	for !p.peek(gqlTokenPunct, ")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(gqlTokenPunct, ":"); err != nil {
+			return nil, err
+		}
+		if _, ok := arguments[name]; ok {
+			return nil, fmt.Errorf("There can be only one argument named %q.", name)
+		}
+		arguments[name], err = p.value(false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return arguments, p.advance()
+}
+
+func (p *gqlParser) directives() ([]*gqlDirective, error) {
+	var directives []*gqlDirective
+	for p.peek(gqlTokenPunct, "@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, &gqlDirective{name, arguments})
+	}
+	return directives, nil
+}
+
+// value parse an input value, variables are not allowed in constant value
+func (p *gqlParser) value(constant bool) (gqlValue, error) {
+	token := p.token
+	switch {
+	case token.kind == gqlTokenPunct && token.value == "$" && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return gqlVariable(name), err
+	case token.kind == gqlTokenPunct && token.value == "[":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := []gqlValue{}
+		for !p.peek(gqlTokenPunct, "]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.advance()
+	case token.kind == gqlTokenPunct && token.value == "{":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		object := make(map[string]gqlValue)
+		for !p.peek(gqlTokenPunct, "}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(gqlTokenPunct, ":"); err != nil {
+				return nil, err
+			}
+			object[name], err = p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return object, p.advance()
+	case token.kind == gqlTokenInt:
+		value, err := strconv.ParseInt(token.value, 10, 64)
+		if err != nil {
+			return nil, &gqlSyntaxError{"invalid integer " + token.value, token.loc}
+		}
+		return value, p.advance()
+	case token.kind == gqlTokenFloat:
+		value, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, &gqlSyntaxError{"invalid float " + token.value, token.loc}
+		}
+		return value, p.advance()
+	case token.kind == gqlTokenString:
+		return token.value, p.advance()
+	case token.kind == gqlTokenName:
+		switch token.value {
+		case "true":
+			return true, p.advance()
+		case "false":
+			return false, p.advance()
+		case "null":
+			return nil, p.advance()
+		}
+		return gqlEnum(token.value), p.advance()
+	}
+	return nil, p.unexpected()
+}
+
+// --- schema ---
+
+// gqlResolver resolve a field for every parent object at once. It must
+// return one result per parent, in the same order
+type gqlResolver func(parents []interface{}, args map[string]interface{}) ([]interface{}, error)
+
+// gqlFieldDefinition describe a field of an object type. Type is written
+// in SDL notation, e.g. `Int!`, `[Quote!]!`
+type gqlFieldDefinition struct {
+	Type        string
+	Arguments   map[string]string
+	Description string
+	Resolve     gqlResolver
+}
+
+// gqlSchema is a set of object types, Query is the root type
+type gqlSchema map[string]map[string]*gqlFieldDefinition
+
+// gqlScalars are the built-in scalar types
+var gqlScalars = map[string]bool{"Int": true, "Float": true, "String": true, "Boolean": true, "ID": true}
+
+// namedType strip list and non-null markers from type
+func namedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+// isListType check type is a list type
+func isListType(typ string) bool {
+	return strings.HasPrefix(strings.TrimSuffix(typ, "!"), "[")
+}
+
+// --- execution ---
+
+// gqlObject is a JSON object that keep keys order as requested
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newGqlObject() *gqlObject {
+	return &gqlObject{values: make(map[string]interface{})}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// gqlResponse define structure of GraphQL response
+type gqlResponse struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*gqlError `json:"errors,omitempty"`
+}
+
+// gqlExecution hold state of a single operation execution
+type gqlExecution struct {
+	schema    gqlSchema
+	document  *gqlDocument
+	variables map[string]interface{}
+	errors    []*gqlError
+	// validation state of fragments, see validateFragment
+	fragmentStates map[string]int
+	fragmentDepths map[string]int
+	fragmentCosts  map[string]int
+}
+
+// validation states of fragments
+const (
+	gqlFragmentVisiting = 1
+	gqlFragmentDone     = 2
+)
+
+// executeGraphQL parse, validate and execute a query document
+func executeGraphQL(schema gqlSchema, query, operationName string, variables map[string]interface{}) *gqlResponse {
+	document, err := parseGraphQL(query)
+	if err != nil {
+		return gqlErrorResponse(err)
+	}
+	operation, err := document.operation(operationName)
+	if err != nil {
+		return gqlErrorResponse(err)
+	}
+	if operation.Type != "query" {
+		return gqlErrorResponse(fmt.Errorf("Operation type %q is not supported.", operation.Type))
+	}
+
+	e := &gqlExecution{schema: schema, document: document}
+	e.variables, err = coerceVariables(operation.Variables, variables)
+	if err != nil {
+		return gqlErrorResponse(err)
+	}
+	if errs := e.validateDocument(operation); len(errs) > 0 {
+		return &gqlResponse{Errors: errs}
+	}
+
+	data := e.executeSelections("Query", []interface{}{nil}, operation.Selections, nil)
+	return &gqlResponse{Data: data[0], Errors: e.errors}
+}
+
+func gqlErrorResponse(err error) *gqlResponse {
+	gqlErr := &gqlError{Message: err.Error()}
+	if syntaxErr, ok := err.(*gqlSyntaxError); ok {
+		gqlErr.Locations = []gqlLocation{syntaxErr.loc}
+	}
+	return &gqlResponse{Errors: []*gqlError{gqlErr}}
+}
+
+// operation select the operation to execute
+func (d *gqlDocument) operation(name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(d.Operations) > 1 {
+			return nil, errors.New("Must provide operation name if query contains multiple operations.")
+		}
+		return d.Operations[0], nil
+	}
+	for _, operation := range d.Operations {
+		if operation.Name == name {
+			return operation, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown operation named %q.", name)
+}
+
+// coerceVariables check provided variables against definitions and apply
+// default values
+func coerceVariables(definitions []*gqlVariableDefinition, provided map[string]interface{}) (map[string]interface{}, error) {
+	variables := make(map[string]interface{})
+	for _, definition := range definitions {
+		value, ok := provided[definition.Name]
+		if !ok {
+			if definition.Default != nil {
+				value = definition.Default
+			} else if strings.HasSuffix(definition.Type, "!") {
+				return nil, fmt.Errorf("Variable \"$%s\" of required type %q was not provided.", definition.Name, definition.Type)
+			}
+		}
+		coerced, err := coerceInput(definition.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("Variable \"$%s\" got invalid value: %s", definition.Name, err)
+		}
+		variables[definition.Name] = coerced
+	}
+	return variables, nil
+}
+
+// coerceInput convert JSON or literal value to Go value of given type
+func coerceInput(typ string, value interface{}) (interface{}, error) {
+	if value == nil {
+		if strings.HasSuffix(typ, "!") {
+			return nil, fmt.Errorf("expected non-null value of type %s", typ)
+		}
+		return nil, nil
+	}
+	typ = strings.TrimSuffix(typ, "!")
+	if strings.HasPrefix(typ, "[") {
+		inner := typ[1 : len(typ)-1]
+		var items []interface{}
+		switch list := value.(type) {
+		case []interface{}:
+			items = list
+		case []gqlValue:
+			for _, item := range list {
+				items = append(items, item)
+			}
+		default:
+			items = []interface{}{value}
+		}
+		coerced := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			coerced[i], err = coerceInput(inner, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return coerced, nil
+	}
+	switch typ {
+	case "Int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float64:
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		}
+		return nil, fmt.Errorf("Int cannot represent %v", value)
+	case "Float":
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+		return nil, fmt.Errorf("Float cannot represent %v", value)
+	case "String", "ID":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int64:
+			if typ == "ID" {
+				return strconv.FormatInt(v, 10), nil
+			}
+		}
+		return nil, fmt.Errorf("%s cannot represent %v", typ, value)
+	case "Boolean":
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("Boolean cannot represent %v", value)
+	}
+	return nil, fmt.Errorf("unknown input type %s", typ)
+}
+
+// argumentValues resolve variables in arguments and coerce every argument
+func (e *gqlExecution) argumentValues(definitions map[string]string, arguments map[string]gqlValue) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for name, typ := range definitions {
+		literal, ok := arguments[name]
+		if !ok {
+			if strings.HasSuffix(typ, "!") {
+				return nil, fmt.Errorf("Argument %q of type %q is required.", name, typ)
+			}
+			continue
+		}
+		value, err := e.resolveVariables(literal)
+		if err != nil {
+			return nil, err
+		}
+		coerced, err := coerceInput(typ, value)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %q has invalid value: %s", name, err)
+		}
+		if coerced != nil {
+			values[name] = coerced
+		}
+	}
+	return values, nil
+}
+
+// resolveVariables replace variables in a literal by their values
+func (e *gqlExecution) resolveVariables(literal gqlValue) (interface{}, error) {
+	switch v := literal.(type) {
+	case gqlVariable:
+		return e.variables[string(v)], nil
+	case []gqlValue:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			list[i], err = e.resolveVariables(item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case gqlEnum:
+		return string(v), nil
+	}
+	return literal, nil
+}
+
+// included evaluate @skip and @include directives
+func (e *gqlExecution) included(directives []*gqlDirective) bool {
+	for _, directive := range directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			continue
+		}
+		value, err := e.resolveVariables(directive.Arguments["if"])
+		if err != nil {
+			continue
+		}
+		condition, _ := value.(bool)
+		if directive.Name == "skip" && condition {
+			return false
+		}
+		if directive.Name == "include" && !condition {
+			return false
+		}
+	}
+	return true
+}
+
+// collectFields flatten fragments into an ordered list of fields, fields
+// with the same response key are merged. Fields with the same response key
+// must select the same field with the same arguments, otherwise an error is
+// returned for each conflict and the first field is kept
+func (e *gqlExecution) collectFields(typeName string, selections []gqlSelection, fields []*gqlField, visited map[string]bool) ([]*gqlField, []*gqlError) {
+	var errs []*gqlError
+	for _, selection := range selections {
+		switch s := selection.(type) {
+		case *gqlField:
+			if !e.included(s.Directives) {
+				continue
+			}
+			merged := false
+			for i, field := range fields {
+				if field.responseKey() != s.responseKey() {
+					continue
+				}
+				merged = true
+				if field.Name != s.Name || !reflect.DeepEqual(field.Arguments, s.Arguments) {
+					errs = append(errs, &gqlError{
+						Message:   fmt.Sprintf("Fields %q conflict because they select different fields or arguments. Use different aliases on the fields to fetch both.", s.responseKey()),
+						Locations: []gqlLocation{field.Loc, s.Loc},
+					})
+					break
+				}
+				copied := *field
+				copied.Selections = append(append([]gqlSelection{}, field.Selections...), s.Selections...)
+				fields[i] = &copied
+				break
+			}
+			if !merged {
+				fields = append(fields, s)
+			}
+		case *gqlFragmentSpread:
+			if !e.included(s.Directives) || visited[s.Name] {
+				continue
+			}
+			fragment, ok := e.document.Fragments[s.Name]
+			if !ok || fragment.TypeCondition != typeName {
+				continue
+			}
+			visited[s.Name] = true
+			var fragmentErrs []*gqlError
+			fields, fragmentErrs = e.collectFields(typeName, fragment.Selections, fields, visited)
+			errs = append(errs, fragmentErrs...)
+		case *gqlInlineFragment:
+			if !e.included(s.Directives) {
+				continue
+			}
+			if s.TypeCondition != "" && s.TypeCondition != typeName {
+				continue
+			}
+			var fragmentErrs []*gqlError
+			fields, fragmentErrs = e.collectFields(typeName, s.Selections, fields, visited)
+			errs = append(errs, fragmentErrs...)
+		}
+	}
+	return fields, errs
+}
+
+// validateMerge check fields can be merged in every selection set as they
+// are executed, merged fields included. It walk the query as it's executed,
+// so it must only run once the cost of the query is checked
+func (e *gqlExecution) validateMerge(typeName string, selections []gqlSelection) []*gqlError {
+	fields, errs := e.collectFields(typeName, selections, nil, map[string]bool{})
+	for _, field := range fields {
+		definition, ok := e.schema[typeName][field.Name]
+		if !ok || gqlScalars[namedType(definition.Type)] {
+			continue
+		}
+		errs = append(errs, e.validateMerge(namedType(definition.Type), field.Selections)...)
+	}
+	return errs
+}
+
+// validateDocument validate every fragment definition once, then the
+// operation. Spreads of validated fragments are not validated again, so the
+// cost is linear in the size of the document. Once the operation is valid
+// and under gqlMaxCost, merged fields are checked
+func (e *gqlExecution) validateDocument(operation *gqlOperation) []*gqlError {
+	e.fragmentStates = map[string]int{}
+	e.fragmentDepths = map[string]int{}
+	e.fragmentCosts = map[string]int{}
+	names := make([]string, 0, len(e.document.Fragments))
+	for name := range e.document.Fragments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []*gqlError
+	for _, name := range names {
+		_, _, fragmentErrs := e.validateFragment(name)
+		errs = append(errs, fragmentErrs...)
+	}
+	_, cost, operationErrs := e.validate("Query", operation.Selections, 1)
+	errs = append(errs, operationErrs...)
+	if len(errs) > 0 {
+		return errs
+	}
+	if cost > gqlMaxCost {
+		return []*gqlError{{Message: fmt.Sprintf("Query may load more than %d objects, use smaller limits or fewer nested lists.", gqlMaxCost)}}
+	}
+	return e.validateMerge("Query", operation.Selections)
+}
+
+// validateFragment validate a fragment definition and return the nesting
+// depth and the cost of its selections. A fragment spread while it's
+// visited is a cycle. Fragments already validated return their depth and
+// cost and no error
+func (e *gqlExecution) validateFragment(name string) (int, int, []*gqlError) {
+	if e.fragmentStates[name] == gqlFragmentDone {
+		return e.fragmentDepths[name], e.fragmentCosts[name], nil
+	}
+	fragment := e.document.Fragments[name]
+	e.fragmentStates[name] = gqlFragmentVisiting
+	defer func() { e.fragmentStates[name] = gqlFragmentDone }()
+	if _, ok := e.schema[fragment.TypeCondition]; !ok {
+		return 0, 0, []*gqlError{{Message: fmt.Sprintf("Unknown type %q.", fragment.TypeCondition)}}
+	}
+	depth, cost, errs := e.validate(fragment.TypeCondition, fragment.Selections, 1)
+	e.fragmentDepths[name] = depth
+	e.fragmentCosts[name] = cost
+	return depth, cost, errs
+}
+
+// listSize estimate the number of objects of a field per parent object:
+// the `limit` argument of lists, or a default page
+func (e *gqlExecution) listSize(definition *gqlFieldDefinition, field *gqlField) int {
+	if !isListType(definition.Type) {
+		return 1
+	}
+	size := defaultPerPage
+	// limits out of range are rejected by resolvers
+	if literal, ok := field.Arguments["limit"]; ok {
+		value, _ := e.resolveVariables(literal)
+		coerced, _ := coerceInput("Int", value)
+		if limit, ok := coerced.(int); ok && limit >= 0 && limit <= maxPerPage {
+			size = limit
+		}
+	}
+	// every selection is counted, even of empty lists
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// validate check every selected field exist, object fields have
+// selections, leaf fields don't and arguments are known. It return the
+// deepest nesting level of selections and the cost of selections, the
+// estimated number of objects loaded per parent object. Costs over
+// gqlMaxCost are capped to gqlMaxCost+1
+func (e *gqlExecution) validate(typeName string, selections []gqlSelection, depth int) (int, int, []*gqlError) {
+	var errs []*gqlError
+	if depth > gqlMaxDepth {
+		return depth, 0, []*gqlError{{Message: fmt.Sprintf("Query is nested deeper than %d levels.", gqlMaxDepth)}}
+	}
+	deepest := depth
+	cost := 0
+	addCost := func(c int) {
+		cost += c
+		if cost > gqlMaxCost {
+			cost = gqlMaxCost + 1
+		}
+	}
+	for _, selection := range selections {
+		switch s := selection.(type) {
+		case *gqlField:
+			if s.Name == "__typename" {
+				continue
+			}
+			definition, ok := e.schema[typeName][s.Name]
+			loc := []gqlLocation{s.Loc}
+			if !ok {
+				errs = append(errs, &gqlError{Message: fmt.Sprintf("Cannot query field %q on type %q.", s.Name, typeName), Locations: loc})
+				continue
+			}
+			for name := range s.Arguments {
+				if _, ok := definition.Arguments[name]; !ok {
+					errs = append(errs, &gqlError{Message: fmt.Sprintf("Unknown argument %q on field %q of type %q.", name, s.Name, typeName), Locations: loc})
+				}
+			}
+			fieldType := namedType(definition.Type)
+			if gqlScalars[fieldType] {
+				if len(s.Selections) > 0 {
+					errs = append(errs, &gqlError{Message: fmt.Sprintf("Field %q must not have a selection since type %q has no subfields.", s.Name, definition.Type), Locations: loc})
+				}
+				continue
+			}
+			if len(s.Selections) == 0 {
+				errs = append(errs, &gqlError{Message: fmt.Sprintf("Field %q of type %q must have a selection of subfields.", s.Name, definition.Type), Locations: loc})
+				continue
+			}
+			fieldDepth, fieldCost, fieldErrs := e.validate(fieldType, s.Selections, depth+1)
+			errs = append(errs, fieldErrs...)
+			if fieldDepth > deepest {
+				deepest = fieldDepth
+			}
+			addCost(e.listSize(definition, s) * (1 + fieldCost))
+		case *gqlFragmentSpread:
+			fragment, ok := e.document.Fragments[s.Name]
+			if !ok {
+				errs = append(errs, &gqlError{Message: fmt.Sprintf("Unknown fragment %q.", s.Name), Locations: []gqlLocation{s.Loc}})
+				continue
+			}
+			if e.fragmentStates[s.Name] == gqlFragmentVisiting {
+				errs = append(errs, &gqlError{Message: fmt.Sprintf("Cannot spread fragment %q within itself.", s.Name), Locations: []gqlLocation{s.Loc}})
+				continue
+			}
+			if _, ok := e.schema[fragment.TypeCondition]; ok && fragment.TypeCondition != typeName {
+				errs = append(errs, &gqlError{Message: fmt.Sprintf("Fragment %q cannot be spread here as objects of type %q can never be of type %q.", s.Name, typeName, fragment.TypeCondition), Locations: []gqlLocation{s.Loc}})
+				continue
+			}
+			// errors of the fragment are only returned by its first spread
+			fragmentDepth, fragmentCost, fragmentErrs := e.validateFragment(s.Name)
+			errs = append(errs, fragmentErrs...)
+			addCost(fragmentCost)
+			// fragment selections are at the level of the spread
+			if spreadDepth := depth + fragmentDepth - 1; spreadDepth > deepest {
+				deepest = spreadDepth
+				if deepest > gqlMaxDepth {
+					return deepest, cost, append(errs, &gqlError{Message: fmt.Sprintf("Query is nested deeper than %d levels.", gqlMaxDepth)})
+				}
+			}
+		case *gqlInlineFragment:
+			if s.TypeCondition != "" && s.TypeCondition != typeName {
+				errs = append(errs, &gqlError{Message: fmt.Sprintf("Inline fragment on %q cannot be spread within type %q.", s.TypeCondition, typeName)})
+				continue
+			}
+			fragmentDepth, fragmentCost, fragmentErrs := e.validate(typeName, s.Selections, depth)
+			errs = append(errs, fragmentErrs...)
+			if fragmentDepth > deepest {
+				deepest = fragmentDepth
+			}
+			addCost(fragmentCost)
+		}
+	}
+	return deepest, cost, errs
+}
+
+// executeSelections execute a selection set for every parent at once and
+// return one object per parent, nil parent give nil object
+func (e *gqlExecution) executeSelections(typeName string, parents []interface{}, selections []gqlSelection, path []interface{}) []interface{} {
+	results := make([]interface{}, len(parents))
+	var objects []*gqlObject
+	var present []interface{}
+	for i, parent := range parents {
+		if parent == nil && typeName != "Query" {
+			continue
+		}
+		object := newGqlObject()
+		results[i] = object
+		objects = append(objects, object)
+		present = append(present, parent)
+	}
+	if len(present) == 0 {
+		return results
+	}
+
+	// conflicts are reported by validateMerge
+	fields, _ := e.collectFields(typeName, selections, nil, map[string]bool{})
+	for _, field := range fields {
+		key := field.responseKey()
+		fieldPath := append(append([]interface{}{}, path...), key)
+		if field.Name == "__typename" {
+			for _, object := range objects {
+				object.set(key, typeName)
+			}
+			continue
+		}
+
+		definition := e.schema[typeName][field.Name]
+		values, err := e.resolve(definition, present, field)
+		if err != nil {
+			e.errors = append(e.errors, &gqlError{Message: err.Error(), Locations: []gqlLocation{field.Loc}, Path: fieldPath})
+			values = make([]interface{}, len(present))
+		}
+
+		fieldType := namedType(definition.Type)
+		if !gqlScalars[fieldType] {
+			values = e.executeChildren(fieldType, isListType(definition.Type), values, field.Selections, fieldPath)
+		}
+		for i, object := range objects {
+			object.set(key, values[i])
+		}
+	}
+	return results
+}
+
+// resolve call field resolver and check it return one value per parent
+func (e *gqlExecution) resolve(definition *gqlFieldDefinition, parents []interface{}, field *gqlField) ([]interface{}, error) {
+	args, err := e.argumentValues(definition.Arguments, field.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	values, err := definition.Resolve(parents, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(parents) {
+		return nil, fmt.Errorf("resolver of %q returned %d values for %d parents", field.Name, len(values), len(parents))
+	}
+	return values, nil
+}
+
+// executeChildren execute sub-selections of object fields. For list fields
+// every item of every parent is executed in a single batch
+func (e *gqlExecution) executeChildren(typeName string, list bool, values []interface{}, selections []gqlSelection, path []interface{}) []interface{} {
+	if !list {
+		return e.executeSelections(typeName, values, selections, path)
+	}
+
+	var flat []interface{}
+	for _, value := range values {
+		if items, ok := value.([]interface{}); ok {
+			flat = append(flat, items...)
+		}
+	}
+	executed := e.executeSelections(typeName, flat, selections, path)
+
+	results := make([]interface{}, len(values))
+	offset := 0
+	for i, value := range values {
+		items, ok := value.([]interface{})
+		if !ok {
+			continue
+		}
+		results[i] = executed[offset : offset+len(items)]
+		offset += len(items)
+	}
+	return results
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// maximum size of GraphQL request body
+const gqlMaxBodySize = 1 << 20
+
+// graphqlSDL describe the schema served by /graphql
+const graphqlSDL = `type Query {
+  random(tag: String, author: String): Quote
+  quote(id: Int!): Quote
+  quotes(limit: Int, offset: Int): [Quote!]!
+  search(query: String!, limit: Int, offset: Int): [Quote!]!
+  author(id: Int, twitterUsername: String): Author
+  authors(limit: Int, offset: Int): [Author!]!
+  tag(id: Int, label: String): Tag
+  tags(limit: Int, offset: Int): [Tag!]!
+}
+
+type Quote {
+  id: Int!
+  postId: String!
+  content: String!
+  permalink: String!
+  pictureUrl: String!
+  author: Author!
+  tags: [Tag!]!
+}
+
+type Author {
+  id: Int!
+  name: String!
+  company: String!
+  avatarUrl: String!
+  twitterUsername: String!
+  quotes(limit: Int, offset: Int, random: Boolean, exclude: [Int!]): [Quote!]!
+  tags: [Tag!]!
+}
+
+type Tag {
+  id: Int!
+  label: String!
+  quotes(limit: Int, offset: Int, random: Boolean, exclude: [Int!]): [Quote!]!
+}
+`
+
+// graphqlRequest define structure of GraphQL request body
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// limitOffset read `limit` and `offset` arguments
+func limitOffset(args map[string]interface{}) (int, int, error) {
+	limit, offset := defaultPerPage, 0
+	if value, ok := args["limit"].(int); ok {
+		limit = value
+	}
+	if value, ok := args["offset"].(int); ok {
+		offset = value
+	}
+	if limit < 0 || limit > maxPerPage {
+		return 0, 0, errors.New("limit must be between 0 and 100")
+	}
+	if offset < 0 {
+		return 0, 0, errors.New("offset must not be negative")
+	}
+	return limit, offset, nil
+}
+
+// quotesPage read exclude, random, offset and limit arguments of relations
+func quotesPage(args map[string]interface{}) (QuotesPage, error) {
+	limit, offset, err := limitOffset(args)
+	if err != nil {
+		return QuotesPage{}, err
+	}
+	page := QuotesPage{Limit: limit, Offset: offset}
+	page.Random, _ = args["random"].(bool)
+	if ids, ok := args["exclude"].([]interface{}); ok {
+		for _, id := range ids {
+			if id, ok := id.(int); ok {
+				page.Exclude = append(page.Exclude, id)
+			}
+		}
+	}
+	return page, nil
+}
+
+// quotesToList convert quotes to GraphQL list
+func quotesToList(quotes []*Quote) []interface{} {
+	list := make([]interface{}, len(quotes))
+	for i, quote := range quotes {
+		list[i] = quote
+	}
+	return list
+}
+
+// rootField resolve a Query field, Query has a single nil parent
+func rootField(resolve func(args map[string]interface{}) (interface{}, error)) gqlResolver {
+	return func(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		value, err := resolve(args)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{value}, nil
+	}
+}
+
+// nullIfNotFound return nil value instead of not found errors, GraphQL
+// lookups return null for missing objects
+func nullIfNotFound(value interface{}, err error) (interface{}, error) {
+	if err == errQuoteNotFound || err == errAuthorNotFound || err == errTagNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+	return value, nil
+}
+
+// quoteField resolve a field of Quote without database access
+func quoteField(get func(quote *Quote) interface{}) gqlResolver {
+	return func(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		values := make([]interface{}, len(parents))
+		for i, parent := range parents {
+			values[i] = get(parent.(*Quote))
+		}
+		return values, nil
+	}
+}
+
+// authorField resolve a field of Author without database access
+func authorField(get func(author *Author) interface{}) gqlResolver {
+	return func(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		values := make([]interface{}, len(parents))
+		for i, parent := range parents {
+			values[i] = get(parent.(*Author))
+		}
+		return values, nil
+	}
+}
+
+// tagField resolve a field of Tag without database access
+func tagField(get func(tag *Tag) interface{}) gqlResolver {
+	return func(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		values := make([]interface{}, len(parents))
+		for i, parent := range parents {
+			values[i] = get(parent.(*Tag))
+		}
+		return values, nil
+	}
+}
+
+// newGraphQLSchema create the schema backed by DatabaseUtils
+func newGraphQLSchema(dbUtils *DatabaseUtils) gqlSchema {
+	pageArgs := map[string]string{"limit": "Int", "offset": "Int"}
+	relationArgs := map[string]string{"limit": "Int", "offset": "Int", "random": "Boolean", "exclude": "[Int!]"}
+
+	return gqlSchema{
+		"Query": {
+			"random": {Type: "Quote", Arguments: map[string]string{"tag": "String", "author": "String"},
+				Resolve: rootField(func(args map[string]interface{}) (interface{}, error) {
+					tag, _ := args["tag"].(string)
+					author, _ := args["author"].(string)
+					return nullIfNotFound(dbUtils.RandomQuoteFiltered(tag, author))
+				})},
+			"quote": {Type: "Quote", Arguments: map[string]string{"id": "Int!"},
+				Resolve: rootField(func(args map[string]interface{}) (interface{}, error) {
+					return nullIfNotFound(dbUtils.QuoteById(args["id"].(int)))
+				})},
+			"quotes": {Type: "[Quote!]!", Arguments: pageArgs,
+				Resolve: rootField(func(args map[string]interface{}) (interface{}, error) {
+					limit, offset, err := limitOffset(args)
+					if err != nil {
+						return nil, err
+					}
+					quotes, _, err := dbUtils.Quotes(limit, offset)
+					if err != nil {
+						return nil, errors.New("database error")
+					}
+					return quotesToList(quotes), nil
+				})},
+			"search": {Type: "[Quote!]!", Arguments: map[string]string{"query": "String!", "limit": "Int", "offset": "Int"},
+				Resolve: rootField(func(args map[string]interface{}) (interface{}, error) {
+					limit, offset, err := limitOffset(args)
+					if err != nil {
+						return nil, err
+					}
+					quotes, err := dbUtils.SearchQuotes(args["query"].(string), limit, offset)
+					if err != nil {
+						return nil, errors.New("database error")
+					}
+					return quotesToList(quotes), nil
+				})},
+			"author": {Type: "Author", Arguments: map[string]string{"id": "Int", "twitterUsername": "String"},
+				Resolve: rootField(func(args map[string]interface{}) (interface{}, error) {
+					var author Author
+					var err error
+					if id, ok := args["id"].(int); ok {
+						author, err = dbUtils.AuthorById(id)
+					} else if username, ok := args["twitterUsername"].(string); ok {
+						author, err = dbUtils.AuthorByTwitterUsername(username)
+					} else {
+						return nil, errors.New("id or twitterUsername argument is required")
+					}
+					return nullIfNotFound(&author, err)
+				})},
+			"authors": {Type: "[Author!]!", Arguments: pageArgs,
+				Resolve: rootField(func(args map[string]interface{}) (interface{}, error) {
+					limit, offset, err := limitOffset(args)
+					if err != nil {
+						return nil, err
+					}
+					authors, _, err := dbUtils.AuthorsPage(limit, offset)
+					if err != nil {
+						return nil, errors.New("database error")
+					}
+					list := make([]interface{}, len(authors))
+					for i := range authors {
+						list[i] = &authors[i]
+					}
+					return list, nil
+				})},
+			"tag": {Type: "Tag", Arguments: map[string]string{"id": "Int", "label": "String"},
+				Resolve: rootField(func(args map[string]interface{}) (interface{}, error) {
+					var tag Tag
+					var err error
+					if id, ok := args["id"].(int); ok {
+						tag, err = dbUtils.TagById(id)
+					} else if label, ok := args["label"].(string); ok {
+						tag, err = dbUtils.TagByLabel(label)
+					} else {
+						return nil, errors.New("id or label argument is required")
+					}
+					return nullIfNotFound(&tag, err)
+				})},
+			"tags": {Type: "[Tag!]!", Arguments: pageArgs,
+				Resolve: rootField(func(args map[string]interface{}) (interface{}, error) {
+					limit, offset, err := limitOffset(args)
+					if err != nil {
+						return nil, err
+					}
+					tags, _, err := dbUtils.TagsPage(limit, offset)
+					if err != nil {
+						return nil, errors.New("database error")
+					}
+					list := make([]interface{}, len(tags))
+					for i := range tags {
+						list[i] = &tags[i]
+					}
+					return list, nil
+				})},
+		},
+		"Quote": {
+			"id":         {Type: "Int!", Resolve: quoteField(func(q *Quote) interface{} { return q.Id })},
+			"postId":     {Type: "String!", Resolve: quoteField(func(q *Quote) interface{} { return q.PostId })},
+			"content":    {Type: "String!", Resolve: quoteField(func(q *Quote) interface{} { return q.Content })},
+			"permalink":  {Type: "String!", Resolve: quoteField(func(q *Quote) interface{} { return q.Permalink })},
+			"pictureUrl": {Type: "String!", Resolve: quoteField(func(q *Quote) interface{} { return q.PictureUrl })},
+			// author and tags are loaded in batch with the quotes
+			"author": {Type: "Author!", Resolve: quoteField(func(q *Quote) interface{} { return &q.Author })},
+			"tags": {Type: "[Tag!]!", Resolve: quoteField(func(q *Quote) interface{} {
+				list := make([]interface{}, len(q.Tags))
+				for i := range q.Tags {
+					list[i] = &q.Tags[i]
+				}
+				return list
+			})},
+		},
+		"Author": {
+			"id":              {Type: "Int!", Resolve: authorField(func(a *Author) interface{} { return a.Id })},
+			"name":            {Type: "String!", Resolve: authorField(func(a *Author) interface{} { return a.Name })},
+			"company":         {Type: "String!", Resolve: authorField(func(a *Author) interface{} { return a.Company })},
+			"avatarUrl":       {Type: "String!", Resolve: authorField(func(a *Author) interface{} { return a.AvatarUrl })},
+			"twitterUsername": {Type: "String!", Resolve: authorField(func(a *Author) interface{} { return a.Twitter })},
+			"quotes": {Type: "[Quote!]!", Arguments: relationArgs,
+				Resolve: func(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+					ids := make([]int, len(parents))
+					for i, parent := range parents {
+						ids[i] = parent.(*Author).Id
+					}
+					page, err := quotesPage(args)
+					if err != nil {
+						return nil, err
+					}
+					quotes, err := dbUtils.QuotesByAuthorIdsPage(ids, page)
+					if err != nil {
+						return nil, errors.New("database error")
+					}
+					values := make([]interface{}, len(parents))
+					for i, id := range ids {
+						values[i] = quotesToList(quotes[id])
+					}
+					return values, nil
+				}},
+			"tags": {Type: "[Tag!]!",
+				Resolve: func(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+					ids := make([]int, len(parents))
+					for i, parent := range parents {
+						ids[i] = parent.(*Author).Id
+					}
+					tags, err := dbUtils.TagsByAuthorIds(ids)
+					if err != nil {
+						return nil, errors.New("database error")
+					}
+					values := make([]interface{}, len(parents))
+					for i, id := range ids {
+						list := make([]interface{}, len(tags[id]))
+						for j := range tags[id] {
+							list[j] = &tags[id][j]
+						}
+						values[i] = list
+					}
+					return values, nil
+				}},
+		},
+		"Tag": {
+			"id":    {Type: "Int!", Resolve: tagField(func(t *Tag) interface{} { return t.Id })},
+			"label": {Type: "String!", Resolve: tagField(func(t *Tag) interface{} { return t.Label })},
+			"quotes": {Type: "[Quote!]!", Arguments: relationArgs,
+				Resolve: func(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+					ids := make([]int, len(parents))
+					for i, parent := range parents {
+						ids[i] = parent.(*Tag).Id
+					}
+					page, err := quotesPage(args)
+					if err != nil {
+						return nil, err
+					}
+					quotes, err := dbUtils.QuotesByTagIdsPage(ids, page)
+					if err != nil {
+						return nil, errors.New("database error")
+					}
+					values := make([]interface{}, len(parents))
+					for i, id := range ids {
+						values[i] = quotesToList(quotes[id])
+					}
+					return values, nil
+				}},
+		},
+	}
+}
+
+// GraphQLHandler serve POST /graphql
+type GraphQLHandler struct {
+	Schema gqlSchema
+}
+
+// /graphql endpoint. execute a GraphQL query
+func (g GraphQLHandler) graphqlHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	var req graphqlRequest
+	body := io.LimitReader(r.Body, gqlMaxBodySize)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		return invalidParameter("graphqlHandler.Decode", errors.New("request body must be a JSON object with a query"))
+	}
+	if req.Query == "" {
+		return invalidParameter("graphqlHandler.Query", errors.New("query must not be empty"))
+	}
+	resp := executeGraphQL(g.Schema, req.Query, req.OperationName, req.Variables)
+	// queries that are invalid or too expensive are not executed
+	if resp.Data == nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	return writeJSON(w, r, resp, "graphqlHandler.resp")
+}
+
+// /graphql/schema endpoint. return the schema in SDL
+func graphqlSchemaHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err := io.WriteString(w, graphqlSDL)
+	if err != nil {
+		return &apiError{
+			"graphqlSchemaHandler.WriteString",
+			err,
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+	return nil
+}
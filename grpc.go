@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// port of gRPC server, gRPC disabled if empty
+	GRPC_PORT = os.Getenv("GRPC_PORT")
+)
+
+// This file implement the gRPC protocol (HTTP/2 without TLS, identity
+// encoding) for wisdom.v1.WisdomService defined in
+// proto/wisdom/v1/wisdom.proto.
+
+// maximum size of a gRPC request message
+const grpcMaxMessageSize = 4 << 20
+
+// gRPC status codes
+const (
+	grpcOK              = 0
+	grpcCanceled        = 1
+	grpcInvalidArgument = 3
+	grpcNotFound        = 5
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+	grpcUnavailable     = 14
+)
+
+const grpcServiceName = "wisdom.v1.WisdomService"
+
+// grpcStatus is a non-OK gRPC status
+type grpcStatus struct {
+	Code    int
+	Message string
+}
+
+func (s *grpcStatus) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", s.Code, s.Message)
+}
+
+// grpcDatabaseErr convert error from DatabaseUtils to grpcStatus
+func grpcDatabaseErr(err error) *grpcStatus {
+	switch err {
+	case errQuoteNotFound:
+		return &grpcStatus{grpcNotFound, "Quote not found"}
+	case errAuthorNotFound:
+		return &grpcStatus{grpcNotFound, "Author not found"}
+	case errTagNotFound:
+		return &grpcStatus{grpcNotFound, "Tag not found"}
+	}
+	return &grpcStatus{grpcUnavailable, "Database error"}
+}
+
+// grpcUnary is an unary method
+type grpcUnary func(dbUtils *DatabaseUtils, req *protoRequest) ([]byte, *grpcStatus)
+
+// grpcStreaming is a server streaming method, send write a response message
+type grpcStreaming func(dbUtils *DatabaseUtils, req *protoRequest, send func([]byte) error, done <-chan struct{}) *grpcStatus
+
+// GRPCServer serve wisdom.v1.WisdomService
+type GRPCServer struct {
+	DBUtils   *DatabaseUtils
+	Unary     map[string]grpcUnary
+	Streaming map[string]grpcStreaming
+}
+
+// NewGRPCServer create WisdomService server
+func NewGRPCServer(dbUtils *DatabaseUtils) *GRPCServer {
+	return &GRPCServer{
+		DBUtils: dbUtils,
+		Unary: map[string]grpcUnary{
+			"GetRandomQuote":     grpcGetRandomQuote,
+			"GetQuote":           grpcGetQuote,
+			"ListAuthors":        grpcListAuthors,
+			"ListQuotesByAuthor": grpcListQuotesByAuthor,
+			"ListTags":           grpcListTags,
+		},
+		Streaming: map[string]grpcStreaming{
+			"StreamRandomQuotes": grpcStreamRandomQuotes,
+		},
+	}
+}
+
+// newGRPCHTTPServer create the HTTP/2 without TLS (h2c) server of addr
+func newGRPCHTTPServer(addr string, server *GRPCServer) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{
+		Addr:      addr,
+		Handler:   server,
+		Protocols: protocols,
+	}
+}
+
+func (g *GRPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+
+	status := g.call(w, r)
+	if status == nil {
+		status = &grpcStatus{grpcOK, ""}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(status.Code))
+	if status.Message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEncodeMessage(status.Message))
+	}
+
+	log.Printf("%s gRPC %s %d", r.RemoteAddr, r.URL.Path, status.Code)
+}
+
+// call decode the request message and dispatch to the method
+func (g *GRPCServer) call(w http.ResponseWriter, r *http.Request) *grpcStatus {
+	prefix := "/" + grpcServiceName + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		return &grpcStatus{grpcUnimplemented, "unknown service " + strings.Trim(r.URL.Path, "/")}
+	}
+	name := strings.TrimPrefix(r.URL.Path, prefix)
+	unary, isUnary := g.Unary[name]
+	streaming, isStreaming := g.Streaming[name]
+	if !isUnary && !isStreaming {
+		return &grpcStatus{grpcUnimplemented, "unknown method " + name + " for service " + grpcServiceName}
+	}
+
+	message, status := readGRPCMessage(r.Body)
+	if status != nil {
+		return status
+	}
+	req, err := decodeRequest(message)
+	if err != nil {
+		return &grpcStatus{grpcInvalidArgument, err.Error()}
+	}
+
+	send := func(resp []byte) error {
+		if err := writeGRPCMessage(w, resp); err != nil {
+			return err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	if isUnary {
+		resp, status := unary(g.DBUtils, req)
+		if status != nil {
+			return status
+		}
+		if err := send(resp); err != nil {
+			return &grpcStatus{grpcInternal, err.Error()}
+		}
+		return nil
+	}
+	return streaming(g.DBUtils, req, send, r.Context().Done())
+}
+
+// readGRPCMessage read a length-prefixed message
+func readGRPCMessage(body io.Reader) ([]byte, *grpcStatus) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(body, header); err != nil {
+		return nil, &grpcStatus{grpcInvalidArgument, "missing request message"}
+	}
+	if header[0] != 0 {
+		return nil, &grpcStatus{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > grpcMaxMessageSize {
+		return nil, &grpcStatus{grpcInvalidArgument, "request message is too large"}
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, &grpcStatus{grpcInvalidArgument, "truncated request message"}
+	}
+	return message, nil
+}
+
+// writeGRPCMessage write a length-prefixed message
+func writeGRPCMessage(w io.Writer, message []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(message)
+	return err
+}
+
+// grpcEncodeMessage percent-encode grpc-message trailer
+func grpcEncodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcPageParams read page size and page token, page token is the offset
+// of the next page
+func grpcPageParams(pageSize int, pageToken string) (int, int, *grpcStatus) {
+	if pageSize == 0 {
+		pageSize = defaultPerPage
+	}
+	if pageSize < 0 || pageSize > maxPerPage {
+		return 0, 0, &grpcStatus{grpcInvalidArgument, "page_size must be between 1 and 100"}
+	}
+	offset := 0
+	if pageToken != "" {
+		var err error
+		offset, err = strconv.Atoi(pageToken)
+		if err != nil || offset < 0 {
+			return 0, 0, &grpcStatus{grpcInvalidArgument, "invalid page_token"}
+		}
+	}
+	return pageSize, offset, nil
+}
+
+// nextPage create page info of list responses
+func nextPage(limit, offset, total int) grpcPage {
+	page := grpcPage{TotalSize: total}
+	if offset+limit < total {
+		page.NextPageToken = strconv.Itoa(offset + limit)
+	}
+	return page
+}
+
+// GetRandomQuote(GetRandomQuoteRequest) returns (Quote)
+func grpcGetRandomQuote(dbUtils *DatabaseUtils, req *protoRequest) ([]byte, *grpcStatus) {
+	quote, err := dbUtils.RandomQuoteFiltered(req.strings[1], req.strings[2])
+	if err != nil {
+		return nil, grpcDatabaseErr(err)
+	}
+	return encodeQuote(quote), nil
+}
+
+// GetQuote(GetQuoteRequest) returns (Quote)
+func grpcGetQuote(dbUtils *DatabaseUtils, req *protoRequest) ([]byte, *grpcStatus) {
+	if req.ints[1] <= 0 {
+		return nil, &grpcStatus{grpcInvalidArgument, "id is required"}
+	}
+	quote, err := dbUtils.QuoteById(req.ints[1])
+	if err != nil {
+		return nil, grpcDatabaseErr(err)
+	}
+	return encodeQuote(quote), nil
+}
+
+// ListAuthors(ListAuthorsRequest) returns (ListAuthorsResponse)
+func grpcListAuthors(dbUtils *DatabaseUtils, req *protoRequest) ([]byte, *grpcStatus) {
+	limit, offset, status := grpcPageParams(req.ints[1], req.strings[2])
+	if status != nil {
+		return nil, status
+	}
+	authors, total, err := dbUtils.AuthorsPage(limit, offset)
+	if err != nil {
+		return nil, grpcDatabaseErr(err)
+	}
+	return encodeListAuthorsResponse(authors, nextPage(limit, offset, total)), nil
+}
+
+// ListQuotesByAuthor(ListQuotesByAuthorRequest) returns (ListQuotesByAuthorResponse)
+func grpcListQuotesByAuthor(dbUtils *DatabaseUtils, req *protoRequest) ([]byte, *grpcStatus) {
+	if req.strings[1] == "" {
+		return nil, &grpcStatus{grpcInvalidArgument, "twitter_username is required"}
+	}
+	limit, offset, status := grpcPageParams(req.ints[2], req.strings[3])
+	if status != nil {
+		return nil, status
+	}
+	author, err := dbUtils.AuthorByTwitterUsername(req.strings[1])
+	if err != nil {
+		return nil, grpcDatabaseErr(err)
+	}
+	quotes, total, err := dbUtils.QuotesByAuthorIdPage(author.Id, limit, offset)
+	if err != nil {
+		return nil, grpcDatabaseErr(err)
+	}
+	return encodeListQuotesByAuthorResponse(quotes, nextPage(limit, offset, total)), nil
+}
+
+// ListTags(ListTagsRequest) returns (ListTagsResponse)
+func grpcListTags(dbUtils *DatabaseUtils, req *protoRequest) ([]byte, *grpcStatus) {
+	limit, offset, status := grpcPageParams(req.ints[1], req.strings[2])
+	if status != nil {
+		return nil, status
+	}
+	tags, total, err := dbUtils.TagsPage(limit, offset)
+	if err != nil {
+		return nil, grpcDatabaseErr(err)
+	}
+	return encodeListTagsResponse(tags, nextPage(limit, offset, total)), nil
+}
+
+// StreamRandomQuotes(StreamRandomQuotesRequest) returns (stream Quote)
+func grpcStreamRandomQuotes(dbUtils *DatabaseUtils, req *protoRequest, send func([]byte) error, done <-chan struct{}) *grpcStatus {
+	interval := req.ints[3]
+	if interval == 0 {
+		interval = 10
+	}
+	if interval < 1 {
+		return &grpcStatus{grpcInvalidArgument, "interval_seconds must be positive"}
+	}
+	count := req.ints[4]
+	if count < 0 {
+		return &grpcStatus{grpcInvalidArgument, "count must not be negative"}
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for sent := 0; count == 0 || sent < count; sent++ {
+		if sent > 0 {
+			select {
+			case <-done:
+				return &grpcStatus{grpcCanceled, "stream canceled"}
+			case <-shuttingDown:
+				return &grpcStatus{grpcUnavailable, "server shutting down"}
+			case <-ticker.C:
+			}
+		}
+		quote, err := dbUtils.RandomQuoteFiltered(req.strings[1], req.strings[2])
+		if err != nil {
+			return grpcDatabaseErr(err)
+		}
+		if err := send(encodeQuote(quote)); err != nil {
+			return &grpcStatus{grpcCanceled, "send failed: " + err.Error()}
+		}
+	}
+	return nil
+}
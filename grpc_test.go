@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestGRPCMessage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeGRPCMessage(&buf, []byte{0x08, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0, 0, 0, 2, 0x08, 0x01}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("writeGRPCMessage() wrote %x, want %x", buf.Bytes(), want)
+	}
+	message, status := readGRPCMessage(&buf)
+	if status != nil || !bytes.Equal(message, []byte{0x08, 0x01}) {
+		t.Errorf("readGRPCMessage() = %x, %v", message, status)
+	}
+
+	large := make([]byte, 5)
+	binary.BigEndian.PutUint32(large[1:], grpcMaxMessageSize+1)
+	tests := []struct {
+		desc string
+		body []byte
+		code int
+	}{
+		{"empty body", nil, grpcInvalidArgument},
+		{"short header", []byte{0, 0, 0}, grpcInvalidArgument},
+		{"compressed", []byte{1, 0, 0, 0, 0}, grpcUnimplemented},
+		{"too large", large, grpcInvalidArgument},
+		{"truncated", []byte{0, 0, 0, 0, 3, 0x08}, grpcInvalidArgument},
+	}
+	for _, test := range tests {
+		_, status := readGRPCMessage(bytes.NewReader(test.body))
+		if status == nil || status.Code != test.code {
+			t.Errorf("%s: readGRPCMessage() = %v, want code %d", test.desc, status, test.code)
+		}
+	}
+}
+
+func TestGRPCEncodeMessage(t *testing.T) {
+	tests := map[string]string{
+		"quote not found": "quote not found",
+		"100%":            "100%25",
+		"line\nbreak":     "line%0Abreak",
+		"café":            "caf%C3%A9",
+	}
+	for message, want := range tests {
+		if got := grpcEncodeMessage(message); got != want {
+			t.Errorf("grpcEncodeMessage(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	icalDefaultDays = 30
+	icalMaxDays     = 366
+	// content lines are folded at 75 octets, see RFC 5545 section 3.1
+	icalLineLength = 75
+)
+
+// icalEscaper escape TEXT values, see RFC 5545 section 3.3.11
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// icalWriter write a calendar, content lines end with CRLF and are folded
+type icalWriter struct {
+	buf bytes.Buffer
+}
+
+// line write a content line, long lines are folded without splitting
+// UTF-8 sequences
+func (c *icalWriter) line(name, value string) {
+	line := name + ":" + value
+	limit := icalLineLength
+	for len(line) > limit {
+		cut := limit
+		for !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		c.buf.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// continuation lines start with a space
+		limit = icalLineLength - 1
+	}
+	c.buf.WriteString(line + "\r\n")
+}
+
+// text write a TEXT property
+func (c *icalWriter) text(name, value string) {
+	c.line(name, icalEscaper.Replace(value))
+}
+
+// /v1/qotd.ics endpoint. one all-day event per day with the quote of the
+// day, for the next `days` days, optionally with `tag`
+func qotdCalendarHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	query := r.URL.Query()
+	days := icalDefaultDays
+	if value := query.Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > icalMaxDays {
+			return invalidParameter("qotdCalendarHandler.days", fmt.Errorf("days must be an integer between 1 and %d", icalMaxDays))
+		}
+	}
+	tag_label := query.Get("tag")
+	if tag_label != "" {
+		if _, err := dbUtils.TagByLabel(tag_label); err != nil {
+			return databaseError("qotdCalendarHandler.TagByLabel", err)
+		}
+	}
+
+	host := "wisdom"
+	if u, err := url.Parse(baseURL(r)); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	name := "Wisdom: quote of the day"
+	if tag_label != "" {
+		name += " (" + tag_label + ")"
+	}
+
+	c := &icalWriter{}
+	c.line("BEGIN", "VCALENDAR")
+	c.line("VERSION", "2.0")
+	c.line("PRODID", "-//Wisdom//Quote of the day//EN")
+	c.line("CALSCALE", "GREGORIAN")
+	c.line("METHOD", "PUBLISH")
+	c.text("X-WR-CALNAME", name)
+	c.line("REFRESH-INTERVAL;VALUE=DURATION", "P1D")
+	c.line("X-PUBLISHED-TTL", "P1D")
+
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	dates := make([]time.Time, days)
+	for i := range dates {
+		dates[i] = today.AddDate(0, 0, i)
+	}
+	quotes, err := dbUtils.QuotesOfTheDays(dates, tag_label)
+	if err != nil {
+		return databaseError("qotdCalendarHandler.QuotesOfTheDays", err)
+	}
+	for i, day := range dates {
+		quote := quotes[i]
+		// UID only depend on the day and tag so clients update events in
+		// place when the quote change
+		uid := "qotd-" + day.Format("20060102")
+		if tag_label != "" {
+			uid += "-" + url.PathEscape(tag_label)
+		}
+		c.line("BEGIN", "VEVENT")
+		c.line("UID", uid+"@"+host)
+		c.line("DTSTAMP", now.Format("20060102T150405Z"))
+		c.line("DTSTART;VALUE=DATE", day.Format("20060102"))
+		c.line("DTEND;VALUE=DATE", day.AddDate(0, 0, 1).Format("20060102"))
+		c.text("SUMMARY", "“"+quote.Content+"” — "+quote.Author.Name)
+		c.text("DESCRIPTION", quote.Content+"\n\n— "+quote.Author.Name+"\n"+quote.Permalink)
+		if quote.Permalink != "" {
+			c.line("URL", quote.Permalink)
+		}
+		var categories []string
+		for _, tag := range quote.Tags {
+			categories = append(categories, icalEscaper.Replace(tag.Label))
+		}
+		if len(categories) > 0 {
+			c.line("CATEGORIES", strings.Join(categories, ","))
+		}
+		c.line("TRANSP", "TRANSPARENT")
+		c.line("END", "VEVENT")
+	}
+	c.line("END", "VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="qotd.ics"`)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(c.buf.Bytes())
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// set JSONP_DISABLED=true to ignore callback & jsonp query parameters
+	JSONP_DISABLED = os.Getenv("JSONP_DISABLED")
+)
+
+// maximum length of JSONP callback name
+const jsonpCallbackMaxLength = 128
+
+var errInvalidJSONPCallback = errors.New("invalid JSONP callback")
+
+// valid JSONP callback is a javascript identifier or a dotted path of
+// javascript identifiers, e.g. `callback`, `$.wisdom._cb1`
+var jsonpCallbackRegexp = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$]*(\.[a-zA-Z_$][a-zA-Z0-9_$]*)*$`)
+
+// javascript reserved words can't be used as a callback name
+var jsonpReservedWords = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true, "const": true,
+	"continue": true, "debugger": true, "default": true, "delete": true,
+	"do": true, "else": true, "enum": true, "export": true, "extends": true,
+	"false": true, "finally": true, "for": true, "function": true, "if": true,
+	"implements": true, "import": true, "in": true, "instanceof": true,
+	"interface": true, "let": true, "new": true, "null": true, "package": true,
+	"private": true, "protected": true, "public": true, "return": true,
+	"static": true, "super": true, "switch": true, "this": true, "throw": true,
+	"true": true, "try": true, "typeof": true, "var": true, "void": true,
+	"while": true, "with": true, "yield": true,
+}
+
+// jsonpEnabled return false when JSONP disabled by config
+func jsonpEnabled() bool {
+	disabled, err := strconv.ParseBool(JSONP_DISABLED)
+	if err != nil {
+		return true
+	}
+	return !disabled
+}
+
+// validJSONPCallback check the callback name is safe to reflect in response
+func validJSONPCallback(callback string) bool {
+	if len(callback) > jsonpCallbackMaxLength {
+		return false
+	}
+	if !jsonpCallbackRegexp.MatchString(callback) {
+		return false
+	}
+	for _, part := range strings.Split(callback, ".") {
+		if jsonpReservedWords[part] {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonpCallback return callback name from `callback` or `jsonp` query
+// parameter. empty string returned if request is not a JSONP request
+func jsonpCallback(r *http.Request) string {
+	if !jsonpEnabled() {
+		return ""
+	}
+	query := r.URL.Query()
+	if callback := query.Get("callback"); callback != "" {
+		return callback
+	}
+	return query.Get("jsonp")
+}
+
+// writeJSON write v as JSON response. If request have a callback or jsonp
+// query parameter, the response is wrapped as JSONP
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}, tag string) *apiError {
+	callback := jsonpCallback(r)
+	if callback == "" {
+		err := json.NewEncoder(w).Encode(v)
+		if err != nil {
+			return &apiError{
+				tag + ".Encode.Err",
+				err,
+				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+				http.StatusInternalServerError,
+				errCodeInternal,
+			}
+		}
+		return nil
+	}
+
+	if !validJSONPCallback(callback) {
+		return &apiError{
+			tag + ".validJSONPCallback",
+			errInvalidJSONPCallback,
+			"Invalid JSONP callback",
+			http.StatusBadRequest,
+			errCodeInvalidParameter,
+		}
+	}
+
+	jsonResult, err := json.Marshal(v)
+	if err != nil {
+		return &apiError{
+			tag + ".Marshal.Err",
+			err,
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+
+	// `/**/` prefix protect against Rosetta Flash style attack and nosniff
+	// prevent browser to interpret response as other content type
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, err = w.Write([]byte("/**/" + callback + "(" + string(jsonResult) + ");"))
+	if err != nil {
+		return &apiError{
+			tag + ".Write.Err",
+			err,
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// longest request line of line protocols, e.g. a gopher selector
+	lineMaxLength = 512
+	lineTimeout   = 10 * time.Second
+	// requests per minute per client IP
+	lineRateLimit = 60
+)
+
+// serveLines serve a protocol where the client send one line and the server
+// respond then close the connection, e.g. Gopher and Finger. respond is
+// called with the line without CRLF
+func serveLines(name string, listener net.Listener, respond func(line string) string) error {
+	limiter := newIPLimiter(lineRateLimit, lineRateLimit)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if !limiter.allow(addrIP(conn.RemoteAddr())) {
+				return
+			}
+			conn.SetDeadline(time.Now().Add(lineTimeout))
+			reader := bufio.NewReaderSize(conn, lineMaxLength)
+			line, err := reader.ReadSlice('\n')
+			if err != nil && !(err == bufio.ErrBufferFull || len(line) > 0) {
+				return
+			}
+			request := strings.TrimRight(string(line), "\r\n")
+			log.Printf("%s %s %q", conn.RemoteAddr(), name, request)
+			conn.Write([]byte(respond(request)))
+		}()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// MethodHandler restrict an ApiHandler to the allowed methods. HEAD is
+// allowed when GET is allowed and OPTIONS is always allowed
+type MethodHandler struct {
+	Methods     []string
+	Description string
+	Handler     ApiHandler
+}
+
+// routeDescription define structure of OPTIONS response
+type routeDescription struct {
+	Path        string   `json:"path"`
+	Methods     []string `json:"methods"`
+	Description string   `json:"description"`
+}
+
+// allowed return every allowed methods of the route
+func (m MethodHandler) allowed() []string {
+	var methods []string
+	for _, method := range m.Methods {
+		methods = append(methods, method)
+		if method == "GET" {
+			methods = append(methods, "HEAD")
+		}
+	}
+	return append(methods, "OPTIONS")
+}
+
+// allow check method is allowed on the route
+func (m MethodHandler) allow(method string) bool {
+	for _, allowed := range m.allowed() {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (m MethodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allow := strings.Join(m.allowed(), ", ")
+
+	// CORS preflight is handled by ApiHandler
+	if r.Method == "OPTIONS" && !(isPreflight(r) && cors.enabled()) {
+		w.Header().Set("Allow", allow)
+		ApiHandler{Handler: m.optionsHandler}.ServeHTTP(w, r)
+		return
+	}
+
+	if !m.allow(r.Method) {
+		w.Header().Set("Allow", allow)
+		ApiHandler{Handler: methodNotAllowedHandler}.ServeHTTP(w, r)
+		return
+	}
+
+	// net/http discard the body of HEAD response, so HEAD is served by the
+	// GET handler
+	m.Handler.ServeHTTP(w, r)
+}
+
+// describe the route
+func (m MethodHandler) optionsHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	description := &routeDescription{
+		Path:        r.URL.Path,
+		Methods:     m.allowed(),
+		Description: m.Description,
+	}
+	return writeJSON(w, r, description, "optionsHandler")
+}
+
+// response 405 with Allow header already set by MethodHandler
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	return &apiError{
+		"methodNotAllowedHandler",
+		errors.New("Method Not Allowed"),
+		"Method Not Allowed",
+		http.StatusMethodNotAllowed,
+		errCodeMethodNotAllowed,
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// default width of embedded quotes
+	oembedDefaultWidth = 500
+	oembedMinWidth     = 200
+	// embedded quotes can be cached for a day
+	oembedCacheAge = 86400
+)
+
+// paths of our quote URLs, the quote id is the first submatch
+var oembedPathRegexp = regexp.MustCompile(`^/(?:q|v1/quotes|v2/quotes)/([0-9]+)(?:/card\.(?:png|svg))?/?$`)
+
+// oembed is an oEmbed response, see https://oembed.com section 2.3.4
+type oembed struct {
+	XMLName         xml.Name `json:"-" xml:"oembed"`
+	Type            string   `json:"type" xml:"type"`
+	Version         string   `json:"version" xml:"version"`
+	Title           string   `json:"title" xml:"title"`
+	AuthorName      string   `json:"author_name" xml:"author_name"`
+	AuthorUrl       string   `json:"author_url,omitempty" xml:"author_url,omitempty"`
+	ProviderName    string   `json:"provider_name" xml:"provider_name"`
+	ProviderUrl     string   `json:"provider_url" xml:"provider_url"`
+	CacheAge        int      `json:"cache_age" xml:"cache_age"`
+	ThumbnailUrl    string   `json:"thumbnail_url,omitempty" xml:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int      `json:"thumbnail_width,omitempty" xml:"thumbnail_width,omitempty"`
+	ThumbnailHeight int      `json:"thumbnail_height,omitempty" xml:"thumbnail_height,omitempty"`
+	Html            string   `json:"html" xml:"html"`
+	Width           int      `json:"width" xml:"width"`
+	Height          int      `json:"height" xml:"height"`
+}
+
+// oembedURL return the oEmbed endpoint URL of a page, used by discovery
+// links
+func oembedURL(r *http.Request, page_url, format string) string {
+	return baseURL(r) + "/oembed?url=" + url.QueryEscape(page_url) + "&format=" + format
+}
+
+// oembedLinks return the HTTP Link header value for oEmbed discovery of a
+// page
+func oembedLinks(r *http.Request, page_url string) string {
+	return "<" + oembedURL(r, page_url, "json") + `>; rel="alternate"; type="application/json+oembed", ` +
+		"<" + oembedURL(r, page_url, "xml") + `>; rel="alternate"; type="text/xml+oembed"`
+}
+
+// oembedQuote return the quote of one of our quote URLs, or a quote by its
+// Tumblr permalink
+func oembedQuote(dbUtils *DatabaseUtils, r *http.Request, quote_url string) (*Quote, error) {
+	u, err := url.Parse(quote_url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errQuoteNotFound
+	}
+	host := r.Host
+	if public, err := url.Parse(baseURL(r)); err == nil {
+		host = public.Host
+	}
+	if strings.EqualFold(u.Host, host) {
+		match := oembedPathRegexp.FindStringSubmatch(u.Path)
+		if match == nil {
+			return nil, errQuoteNotFound
+		}
+		id, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, errQuoteNotFound
+		}
+		return dbUtils.QuoteById(id)
+	}
+	return dbUtils.QuoteByPermalink(quote_url)
+}
+
+// oembedSize return maxwidth or maxheight parameter, 0 when missing
+func oembedSize(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 {
+		return 0, errors.New(name + " must be a positive integer")
+	}
+	return size, nil
+}
+
+// oembedHtml return the blockquote of an embedded quote. Every value is
+// escaped, the HTML has no script
+func oembedHtml(quote *Quote, width int) string {
+	var b strings.Builder
+	b.WriteString(`<blockquote class="wisdom-quote" style="box-sizing:border-box;max-width:` + strconv.Itoa(width) + `px;margin:1em 0;padding:1em 1.25em;` +
+		`border-left:4px solid #888;background:#fafaf7;color:#222;font:1.1em/1.5 Georgia,serif">`)
+	b.WriteString(`<p style="margin:0 0 .5em">` + html.EscapeString(quote.Content) + `</p>`)
+	b.WriteString(`<footer style="font-size:.85em;color:#666">— <cite style="font-style:normal">`)
+	if quote.Permalink != "" {
+		b.WriteString(`<a href="` + html.EscapeString(quote.Permalink) + `" style="color:inherit">` + html.EscapeString(quote.Author.Name) + `</a>`)
+	} else {
+		b.WriteString(html.EscapeString(quote.Author.Name))
+	}
+	b.WriteString(`</cite>`)
+	if quote.Author.Company != "" {
+		b.WriteString(", " + html.EscapeString(quote.Author.Company))
+	}
+	b.WriteString(`</footer></blockquote>`)
+	return b.String()
+}
+
+// oembedHeight estimate the height of the embedded quote at given width
+func oembedHeight(quote *Quote, width int) int {
+	// about 9px per character of a 17px serif font, 26px per line
+	lines := len(wrapWords(quote.Content, (width-40)/9))
+	return 2*16 + lines*26 + 8 + 22 + 2*16
+}
+
+// /oembed endpoint. return an embeddable quote of `url`, see
+// https://oembed.com
+func oembedHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	query := r.URL.Query()
+	quote_url := query.Get("url")
+	if quote_url == "" {
+		return invalidParameter("oembedHandler.url", errors.New("url is required"))
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "xml" {
+		return &apiError{
+			"oembedHandler.format",
+			errors.New("unsupported format " + format),
+			"format must be json or xml",
+			http.StatusNotImplemented,
+			errCodeNotImplemented,
+		}
+	}
+	maxwidth, err := oembedSize(r, "maxwidth")
+	if err != nil {
+		return invalidParameter("oembedHandler.maxwidth", err)
+	}
+	maxheight, err := oembedSize(r, "maxheight")
+	if err != nil {
+		return invalidParameter("oembedHandler.maxheight", err)
+	}
+	quote, err := oembedQuote(dbUtils, r, quote_url)
+	if err != nil {
+		return databaseError("oembedHandler.oembedQuote", err)
+	}
+
+	// the embed never exceed maxwidth, see oEmbed spec section 2.2
+	if maxwidth != 0 && maxwidth < oembedMinWidth {
+		return &apiError{
+			"oembedHandler.maxwidth",
+			errors.New("maxwidth " + strconv.Itoa(maxwidth) + " is too small"),
+			"the quote can't be embedded in less than " + strconv.Itoa(oembedMinWidth) + " pixels",
+			http.StatusNotImplemented,
+			errCodeNotImplemented,
+		}
+	}
+	width := oembedDefaultWidth
+	if maxwidth != 0 && maxwidth < width {
+		width = maxwidth
+	}
+	// the widest embed is the shortest one
+	height := oembedHeight(quote, width)
+	if maxheight != 0 && maxheight < height {
+		return &apiError{
+			"oembedHandler.maxheight",
+			errors.New("maxheight " + strconv.Itoa(maxheight) + " is too small"),
+			"the quote can't be embedded in less than " + strconv.Itoa(height) + " pixels high",
+			http.StatusNotImplemented,
+			errCodeNotImplemented,
+		}
+	}
+	resp := &oembed{
+		Type:         "rich",
+		Version:      "1.0",
+		Title:        quoteTitle(quote),
+		AuthorName:   quote.Author.Name,
+		ProviderName: "Wisdom",
+		ProviderUrl:  baseURL(r) + "/",
+		CacheAge:     oembedCacheAge,
+		Html:         oembedHtml(quote, width),
+		Width:        width,
+		Height:       height,
+	}
+	if quote.Author.Twitter != "" {
+		resp.AuthorUrl = "https://twitter.com/" + quote.Author.Twitter
+	}
+	// the thumbnail is the card, omitted when it doesn't fit
+	size := cardSizes["opengraph"]
+	if (maxwidth == 0 || size.Width <= maxwidth) && (maxheight == 0 || size.Height <= maxheight) {
+		resp.ThumbnailUrl = baseURL(r) + "/v1/quotes/" + strconv.Itoa(quote.Id) + "/card.png"
+		resp.ThumbnailWidth = size.Width
+		resp.ThumbnailHeight = size.Height
+	}
+
+	if format == "xml" {
+		b, err := xml.MarshalIndent(resp, "", "  ")
+		if err != nil {
+			return &apiError{
+				"oembedHandler.MarshalIndent",
+				err,
+				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+				http.StatusInternalServerError,
+				errCodeInternal,
+			}
+		}
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.Write([]byte(`<?xml version="1.0" encoding="utf-8" standalone="yes"?>` + "\n"))
+		w.Write(b)
+		return nil
+	}
+	return writeJSON(w, r, resp, "oembedHandler.resp")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// quotePageTemplate is the human-facing page of a quote, with metadata for
+// link previews and search engines
+var quotePageTemplate = template.Must(template.New("quote").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta name="description" content="{{.Quote.Content}}">
+<link rel="canonical" href="{{.Url}}">
+<link rel="alternate" type="application/json" href="{{.JSONUrl}}">
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedJSONUrl}}" title="{{.Title}}">
+<link rel="alternate" type="text/xml+oembed" href="{{.OEmbedXMLUrl}}" title="{{.Title}}">
+<meta property="og:type" content="article">
+<meta property="og:site_name" content="Wisdom">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Quote.Content}}">
+<meta property="og:url" content="{{.Url}}">
+<meta property="og:image" content="{{.ImageUrl}}">
+<meta property="og:image:type" content="image/png">
+<meta property="og:image:width" content="{{.ImageWidth}}">
+<meta property="og:image:height" content="{{.ImageHeight}}">
+<meta property="og:image:alt" content="{{.Description}}">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Quote.Content}}">
+<meta name="twitter:image" content="{{.TwitterImageUrl}}">
+<meta name="twitter:image:alt" content="{{.Description}}">
+{{if .Quote.Author.Twitter}}<meta name="twitter:creator" content="@{{.Quote.Author.Twitter}}">
+{{end}}<script type="application/ld+json">{{.JSONLD}}</script>
+<style>{{.Style}}</style>
+</head>
+<body>
+<main>
+<blockquote cite="{{.Url}}">
+<p>{{.Quote.Content}}</p>
+<footer>{{if .Quote.Author.AvatarUrl}}<img class="avatar" src="{{.Quote.Author.AvatarUrl}}" alt="">{{end}}— <cite>{{if .Quote.Author.Twitter}}<a href="https://twitter.com/{{.Quote.Author.Twitter}}" rel="author">{{.Quote.Author.Name}}</a>{{else}}{{.Quote.Author.Name}}{{end}}</cite>{{if .Quote.Author.Company}}, {{.Quote.Author.Company}}{{end}}</footer>
+</blockquote>
+{{if .Quote.Tags}}<ul class="tags">
+{{range .Quote.Tags}}<li>{{.Label}}</li>
+{{end}}</ul>
+{{end}}{{if .Quote.Permalink}}<p><a href="{{.Quote.Permalink}}">Original post</a></p>
+{{end}}</main>
+</body>
+</html>
+`))
+
+// newNonce return a random nonce of Content-Security-Policy
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// quotePageStyle is the inline style sheet of quote pages
+const quotePageStyle = `
+body { max-width: 40em; margin: 4em auto; padding: 0 1em; background: #fafaf7; color: #222; font: 1.25em/1.5 Georgia, serif; }
+blockquote { margin: 0; padding: 0 0 0 1em; border-left: 4px solid #888; }
+blockquote p { margin: 0 0 .5em; font-size: 1.3em; }
+footer { color: #666; }
+cite { font-style: normal; }
+img.avatar { width: 48px; height: 48px; border-radius: 50%; vertical-align: middle; margin-right: .5em; }
+ul.tags { list-style: none; padding: 0; margin: 2em 0 0; font-size: .8em; }
+ul.tags li { display: inline-block; margin: 0 .5em .5em 0; padding: .1em .6em; border: 1px solid #ccc; border-radius: 1em; }
+a { color: inherit; }
+`
+
+// quotePageCSP is the Content-Security-Policy of quote pages. The style
+// sheet is allowed by its hash instead of a nonce, so pages can be cached
+// by shared caches. JSON-LD is data, not a script
+var quotePageCSP = "default-src 'none'; style-src '" + cspHash(quotePageStyle) + "'; img-src https: http:; base-uri 'none'; form-action 'none'"
+
+// cspHash return the Content-Security-Policy hash source of an inline
+// script or style
+func cspHash(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return "sha256-" + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// quoteJSONLD return the schema.org Quotation of a quote
+func quoteJSONLD(quote *Quote, page_url, image_url string) map[string]interface{} {
+	author := map[string]interface{}{
+		"@type": "Person",
+		"name":  quote.Author.Name,
+	}
+	if quote.Author.Twitter != "" {
+		author["sameAs"] = "https://twitter.com/" + quote.Author.Twitter
+	}
+	if quote.Author.Company != "" {
+		author["worksFor"] = map[string]interface{}{"@type": "Organization", "name": quote.Author.Company}
+	}
+	if quote.Author.AvatarUrl != "" {
+		author["image"] = quote.Author.AvatarUrl
+	}
+	var keywords []string
+	for _, tag := range quote.Tags {
+		keywords = append(keywords, tag.Label)
+	}
+	ld := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "Quotation",
+		"@id":      page_url,
+		"url":      page_url,
+		"text":     quote.Content,
+		"creator":  author,
+		"image":    image_url,
+	}
+	if len(keywords) > 0 {
+		ld["keywords"] = keywords
+	}
+	if quote.Permalink != "" {
+		ld["isBasedOn"] = quote.Permalink
+	}
+	return ld
+}
+
+// /q/{id} endpoint. return the HTML page of a quote
+func quotePageHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	quote, err := dbUtils.QuoteById(id)
+	if err != nil {
+		return databaseError("quotePageHandler.QuoteById", err)
+	}
+
+	base := baseURL(r)
+	page_url := base + "/q/" + strconv.Itoa(quote.Id)
+	card_url := base + "/v1/quotes/" + strconv.Itoa(quote.Id) + "/card.png"
+	size := cardSizes["opengraph"]
+	var buf bytes.Buffer
+	err = quotePageTemplate.Execute(&buf, map[string]interface{}{
+		"Quote":           quote,
+		"Title":           quoteTitle(quote),
+		"Description":     quote.Content + " — " + quote.Author.Name,
+		"Url":             page_url,
+		"JSONUrl":         base + "/v2/quotes/" + strconv.Itoa(quote.Id),
+		"OEmbedJSONUrl":   oembedURL(r, page_url, "json"),
+		"OEmbedXMLUrl":    oembedURL(r, page_url, "xml"),
+		"ImageUrl":        card_url + "?size=opengraph",
+		"ImageWidth":      size.Width,
+		"ImageHeight":     size.Height,
+		"TwitterImageUrl": card_url + "?size=twitter",
+		"JSONLD":          quoteJSONLD(quote, page_url, card_url+"?size=opengraph"),
+		"Style":           template.CSS(quotePageStyle),
+	})
+	if err != nil {
+		return &apiError{
+			"quotePageHandler.Execute",
+			err,
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+
+	w.Header().Set("Content-Security-Policy", quotePageCSP)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Link", oembedLinks(r, page_url))
+	w.Write(buf.Bytes())
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// stable machine-readable error codes. Clients can rely on these values,
+// never change or reuse them
+const (
+	errCodeNotFound         = "not_found"
+	errCodeQuoteNotFound    = "quote_not_found"
+	errCodeAuthorNotFound   = "author_not_found"
+	errCodeTagNotFound      = "tag_not_found"
+	errCodeInvalidParameter = "invalid_parameter"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeDatabase         = "database_error"
+	errCodeInternal         = "internal_error"
+	errCodeUnavailable      = "service_unavailable"
+	errCodeWebhookNotFound  = "webhook_not_found"
+	errCodeDeliveryNotFound = "delivery_not_found"
+	errCodeUnauthorized     = "unauthorized"
+	errCodeForbidden        = "forbidden"
+	errCodeNotImplemented   = "not_implemented"
+	errCodeLinkNotFound     = "link_not_found"
+	errCodeUpgradeRequired  = "upgrade_required"
+)
+
+// short human-readable summary of every error code
+var errCodeTitles = map[string]string{
+	errCodeNotFound:         "Resource not found",
+	errCodeQuoteNotFound:    "Quote not found",
+	errCodeAuthorNotFound:   "Author not found",
+	errCodeTagNotFound:      "Tag not found",
+	errCodeInvalidParameter: "Invalid parameter",
+	errCodeMethodNotAllowed: "Method not allowed",
+	errCodeDatabase:         "Database error",
+	errCodeInternal:         "Internal server error",
+	errCodeUnavailable:      "Service unavailable",
+	errCodeWebhookNotFound:  "Webhook not found",
+	errCodeDeliveryNotFound: "Webhook delivery not found",
+	errCodeUnauthorized:     "Unauthorized",
+	errCodeForbidden:        "Forbidden",
+	errCodeNotImplemented:   "Not implemented",
+	errCodeLinkNotFound:     "Short link not found",
+	errCodeUpgradeRequired:  "Upgrade required",
+}
+
+// prefix of problem type URI, followed by error code
+const problemTypePrefix = "urn:wisdom:error:"
+
+// media type of RFC 7807 problem details
+const problemMediaType = "application/problem+json"
+
+// problem define structure of RFC 7807 problem details response
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance"`
+	Code      string `json:"code"`
+	RequestId string `json:"request_id"`
+}
+
+// newProblem create problem details from an apiError
+func newProblem(err *apiError, r *http.Request, requestId string) *problem {
+	code := err.ErrorCode
+	if code == "" {
+		code = errCodeInternal
+	}
+	title, ok := errCodeTitles[code]
+	if !ok {
+		title = http.StatusText(err.Code)
+	}
+	return &problem{
+		Type:      problemTypePrefix + code,
+		Title:     title,
+		Status:    err.Code,
+		Detail:    err.Message,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestId: requestId,
+	}
+}
+
+// acceptProblem check if client ask for problem details in Accept header.
+// Otherwise v1 error is responded in `{"error":"...","code":500}` format
+func acceptProblem(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == problemMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError write apiError as problem details or v1 error response. v2
+// errors are always problem details
+func writeError(w http.ResponseWriter, r *http.Request, err *apiError, requestId string) error {
+	var body interface{} = err
+	if acceptProblem(r) || apiVersion(r) >= 2 {
+		w.Header().Set("Content-Type", problemMediaType+"; charset=utf-8")
+		body = newProblem(err, r, requestId)
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.WriteHeader(err.Code)
+	return json.NewEncoder(w).Encode(body)
+}
+
+// client provided request id only accepted if it looks sane
+var requestIdRegexp = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// newRequestId return X-Request-Id header from client or generate a new one
+func newRequestId(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); requestIdRegexp.MatchString(id) {
+		return id
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// databaseError convert error from DatabaseUtils to apiError
+func databaseError(tag string, err error) *apiError {
+	switch err {
+	case errQuoteNotFound:
+		return &apiError{
+			tag + ".errQuoteNotFound",
+			err,
+			"Quote not found",
+			http.StatusNotFound,
+			errCodeQuoteNotFound,
+		}
+	case errAuthorNotFound:
+		return &apiError{
+			tag + ".errAuthorNotFound",
+			err,
+			"Author not found",
+			http.StatusNotFound,
+			errCodeAuthorNotFound,
+		}
+	case errTagNotFound:
+		return &apiError{
+			tag + ".errTagNotFound",
+			err,
+			"Tag not found",
+			http.StatusNotFound,
+			errCodeTagNotFound,
+		}
+	case errWebhookNotFound:
+		return &apiError{
+			tag + ".errWebhookNotFound",
+			err,
+			"Webhook not found",
+			http.StatusNotFound,
+			errCodeWebhookNotFound,
+		}
+	case errDeliveryNotFound:
+		return &apiError{
+			tag + ".errDeliveryNotFound",
+			err,
+			"Webhook delivery not found",
+			http.StatusNotFound,
+			errCodeDeliveryNotFound,
+		}
+	case errShortLinkNotFound:
+		return &apiError{
+			tag + ".errShortLinkNotFound",
+			err,
+			"Short link not found",
+			http.StatusNotFound,
+			errCodeLinkNotFound,
+		}
+	}
+	return &apiError{
+		tag + ".Err",
+		err,
+		"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+		http.StatusInternalServerError,
+		errCodeDatabase,
+	}
+}
+
+// invalidParameter create apiError for an invalid request parameter
+func invalidParameter(tag string, err error) *apiError {
+	return &apiError{
+		tag + ".invalidParameter",
+		err,
+		err.Error(),
+		http.StatusBadRequest,
+		errCodeInvalidParameter,
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+// This file implement protocol buffers wire format for the messages of
+// proto/wisdom/v1/wisdom.proto. Field numbers must be kept in sync with
+// the proto file.
+
+// protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("protobuf: truncated message")
+
+// protoEncoder append fields to a protobuf message. proto3 default values
+// (0, "") are not encoded
+type protoEncoder struct {
+	buf []byte
+}
+
+func (e *protoEncoder) appendVarint(v uint64) {
+	for v >= 0x80 {
+		e.buf = append(e.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *protoEncoder) appendKey(field, wireType int) {
+	e.appendVarint(uint64(field<<3 | wireType))
+}
+
+// int32 encode an int32 field, negative values are sign extended
+func (e *protoEncoder) int32(field int, v int) {
+	if v == 0 {
+		return
+	}
+	e.appendKey(field, protoVarint)
+	e.appendVarint(uint64(int64(int32(v))))
+}
+
+func (e *protoEncoder) string(field int, v string) {
+	if v == "" {
+		return
+	}
+	e.bytes(field, []byte(v))
+}
+
+func (e *protoEncoder) bytes(field int, v []byte) {
+	e.appendKey(field, protoBytes)
+	e.appendVarint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// message encode an embedded message, it is always encoded even if empty
+func (e *protoEncoder) message(field int, v []byte) {
+	e.bytes(field, v)
+}
+
+// protoField is a decoded field. Varint is set for varint fields and Bytes
+// for length-delimited fields
+type protoField struct {
+	Number   int
+	WireType int
+	Varint   uint64
+	Bytes    []byte
+}
+
+// decodeProto call fn for every field of a protobuf message
+func decodeProto(b []byte, fn func(field protoField) error) error {
+	for len(b) > 0 {
+		key, n := protoReadVarint(b)
+		if n == 0 {
+			return errProtoTruncated
+		}
+		b = b[n:]
+		field := protoField{Number: int(key >> 3), WireType: int(key & 7)}
+		if field.Number == 0 {
+			return errors.New("protobuf: invalid field number 0")
+		}
+		switch field.WireType {
+		case protoVarint:
+			field.Varint, n = protoReadVarint(b)
+			if n == 0 {
+				return errProtoTruncated
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return errProtoTruncated
+			}
+			b = b[8:]
+		case protoBytes:
+			length, n := protoReadVarint(b)
+			if n == 0 || uint64(len(b)-n) < length {
+				return errProtoTruncated
+			}
+			field.Bytes = b[n : n+int(length)]
+			b = b[n+int(length):]
+		case protoFixed32:
+			if len(b) < 4 {
+				return errProtoTruncated
+			}
+			b = b[4:]
+		default:
+			return errors.New("protobuf: unsupported wire type " + strconv.Itoa(field.WireType))
+		}
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoReadVarint read a varint, n is 0 if b is truncated
+func protoReadVarint(b []byte) (v uint64, n int) {
+	for shift := uint(0); shift < 64; shift += 7 {
+		if n >= len(b) {
+			return 0, 0
+		}
+		c := b[n]
+		n++
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v, n
+		}
+	}
+	return 0, 0
+}
+
+// --- messages ---
+
+func encodeAuthor(author *Author) []byte {
+	e := &protoEncoder{}
+	e.int32(1, author.Id)
+	e.string(2, author.Name)
+	e.string(3, author.Company)
+	e.string(4, author.AvatarUrl)
+	e.string(5, author.Twitter)
+	return e.buf
+}
+
+func encodeTag(tag *Tag) []byte {
+	e := &protoEncoder{}
+	e.int32(1, tag.Id)
+	e.string(2, tag.Label)
+	return e.buf
+}
+
+func encodeQuote(quote *Quote) []byte {
+	e := &protoEncoder{}
+	e.int32(1, quote.Id)
+	e.string(2, quote.PostId)
+	e.message(3, encodeAuthor(&quote.Author))
+	e.string(4, quote.Content)
+	e.string(5, quote.Permalink)
+	e.string(6, quote.PictureUrl)
+	for i := range quote.Tags {
+		e.message(7, encodeTag(&quote.Tags[i]))
+	}
+	return e.buf
+}
+
+// grpcPage is the common part of list responses
+type grpcPage struct {
+	NextPageToken string
+	TotalSize     int
+}
+
+func encodeListAuthorsResponse(authors []Author, page grpcPage) []byte {
+	e := &protoEncoder{}
+	for i := range authors {
+		e.message(1, encodeAuthor(&authors[i]))
+	}
+	e.string(2, page.NextPageToken)
+	e.int32(3, page.TotalSize)
+	return e.buf
+}
+
+func encodeListQuotesByAuthorResponse(quotes []*Quote, page grpcPage) []byte {
+	e := &protoEncoder{}
+	for _, quote := range quotes {
+		e.message(1, encodeQuote(quote))
+	}
+	e.string(2, page.NextPageToken)
+	e.int32(3, page.TotalSize)
+	return e.buf
+}
+
+func encodeListTagsResponse(tags []Tag, page grpcPage) []byte {
+	e := &protoEncoder{}
+	for i := range tags {
+		e.message(1, encodeTag(&tags[i]))
+	}
+	e.string(2, page.NextPageToken)
+	e.int32(3, page.TotalSize)
+	return e.buf
+}
+
+// protoRequest hold decoded request fields by number. Only int32 and
+// string fields are used by requests
+type protoRequest struct {
+	ints    map[int]int
+	strings map[int]string
+}
+
+// decodeRequest decode a request message
+func decodeRequest(b []byte) (*protoRequest, error) {
+	req := &protoRequest{ints: make(map[int]int), strings: make(map[int]string)}
+	err := decodeProto(b, func(field protoField) error {
+		switch field.WireType {
+		case protoVarint:
+			req.ints[field.Number] = int(int32(field.Varint))
+		case protoBytes:
+			req.strings[field.Number] = string(field.Bytes)
+		}
+		return nil
+	})
+	return req, err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestProtoEncoder(t *testing.T) {
+	tests := []struct {
+		desc   string
+		encode func(e *protoEncoder)
+		want   []byte
+	}{
+		{"int32", func(e *protoEncoder) { e.int32(1, 150) }, []byte{0x08, 0x96, 0x01}},
+		{"negative int32", func(e *protoEncoder) { e.int32(1, -1) }, []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"zero int32", func(e *protoEncoder) { e.int32(1, 0) }, nil},
+		{"string", func(e *protoEncoder) { e.string(2, "testing") }, append([]byte{0x12, 0x07}, "testing"...)},
+		{"empty string", func(e *protoEncoder) { e.string(2, "") }, nil},
+		{"empty message", func(e *protoEncoder) { e.message(3, nil) }, []byte{0x1a, 0x00}},
+		{"large field number", func(e *protoEncoder) { e.int32(16, 1) }, []byte{0x80, 0x01, 0x01}},
+		{"max varint", func(e *protoEncoder) { e.appendVarint(math.MaxUint64) }, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+	}
+	for _, test := range tests {
+		e := &protoEncoder{}
+		test.encode(e)
+		if !bytes.Equal(e.buf, test.want) {
+			t.Errorf("%s: encoded %x, want %x", test.desc, e.buf, test.want)
+		}
+	}
+}
+
+func TestEncodeMessages(t *testing.T) {
+	tag := Tag{Id: 3, Label: "go"}
+	encodedTag := []byte{0x08, 0x03, 0x12, 0x02, 'g', 'o'}
+	tests := []struct {
+		desc string
+		got  []byte
+		want []byte
+	}{
+		{"tag", encodeTag(&tag), encodedTag},
+		{"author", encodeAuthor(&Author{Id: 2, Name: "A", Twitter: "a"}), []byte{0x08, 0x02, 0x12, 0x01, 'A', 0x2a, 0x01, 'a'}},
+		// author is always encoded, even if empty
+		{"empty quote", encodeQuote(&Quote{}), []byte{0x1a, 0x00}},
+		{
+			"quote",
+			encodeQuote(&Quote{Id: 1, Author: Author{Id: 2, Name: "A"}, Content: "hi", Tags: []Tag{tag}}),
+			append([]byte{0x08, 0x01, 0x1a, 0x05, 0x08, 0x02, 0x12, 0x01, 'A', 0x22, 0x02, 'h', 'i', 0x3a, 0x06}, encodedTag...),
+		},
+		{
+			"list tags",
+			encodeListTagsResponse([]Tag{tag}, grpcPage{"10", 25}),
+			append(append([]byte{0x0a, 0x06}, encodedTag...), 0x12, 0x02, '1', '0', 0x18, 25),
+		},
+		{"last page", encodeListAuthorsResponse(nil, grpcPage{"", 0}), nil},
+	}
+	for _, test := range tests {
+		if !bytes.Equal(test.got, test.want) {
+			t.Errorf("%s: encoded %x, want %x", test.desc, test.got, test.want)
+		}
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	e := &protoEncoder{}
+	e.int32(1, 150)
+	e.string(2, "testing")
+	e.int32(3, -2)
+	// fixed64 and fixed32 fields are skipped
+	e.appendKey(4, protoFixed64)
+	e.buf = append(e.buf, 1, 2, 3, 4, 5, 6, 7, 8)
+	e.appendKey(5, protoFixed32)
+	e.buf = append(e.buf, 1, 2, 3, 4)
+	// last value of a field is kept
+	e.string(2, "again")
+	req, err := decodeRequest(e.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ints) != 2 || req.ints[1] != 150 || req.ints[3] != -2 || len(req.strings) != 1 || req.strings[2] != "again" {
+		t.Errorf("decodeRequest(%x) = %v, %v", e.buf, req.ints, req.strings)
+	}
+
+	if req, err := decodeRequest(nil); err != nil || len(req.ints) != 0 || len(req.strings) != 0 {
+		t.Errorf("decodeRequest(nil) = %v, %v, %v", req.ints, req.strings, err)
+	}
+
+	malformed := map[string][]byte{
+		"truncated key":     {0x80},
+		"truncated varint":  {0x08, 0x96},
+		"truncated string":  {0x12, 0x05, 'a'},
+		"truncated fixed64": {0x21, 1, 2, 3},
+		"truncated fixed32": {0x2d, 1, 2},
+		"field number 0":    {0x00, 0x01},
+		"group wire type":   {0x0b},
+		"varint too long":   {0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"length overflow":   {0x12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+	}
+	for desc, b := range malformed {
+		if _, err := decodeRequest(b); err == nil {
+			t.Errorf("%s: decodeRequest(%x) didn't fail", desc, b)
+		}
+	}
+}
+
+func TestProtoReadVarint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 300, math.MaxUint32, math.MaxUint64} {
+		e := &protoEncoder{}
+		e.appendVarint(v)
+		got, n := protoReadVarint(append(e.buf, 0xaa))
+		if got != v || n != len(e.buf) {
+			t.Errorf("protoReadVarint(%x) = %d, %d, want %d, %d", e.buf, got, n, v, len(e.buf))
+		}
+	}
+}
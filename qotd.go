@@ -0,0 +1,174 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// enable the RFC 865 Quote of the Day server if not empty
+	QOTD_ENABLED = os.Getenv("QOTD_ENABLED")
+	// TCP and UDP port of the Quote of the Day server, default 17
+	QOTD_PORT = os.Getenv("QOTD_PORT")
+	// quotes per minute per client IP, default 10
+	QOTD_RATE_LIMIT = os.Getenv("QOTD_RATE_LIMIT")
+)
+
+const (
+	qotdDefaultPort      = "17"
+	qotdDefaultRateLimit = 10
+	// RFC 865: the quote should be limited to 512 characters
+	qotdMaxLength = 511
+	qotdWidth     = 72
+	qotdTimeout   = 10 * time.Second
+)
+
+// UDP source ports of services answering any datagram. Answering them
+// would start an endless loop between two servers
+var qotdReflectorPorts = map[int]bool{0: true, 7: true, 13: true, 17: true, 19: true, 37: true}
+
+// qotdText return quote as ASCII lines ending with CRLF, at most 511
+// characters. Long quotes are truncated
+func qotdText(quote *Quote) string {
+	attribution := "    -- " + fontText(quote.Author.Name)
+	if quote.Author.Company != "" {
+		attribution += ", " + fontText(quote.Author.Company)
+	}
+	attribution = truncateASCII(attribution, qotdWidth) + "\r\n"
+
+	var b strings.Builder
+	for _, line := range wrapWords(fontText(quote.Content), qotdWidth) {
+		line += "\r\n"
+		if b.Len()+len(line)+len(attribution) > qotdMaxLength {
+			// replace the last word by an ellipsis
+			text := strings.TrimRight(b.String(), "\r\n")
+			text = truncateASCII(text, len(text)-3)
+			if i := strings.LastIndexByte(text, ' '); i > 0 {
+				text = text[:i]
+			}
+			b.Reset()
+			b.WriteString(text + "...\r\n")
+			break
+		}
+		b.WriteString(line)
+	}
+	return b.String() + attribution
+}
+
+// truncateASCII cut ASCII text to at most max characters, indentation is
+// kept
+func truncateASCII(text string, max int) string {
+	if max < 0 {
+		max = 0
+	}
+	if len(text) > max {
+		return strings.TrimRight(text[:max], " ")
+	}
+	return text
+}
+
+// qotdServer answer every TCP connection and UDP datagram with a random
+// quote, see RFC 865
+type qotdServer struct {
+	dbUtils *DatabaseUtils
+	limiter *ipLimiter
+}
+
+// newQotdServer create a server rate limited with QOTD_RATE_LIMIT
+func newQotdServer(dbUtils *DatabaseUtils) *qotdServer {
+	rate, err := strconv.Atoi(QOTD_RATE_LIMIT)
+	if err != nil || rate < 1 {
+		rate = qotdDefaultRateLimit
+	}
+	return &qotdServer{dbUtils, newIPLimiter(rate, rate)}
+}
+
+// quote return the text of a random quote, like randomHandler
+func (s *qotdServer) quote() (string, error) {
+	quote, err := s.dbUtils.RandomQuote()
+	if err != nil {
+		return "", err
+	}
+	return qotdText(quote), nil
+}
+
+// serveTCP send a quote on every connection and close it
+func (s *qotdServer) serveTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if !s.limiter.allow(addrIP(conn.RemoteAddr())) {
+				return
+			}
+			text, err := s.quote()
+			if err != nil {
+				log.Printf("qotd %s tcp: %s", conn.RemoteAddr(), err)
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(qotdTimeout))
+			conn.Write([]byte(text))
+		}()
+	}
+}
+
+// serveUDP answer every datagram with a quote. Datagrams are dropped when
+// the client is rate limited, so the server can't be used to amplify
+// traffic to a spoofed address
+func (s *qotdServer) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, 512)
+	for {
+		_, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		if udp, ok := addr.(*net.UDPAddr); ok && qotdReflectorPorts[udp.Port] {
+			continue
+		}
+		if !s.limiter.allow(addrIP(addr)) {
+			continue
+		}
+		text, err := s.quote()
+		if err != nil {
+			log.Printf("qotd %s udp: %s", addr, err)
+			continue
+		}
+		conn.WriteTo([]byte(text), addr)
+	}
+}
+
+// serveQotd listen on TCP and UDP port
+func serveQotd(port string, dbUtils *DatabaseUtils) error {
+	if port == "" {
+		port = qotdDefaultPort
+	}
+	s := newQotdServer(dbUtils)
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	packetConn, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	log.Printf("QOTD listening on :%s (tcp, udp)", port)
+	errs := make(chan error, 2)
+	go func() { errs <- s.serveTCP(listener) }()
+	go func() { errs <- s.serveUDP(packetConn) }()
+	return <-errs
+}
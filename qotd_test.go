@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQotdText(t *testing.T) {
+	quote := &Quote{Content: "Make something “people” want.", Author: Author{Name: "Paul Graham", Company: "Y Combinator"}}
+	if got, want := qotdText(quote), "Make something \"people\" want.\r\n    -- Paul Graham, Y Combinator\r\n"; got != want {
+		t.Errorf("qotdText() = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		desc    string
+		content string
+		author  Author
+	}{
+		{"long quote", strings.Repeat("lorem ipsum ", 100), Author{Name: "Paul Graham"}},
+		{"long word", strings.Repeat("a", 1000), Author{Name: "Paul Graham"}},
+		{"non ASCII", strings.Repeat("café ☕ ", 200), Author{Name: "Zoë"}},
+		{"long author", "Short.", Author{Name: strings.Repeat("b", 50), Company: strings.Repeat("c", 50)}},
+		{"short line before limit", strings.Repeat("a", 72) + " " + strings.Repeat("b ", 300), Author{Name: "Paul Graham", Company: "Y Combinator"}},
+	}
+	for _, test := range tests {
+		text := qotdText(&Quote{Content: test.content, Author: test.author})
+		if len(text) > qotdMaxLength {
+			t.Errorf("%s: qotdText() has length %d", test.desc, len(text))
+		}
+		if !strings.HasSuffix(text, "\r\n") {
+			t.Errorf("%s: qotdText() doesn't end with CRLF: %q", test.desc, text)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(text, "\r\n"), "\r\n") {
+			if len(line) > qotdWidth || strings.ContainsAny(line, "\r\n") {
+				t.Errorf("%s: qotdText() has line %q", test.desc, line)
+			}
+		}
+		for i := 0; i < len(text); i++ {
+			if text[i] >= 0x80 {
+				t.Fatalf("%s: qotdText() is not ASCII at %d: %q", test.desc, i, text)
+			}
+		}
+		if !strings.Contains(text, "\r\n    -- ") {
+			t.Errorf("%s: qotdText() has no attribution: %q", test.desc, text)
+		}
+		if len(test.content) > qotdMaxLength && !strings.Contains(text, "...\r\n    -- ") {
+			t.Errorf("%s: qotdText() of a long quote isn't truncated with an ellipsis: %q", test.desc, text)
+		}
+	}
+}
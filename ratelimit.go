@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// idle buckets are removed after this delay
+	rateLimitSweepInterval = time.Minute
+	// maximum number of tracked IPs, requests of new IPs are dropped when
+	// the limit is reached, e.g. during a flood with spoofed sources
+	rateLimitMaxBuckets = 10000
+	// every IP together are limited to this many times the rate of an IP
+	rateLimitGlobalFactor = 100
+)
+
+// ipLimiter is a token bucket rate limiter per client IP, used by the
+// protocol servers (QOTD, DNS, ...) that have no other protection
+type ipLimiter struct {
+	// tokens added per second, and bucket size
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*ipBucket
+	global    ipBucket
+	lastSweep time.Time
+}
+
+type ipBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newIPLimiter create a limiter allowing perMinute requests per minute per
+// IP, in bursts of at most burst requests
+func newIPLimiter(perMinute, burst int) *ipLimiter {
+	return &ipLimiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   map[string]*ipBucket{},
+		global:    ipBucket{tokens: float64(burst * rateLimitGlobalFactor), last: time.Now()},
+		lastSweep: time.Now(),
+	}
+}
+
+// refill add tokens since the last request, up to burst
+func (b *ipBucket) refill(now time.Time, rate, burst float64) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// allow take a token of ip bucket and of the global bucket, it return false
+// when one of them is empty or too many IPs are tracked
+func (l *ipLimiter) allow(ip net.IP) bool {
+	now := time.Now()
+	key := ip.String()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= rateLimitMaxBuckets {
+			// at most one sweep per second, sweeping is slow when full
+			if now.Sub(l.lastSweep) > time.Second {
+				l.sweep(now)
+			}
+			if len(l.buckets) >= rateLimitMaxBuckets {
+				return false
+			}
+		}
+		bucket = &ipBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.refill(now, l.rate, l.burst)
+	l.global.refill(now, l.rate*rateLimitGlobalFactor, l.burst*rateLimitGlobalFactor)
+	if bucket.tokens < 1 || l.global.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	l.global.tokens--
+	return true
+}
+
+// sweep remove buckets refilled since their last request, they are the same
+// as new buckets
+func (l *ipLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// addrIP return IP of a TCP or UDP address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, _ := net.SplitHostPort(addr.String())
+	return net.ParseIP(host)
+}
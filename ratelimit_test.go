@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIPLimiter(t *testing.T) {
+	l := newIPLimiter(60, 3)
+	client := net.ParseIP("192.0.2.1")
+	for i := 0; i < 3; i++ {
+		if !l.allow(client) {
+			t.Fatalf("request %d of the burst denied", i+1)
+		}
+	}
+	if l.allow(client) {
+		t.Error("request over the burst allowed")
+	}
+	if !l.allow(net.ParseIP("192.0.2.2")) {
+		t.Error("request of another client denied")
+	}
+
+	// 60 per minute is a token per second
+	l.buckets[client.String()].last = time.Now().Add(-2 * time.Second)
+	for i := 0; i < 2; i++ {
+		if !l.allow(client) {
+			t.Fatalf("request %d after refill denied", i+1)
+		}
+	}
+	if l.allow(client) {
+		t.Error("request over the refill allowed")
+	}
+
+	// idle buckets are swept
+	l.buckets[client.String()].last = time.Now().Add(-time.Hour)
+	l.lastSweep = time.Now().Add(-2 * rateLimitSweepInterval)
+	l.allow(net.ParseIP("192.0.2.3"))
+	if _, ok := l.buckets[client.String()]; ok {
+		t.Error("idle bucket wasn't swept")
+	}
+}
+
+func TestIPLimiterGlobal(t *testing.T) {
+	l := newIPLimiter(1, 1)
+	for i := 0; i < rateLimitGlobalFactor; i++ {
+		if !l.allow(net.IPv4(10, 0, byte(i>>8), byte(i))) {
+			t.Fatalf("client %d denied", i)
+		}
+	}
+	if l.allow(net.ParseIP("192.0.2.1")) {
+		t.Error("request over the global limit allowed")
+	}
+}
+
+func TestIPLimiterMaxBuckets(t *testing.T) {
+	l := newIPLimiter(1, 1)
+	l.global.tokens = rateLimitMaxBuckets * 2
+	now := time.Now()
+	for i := 0; i < rateLimitMaxBuckets; i++ {
+		l.buckets[strconv.Itoa(i)] = &ipBucket{tokens: 0, last: now}
+	}
+	l.lastSweep = now.Add(-2 * time.Second)
+	if l.allow(net.ParseIP("192.0.2.1")) {
+		t.Error("new client allowed while tracked clients are busy")
+	}
+	if len(l.buckets) != rateLimitMaxBuckets {
+		t.Errorf("%d buckets, want %d", len(l.buckets), rateLimitMaxBuckets)
+	}
+
+	// tracked clients are idle again
+	for _, bucket := range l.buckets {
+		bucket.last = now.Add(-time.Hour)
+	}
+	l.lastSweep = now.Add(-2 * time.Second)
+	if !l.allow(net.ParseIP("192.0.2.1")) {
+		t.Error("new client denied after tracked clients are idle")
+	}
+}
+
+func TestAddrIP(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 17}, "192.0.2.1"},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53}, "2001:db8::1"},
+		{&net.IPAddr{IP: net.ParseIP("192.0.2.1")}, "<nil>"},
+	}
+	for _, test := range tests {
+		if got := addrIP(test.addr).String(); got != test.want {
+			t.Errorf("addrIP(%v) = %s, want %s", test.addr, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+)
+
+// maximum size of JSON-RPC request body and number of calls in a batch
+const (
+	rpcMaxBodySize  = 1 << 20
+	rpcMaxBatchSize = 50
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	// implementation defined server errors
+	rpcDatabaseError = -32000
+	rpcNotFound      = -32004
+)
+
+// rpcRequest define structure of JSON-RPC request
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+}
+
+// rpcResponse define structure of JSON-RPC response
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+// rpcError define structure of JSON-RPC error, data hold the stable error
+// code also used by problem details
+type rpcError struct {
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Data    rpcErrorData `json:"data"`
+}
+
+type rpcErrorData struct {
+	Code string `json:"code"`
+}
+
+func newRpcError(code int, message, errCode string) *rpcError {
+	return &rpcError{code, message, rpcErrorData{errCode}}
+}
+
+// rpcDatabaseErr convert error from DatabaseUtils to rpcError
+func rpcDatabaseErr(err error) *rpcError {
+	switch err {
+	case errQuoteNotFound:
+		return newRpcError(rpcNotFound, "Quote not found", errCodeQuoteNotFound)
+	case errAuthorNotFound:
+		return newRpcError(rpcNotFound, "Author not found", errCodeAuthorNotFound)
+	case errTagNotFound:
+		return newRpcError(rpcNotFound, "Tag not found", errCodeTagNotFound)
+	}
+	return newRpcError(rpcDatabaseError, "Database error", errCodeDatabase)
+}
+
+// rpcMethod is a JSON-RPC method, params is nil if not provided
+type rpcMethod func(dbUtils *DatabaseUtils, params json.RawMessage) (interface{}, *rpcError)
+
+// rpcMethods are every methods exposed on /rpc
+var rpcMethods = map[string]rpcMethod{
+	"quotes.random":  rpcQuotesRandom,
+	"quotes.get":     rpcQuotesGet,
+	"quotes.list":    rpcQuotesList,
+	"authors.list":   rpcAuthorsList,
+	"authors.get":    rpcAuthorsGet,
+	"authors.quotes": rpcAuthorsQuotes,
+	"authors.random": rpcAuthorsRandom,
+	"tags.list":      rpcTagsList,
+}
+
+// decodeParams decode by-name params into v, missing params is allowed
+func decodeParams(params json.RawMessage, v interface{}) *rpcError {
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return newRpcError(rpcInvalidParams, "Invalid params: "+err.Error(), errCodeInvalidParameter)
+	}
+	return nil
+}
+
+// rpcPageParams are params of list methods
+type rpcPageParams struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+}
+
+// limitOffset validate page params and return limit & offset
+func (p *rpcPageParams) limitOffset() (int, int, *rpcError) {
+	if p.Page == 0 {
+		p.Page = 1
+	}
+	if p.PerPage == 0 {
+		p.PerPage = defaultPerPage
+	}
+	if p.Page < 1 || p.PerPage < 1 || p.PerPage > maxPerPage {
+		return 0, 0, newRpcError(rpcInvalidParams, "Invalid params: page must be positive and per_page between 1 and 100", errCodeInvalidParameter)
+	}
+	return p.PerPage, (p.Page - 1) * p.PerPage, nil
+}
+
+// rpcPage define structure of list methods result
+type rpcPage struct {
+	Items   interface{} `json:"items"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+	Total   int         `json:"total"`
+}
+
+// quotes.random {"tag": "...", "author": "twitter_username"}
+func rpcQuotesRandom(dbUtils *DatabaseUtils, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Tag    string `json:"tag"`
+		Author string `json:"author"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	quote, err := dbUtils.RandomQuoteFiltered(p.Tag, p.Author)
+	if err != nil {
+		return nil, rpcDatabaseErr(err)
+	}
+	return quote, nil
+}
+
+// quotes.get {"id": 13}
+func rpcQuotesGet(dbUtils *DatabaseUtils, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Id int `json:"id"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Id <= 0 {
+		return nil, newRpcError(rpcInvalidParams, "Invalid params: id is required", errCodeInvalidParameter)
+	}
+	quote, err := dbUtils.QuoteById(p.Id)
+	if err != nil {
+		return nil, rpcDatabaseErr(err)
+	}
+	return quote, nil
+}
+
+// quotes.list {"page": 1, "per_page": 20}
+func rpcQuotesList(dbUtils *DatabaseUtils, params json.RawMessage) (interface{}, *rpcError) {
+	var p rpcPageParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	limit, offset, rpcErr := p.limitOffset()
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	quotes, total, err := dbUtils.Quotes(limit, offset)
+	if err != nil {
+		return nil, rpcDatabaseErr(err)
+	}
+	if quotes == nil {
+		quotes = []*Quote{}
+	}
+	return &rpcPage{quotes, p.Page, p.PerPage, total}, nil
+}
+
+// authors.list {"page": 1, "per_page": 20}
+func rpcAuthorsList(dbUtils *DatabaseUtils, params json.RawMessage) (interface{}, *rpcError) {
+	var p rpcPageParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	limit, offset, rpcErr := p.limitOffset()
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	authors, total, err := dbUtils.AuthorsPage(limit, offset)
+	if err != nil {
+		return nil, rpcDatabaseErr(err)
+	}
+	if authors == nil {
+		authors = []Author{}
+	}
+	return &rpcPage{authors, p.Page, p.PerPage, total}, nil
+}
+
+// rpcAuthorParams are params of author methods
+type rpcAuthorParams struct {
+	TwitterUsername string `json:"twitter_username"`
+}
+
+func (p *rpcAuthorParams) validate() *rpcError {
+	if p.TwitterUsername == "" {
+		return newRpcError(rpcInvalidParams, "Invalid params: twitter_username is required", errCodeInvalidParameter)
+	}
+	return nil
+}
+
+// authors.get {"twitter_username": "paulg"}
+func rpcAuthorsGet(dbUtils *DatabaseUtils, params json.RawMessage) (interface{}, *rpcError) {
+	var p rpcAuthorParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	author, err := dbUtils.AuthorByTwitterUsername(p.TwitterUsername)
+	if err != nil {
+		return nil, rpcDatabaseErr(err)
+	}
+	return author, nil
+}
+
+// authors.quotes {"twitter_username": "paulg"}
+func rpcAuthorsQuotes(dbUtils *DatabaseUtils, params json.RawMessage) (interface{}, *rpcError) {
+	var p rpcAuthorParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	quotes, err := dbUtils.QuotesByTwitterUsername(p.TwitterUsername)
+	if err != nil {
+		return nil, rpcDatabaseErr(err)
+	}
+	if quotes == nil {
+		quotes = []*Quote{}
+	}
+	return quotes, nil
+}
+
+// authors.random {"twitter_username": "paulg"}
+func rpcAuthorsRandom(dbUtils *DatabaseUtils, params json.RawMessage) (interface{}, *rpcError) {
+	quotes, rpcErr := rpcAuthorsQuotes(dbUtils, params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	list := quotes.([]*Quote)
+	if len(list) == 0 {
+		return nil, rpcDatabaseErr(errQuoteNotFound)
+	}
+	return list[rand.Intn(len(list))], nil
+}
+
+// tags.list {}
+func rpcTagsList(dbUtils *DatabaseUtils, params json.RawMessage) (interface{}, *rpcError) {
+	if err := decodeParams(params, &struct{}{}); err != nil {
+		return nil, err
+	}
+	tags, err := dbUtils.Tags()
+	if err != nil {
+		return nil, rpcDatabaseErr(err)
+	}
+	if tags == nil {
+		tags = []Tag{}
+	}
+	return tags, nil
+}
+
+// call execute a single JSON-RPC request. nil response is returned for
+// notifications
+func rpcCall(dbUtils *DatabaseUtils, raw json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return &rpcResponse{"2.0", nil, newRpcError(rpcInvalidRequest, "Invalid Request", errCodeInvalidParameter), json.RawMessage("null")}
+	}
+	if len(req.Params) > 0 && req.Params[0] != '{' && !bytes.Equal(req.Params, []byte("null")) {
+		if req.Id == nil {
+			return nil
+		}
+		return &rpcResponse{"2.0", nil, newRpcError(rpcInvalidParams, "Invalid params: params must be an object", errCodeInvalidParameter), req.Id}
+	}
+
+	var result interface{}
+	var rpcErr *rpcError
+	method, ok := rpcMethods[req.Method]
+	if ok {
+		result, rpcErr = method(dbUtils, req.Params)
+	} else {
+		rpcErr = newRpcError(rpcMethodNotFound, "Method not found", errCodeNotFound)
+	}
+
+	// notification doesn't have a response
+	if req.Id == nil {
+		return nil
+	}
+	return &rpcResponse{"2.0", result, rpcErr, req.Id}
+}
+
+// /rpc endpoint. execute JSON-RPC 2.0 request or batch
+func rpcHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, rpcMaxBodySize))
+	if err != nil {
+		return invalidParameter("rpcHandler.ReadAll", errors.New("can't read request body"))
+	}
+	body = bytes.TrimSpace(body)
+
+	if !json.Valid(body) {
+		resp := &rpcResponse{"2.0", nil, newRpcError(rpcParseError, "Parse error", errCodeInvalidParameter), json.RawMessage("null")}
+		return writeJSON(w, r, resp, "rpcHandler.parseError")
+	}
+
+	// batch request
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 || len(batch) > rpcMaxBatchSize {
+			resp := &rpcResponse{"2.0", nil, newRpcError(rpcInvalidRequest, "Invalid Request", errCodeInvalidParameter), json.RawMessage("null")}
+			return writeJSON(w, r, resp, "rpcHandler.invalidBatch")
+		}
+		responses := []*rpcResponse{}
+		for _, raw := range batch {
+			if resp := rpcCall(dbUtils, raw); resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		return writeJSON(w, r, responses, "rpcHandler.batch")
+	}
+
+	resp := rpcCall(dbUtils, body)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return writeJSON(w, r, resp, "rpcHandler.resp")
+}
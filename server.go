@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,6 +23,9 @@ var (
 	DATABASE_URL = os.Getenv("DATABASE_URL")
 )
 
+// time given to open requests to complete on shutdown
+const shutdownTimeout = 10 * time.Second
+
 type Author struct {
 	Id        int    `json:"id"`
 	AvatarUrl string `json:"avatar_url"`
@@ -41,27 +47,17 @@ type Quote struct {
 	Permalink  string `json:"permalink"`
 	PictureUrl string `json:"picture_url"`
 	Tags       []Tag  `json:"tags"`
+	// share URLs, only on request
+	Share *shareIntents `json:"share,omitempty"`
 }
 
 // apiError define structure of API error
 type apiError struct {
-	Tag     string `json:"-"`
-	Error   error  `json:"-"`
-	Message string `json:"error"`
-	Code    int    `json:"code"`
-}
-
-// DatabaseUtils represent database utility that used by handler
-type DatabaseUtils struct {
-	DB                               *sql.DB
-	StatementRandom                  *sql.Stmt
-	StatementAuthorById              *sql.Stmt
-	StatementTagIdsByQuoteId         *sql.Stmt
-	StatementTagById                 *sql.Stmt
-	StatementAuthors                 *sql.Stmt
-	StatementAuthorByTwitterUsername *sql.Stmt
-	StatementQuotesByAuthorId        *sql.Stmt
-	StatementTags                    *sql.Stmt
+	Tag       string `json:"-"`
+	Error     error  `json:"-"`
+	Message   string `json:"error"`
+	Code      int    `json:"code"`
+	ErrorCode string `json:"-"`
 }
 
 // ApiHandler global API mux
@@ -74,21 +70,36 @@ func (api ApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// add header on every response
 	w.Header().Add("Server", "Wisdom powered by Gophergala")
-	w.Header().Add("X-Wisdom-Media-Type", "wisdom.V1")
+	request_id := newRequestId(r)
+	w.Header().Set("X-Request-Id", request_id)
+
+	// CORS preflight request doesn't need to reach the handler
+	if cors.handle(w, r) {
+		log.Printf("%s %s %s [preflight]", r.RemoteAddr, r.Method, r.URL)
+		return
+	}
+
+	version := apiVersion(r)
+	w.Header().Add("X-Wisdom-Media-Type", "wisdom.V"+strconv.Itoa(version))
 	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	if version == 1 {
+		deprecation.addHeaders(w, r)
+	}
 
-	// if handler return an &apiError
-	err := api.Handler(w, r, api.DBUtils)
+	// if handler return an &apiError. absolute URLs are built from Host
+	// when PUBLIC_URL is not set, so it must be allowed
+	var err *apiError
+	if allowedHost(r.Host) {
+		err = api.Handler(w, r, api.DBUtils)
+	} else {
+		err = invalidParameter("ApiHandler.allowedHost", errors.New("Invalid Host header, the host is not in ALLOWED_HOSTS"))
+	}
 	if err != nil {
 		// http log
-		log.Printf("%s %s %s [%s] %s", r.RemoteAddr, r.Method, r.URL, err.Tag, err.Error)
+		log.Printf("%s %s %s %s [%s] %s", r.RemoteAddr, r.Method, r.URL, request_id, err.Tag, err.Error)
 
-		// response proper http status code
-		w.WriteHeader(err.Code)
-
-		// response JSON
-		resp := json.NewEncoder(w)
-		err_json := resp.Encode(err)
+		// response proper http status code and JSON error
+		err_json := writeError(w, r, err, request_id)
 		if err_json != nil {
 			log.Println("Encode JSON for error response was failed.")
 
@@ -100,7 +111,7 @@ func (api ApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// http log
 	// TODO: print response
-	log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
+	log.Printf("%s %s %s %s", r.RemoteAddr, r.Method, r.URL, request_id)
 }
 
 // redirect to github pages
@@ -116,657 +127,105 @@ func notFoundHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUt
 		errors.New("Not Found"),
 		"Not Found",
 		http.StatusNotFound,
+		errCodeNotFound,
 	}
 }
 
 // response random quotes
 func randomHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
 	// get the quote
-	var quote_id, quote_author_id int
-	var post_id, content, permalink, picture_url string
-	err := dbUtils.StatementRandom.QueryRow().Scan(&quote_id, &quote_author_id, &post_id, &content, &permalink, &picture_url)
-	if err == sql.ErrNoRows {
-		return &apiError{
-			"randomHandler.StatementRandom.QueryRow.sql.ErrNoRows",
-			err,
-			"Quote not found",
-			http.StatusNotFound,
-		}
-	}
-	if err != nil {
-		return &apiError{
-			"randomHandler.StatementRandom.QueryRow.Err",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-
-	quote := &Quote{
-		Id:         quote_id,
-		PostId:     post_id,
-		Content:    content,
-		Permalink:  permalink,
-		PictureUrl: picture_url,
-	}
-
-	// get the author
-	var author Author
-	var author_id int
-	var avatar_url, name, company_name, twitter_username sql.NullString
-	err = dbUtils.StatementAuthorById.QueryRow(quote_author_id).Scan(&author_id, &avatar_url, &name, &company_name, &twitter_username)
-	if err == sql.ErrNoRows {
-		return &apiError{
-			"randomHandler.StatementAuthorById.QueryRow.sql.ErrNoRows",
-			err,
-			"Author not found",
-			http.StatusNotFound,
-		}
-	}
-	if err != nil {
-		return &apiError{
-			"randomHandler.StatementAuthorById.QueryRow.Err",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-
-	author.Id = author_id
-	author.Name = name.String
-	if avatar_url.Valid {
-		author.AvatarUrl = avatar_url.String
-	} else {
-		author.AvatarUrl = ""
-	}
-	if company_name.Valid {
-		author.Company = company_name.String
-	} else {
-		author.Company = ""
-	}
-	if twitter_username.Valid {
-		author.Twitter = twitter_username.String
-	} else {
-		author.Twitter = ""
-	}
-
-	quote.Author = author
-
-	// get the tag ids
-	var tag_ids []int
-	tagIdsRows, err := dbUtils.StatementTagIdsByQuoteId.Query(quote_id)
+	quote, err := dbUtils.RandomQuote()
 	if err != nil {
-		return &apiError{
-			"randomHandler.tagIdsRows.Err",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-	defer tagIdsRows.Close()
-	for tagIdsRows.Next() {
-		var tag_id int
-		if err := tagIdsRows.Scan(&tag_id); err != nil {
-			return &apiError{
-				"randomHandler.tagIdsRows.Scan.Err",
-				err,
-				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-				http.StatusInternalServerError,
-			}
-		}
-		tag_ids = append(tag_ids, tag_id)
+		return databaseError("randomHandler.RandomQuote", err)
 	}
-
-	// get the tags
-	var tags []Tag
-	for _, tag_id := range tag_ids {
-		var mtag_id int
-		var mtag_label string
-		var tag Tag
-		err := dbUtils.StatementTagById.QueryRow(tag_id).Scan(&mtag_id, &mtag_label)
-		if err == sql.ErrNoRows {
-			return &apiError{
-				"randomHandler.StatementTagById.QueryRow.sql.ErrNoRows",
-				err,
-				"Tag not found",
-				http.StatusNotFound,
-			}
-		}
-		if err != nil {
-			return &apiError{
-				"randomHandler.StatementTagById.QueryRow.Err",
-				err,
-				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-				http.StatusInternalServerError,
-			}
-		}
-		tag.Id = mtag_id
-		tag.Label = mtag_label
-		tags = append(tags, tag)
+	if apiErr := addShareIntents(r, "randomHandler", quote); apiErr != nil {
+		return apiErr
 	}
-	quote.Tags = tags
-
-	// JSONP response
-	query := r.URL.Query()
-	jsonp := query.Get("jsonp")
-	callback := query.Get("callback")
-	if callback != "" || jsonp != "" {
-		jsonResult, err := json.Marshal(quote)
-		if err != nil {
-			log.Println(err)
-		}
-		if callback != "" {
-			fmt.Fprintf(w, "%s(%s)", callback, jsonResult)
-			return nil
-		}
 
-		if jsonp != "" {
-			fmt.Fprintf(w, "%s(%s)", jsonp, jsonResult)
-			return nil
-		}
+	// fortune(6) text form
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "fortune":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(fortuneText(quote)))
+		return nil
+	default:
+		return invalidParameter("randomHandler.format", errors.New("format must be json or fortune"))
 	}
 
-	// write json to response
-	// response JSON
-	randomResp := json.NewEncoder(w)
-	err = randomResp.Encode(quote)
-	if err != nil {
-		return &apiError{
-			"randomHandler.randomResp.Err",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-	return nil
+	// response JSON or JSONP
+	return writeJSON(w, r, quote, "randomHandler.randomResp")
 }
 
 // /v1/authors endpoint. return an array of authors
 func authorsHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
-	// get the rows
-	var authors []Author
-	authorsRows, err := dbUtils.StatementAuthors.Query()
+	// get the authors
+	authors, err := dbUtils.Authors()
 	if err != nil {
-		return &apiError{
-			"authorsHandler.authorsRows.Err",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-	defer authorsRows.Close()
-	for authorsRows.Next() {
-		var author Author
-		var author_id int
-		var avatar_url, name, company_name, twitter_username sql.NullString
-		if err := authorsRows.Scan(&author_id, &avatar_url, &name, &company_name, &twitter_username); err != nil {
-			return &apiError{
-				"authorsHandler.authorsRows.Scan.Err",
-				err,
-				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-				http.StatusInternalServerError,
-			}
-		}
-		author.Id = author_id
-		author.Name = name.String
-		if avatar_url.Valid {
-			author.AvatarUrl = avatar_url.String
-		} else {
-			author.AvatarUrl = ""
-		}
-		if company_name.Valid {
-			author.Company = company_name.String
-		} else {
-			author.Company = ""
-		}
-		if twitter_username.Valid {
-			author.Twitter = twitter_username.String
-		} else {
-			author.Twitter = ""
-		}
-		authors = append(authors, author)
+		return databaseError("authorsHandler.Authors", err)
 	}
 
-	// JSONP response
-	query := r.URL.Query()
-	jsonp := query.Get("jsonp")
-	callback := query.Get("callback")
-	if callback != "" || jsonp != "" {
-		jsonResult, err := json.Marshal(authors)
-		if err != nil {
-			log.Println(err)
-		}
-		if callback != "" {
-			fmt.Fprintf(w, "%s(%s)", callback, jsonResult)
-			return nil
-		}
-
-		if jsonp != "" {
-			fmt.Fprintf(w, "%s(%s)", jsonp, jsonResult)
-			return nil
-		}
-	}
-
-	// response json
-	authorsResp := json.NewEncoder(w)
-	err = authorsResp.Encode(authors)
-	if err != nil {
-		return &apiError{
-			"authorsHandler.authorsResp.Err",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-	return nil
+	// response JSON or JSONP
+	return writeJSON(w, r, authors, "authorsHandler.authorsResp")
 }
 
-func authorTwitterHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+// authorQuotes return every quotes by author with twitter_username route
+// parameter
+func authorQuotes(r *http.Request, dbUtils *DatabaseUtils, tag string) ([]*Quote, *apiError) {
 	// get the parameter
 	vars := mux.Vars(r)
 	twitter_username := vars["twitter_username"]
 
-	// get the author
-	var author Author
-	var author_id int
-	var avatar_url, name, company_name, author_twitter_username sql.NullString
-	err := dbUtils.StatementAuthorByTwitterUsername.QueryRow(twitter_username).Scan(&author_id, &avatar_url, &name, &company_name, &author_twitter_username)
-	if err == sql.ErrNoRows {
-		return &apiError{
-			"authorTwitterHandler.sql.ErrNoRows",
-			err,
-			"Author not found",
-			http.StatusNotFound,
-		}
-	}
-	if err != nil {
-		return &apiError{
-			"authorTwitterHandler.err!=nil",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-
-	author.Id = author_id
-	author.Name = name.String
-	if avatar_url.Valid {
-		author.AvatarUrl = avatar_url.String
-	} else {
-		author.AvatarUrl = ""
-	}
-	if company_name.Valid {
-		author.Company = company_name.String
-	} else {
-		author.Company = ""
-	}
-	if author_twitter_username.Valid {
-		author.Twitter = author_twitter_username.String
-	} else {
-		author.Twitter = ""
-	}
-
 	// get the quotes
-	var quotes []*Quote
-	quotesRows, err := dbUtils.StatementQuotesByAuthorId.Query(author_id)
+	quotes, err := dbUtils.QuotesByTwitterUsername(twitter_username)
 	if err != nil {
-		return &apiError{
-			"authorTwitterHandler.quotesRows.err!=nil",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
+		return nil, databaseError(tag+".QuotesByTwitterUsername", err)
 	}
-	defer quotesRows.Close()
-	for quotesRows.Next() {
-		// get the quote
-		var quote_id, quote_author_id int
-		var post_id, content, permalink, picture_url string
-		if err := quotesRows.Scan(&quote_id, &quote_author_id, &post_id, &content, &permalink, &picture_url); err != nil {
-			return &apiError{
-				"authorTwitterHandler.quotesRows.Scan",
-				err,
-				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-				http.StatusInternalServerError,
-			}
-		}
-
-		quote := &Quote{
-			Id:         quote_id,
-			PostId:     post_id,
-			Content:    content,
-			Permalink:  permalink,
-			PictureUrl: picture_url,
-		}
-
-		// get the tag ids
-		var tag_ids []int
-		tagIdsRows, err := dbUtils.StatementTagIdsByQuoteId.Query(quote.Id)
-		if err != nil {
-			return &apiError{
-				"authorTwitterHandler.tagIdsRows.err!=nil",
-				err,
-				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-				http.StatusInternalServerError,
-			}
-		}
-		defer tagIdsRows.Close()
-		for tagIdsRows.Next() {
-			var tag_id int
-			if err := tagIdsRows.Scan(&tag_id); err != nil {
-				return &apiError{
-					"authorTwitterHandler.tagIdsRows.Scan",
-					err,
-					"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-					http.StatusInternalServerError,
-				}
-			}
-			tag_ids = append(tag_ids, tag_id)
-		}
-
-		// get the tags
-		var tags []Tag
-		for _, tag_id := range tag_ids {
-			var tag Tag
-			var mtag_id int
-			var mtag_label string
-			err := dbUtils.StatementTagById.QueryRow(tag_id).Scan(&mtag_id, &mtag_label)
-			if err == sql.ErrNoRows {
-				return &apiError{
-					"authorTwitterHandler.tagIdsRows.StatementTagById.sql.ErrNoRows",
-					err,
-					"Author not found",
-					http.StatusNotFound,
-				}
-			}
-			if err != nil {
-				return &apiError{
-					"authorTwitterHandler.tagIdsRows.StatementTagById.Err",
-					err,
-					"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-					http.StatusInternalServerError,
-				}
-			}
-			tag.Id = mtag_id
-			tag.Label = mtag_label
-			tags = append(tags, tag)
-		}
+	return quotes, nil
+}
 
-		quote.Tags = tags
-		quote.Author = author
-		quotes = append(quotes, quote)
+func authorTwitterHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	quotes, apiErr := authorQuotes(r, dbUtils, "authorTwitterHandler")
+	if apiErr != nil {
+		return apiErr
 	}
-
-	// JSONP response
-	query := r.URL.Query()
-	jsonp := query.Get("jsonp")
-	callback := query.Get("callback")
-	if callback != "" || jsonp != "" {
-		jsonResult, err := json.Marshal(quotes)
-		if err != nil {
-			log.Println(err)
-		}
-		if callback != "" {
-			fmt.Fprintf(w, "%s(%s)", callback, jsonResult)
-			return nil
-		}
-
-		if jsonp != "" {
-			fmt.Fprintf(w, "%s(%s)", jsonp, jsonResult)
-			return nil
-		}
+	if apiErr := addShareIntents(r, "authorTwitterHandler", quotes...); apiErr != nil {
+		return apiErr
 	}
 
-	// response JSON
-	quotesResp := json.NewEncoder(w)
-	err = quotesResp.Encode(quotes)
-	if err != nil {
-		return &apiError{
-			"authorTwitterHandler.quotesResp.Err",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-	return nil
+	// response JSON or JSONP
+	return writeJSON(w, r, quotes, "authorTwitterHandler.quotesResp")
 }
 
 func authorTwitterRandomHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
-	// get the parameter
-	vars := mux.Vars(r)
-	twitter_username := vars["twitter_username"]
-
-	// get the author
-	var author Author
-	var author_id int
-	var avatar_url, name, company_name, author_twitter_username sql.NullString
-	err := dbUtils.StatementAuthorByTwitterUsername.QueryRow(twitter_username).Scan(&author_id, &avatar_url, &name, &company_name, &author_twitter_username)
-	if err == sql.ErrNoRows {
-		return &apiError{
-			"authorTwitterRandomHandler.sql.ErrNoRows",
-			err,
-			"Author not found",
-			http.StatusNotFound,
-		}
-	}
-	if err != nil {
-		return &apiError{
-			"authorTwitterRandomHandler.err!=nil",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
+	quotes, apiErr := authorQuotes(r, dbUtils, "authorTwitterRandomHandler")
+	if apiErr != nil {
+		return apiErr
 	}
-
-	author.Id = author_id
-	author.Name = name.String
-	if avatar_url.Valid {
-		author.AvatarUrl = avatar_url.String
-	} else {
-		author.AvatarUrl = ""
-	}
-	if company_name.Valid {
-		author.Company = company_name.String
-	} else {
-		author.Company = ""
-	}
-	if author_twitter_username.Valid {
-		author.Twitter = author_twitter_username.String
-	} else {
-		author.Twitter = ""
-	}
-
-	// get the quotes
-	var quotes []*Quote
-	quotesRows, err := dbUtils.StatementQuotesByAuthorId.Query(author_id)
-	if err != nil {
-		return &apiError{
-			"authorTwitterRandomHandler.quotesRows.err!=nil",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-	defer quotesRows.Close()
-	for quotesRows.Next() {
-		// get the quote
-		var quote_id, quote_author_id int
-		var post_id, content, permalink, picture_url string
-		if err := quotesRows.Scan(&quote_id, &quote_author_id, &post_id, &content, &permalink, &picture_url); err != nil {
-			return &apiError{
-				"authorTwitterRandomHandler.quotesRows.Scan",
-				err,
-				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-				http.StatusInternalServerError,
-			}
-		}
-
-		quote := &Quote{
-			Id:         quote_id,
-			PostId:     post_id,
-			Content:    content,
-			Permalink:  permalink,
-			PictureUrl: picture_url,
-		}
-
-		// get the tag ids
-		var tag_ids []int
-		tagIdsRows, err := dbUtils.StatementTagIdsByQuoteId.Query(quote.Id)
-		if err != nil {
-			return &apiError{
-				"authorTwitterRandomHandler.tagIdsRows.err!=nil",
-				err,
-				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-				http.StatusInternalServerError,
-			}
-		}
-		defer tagIdsRows.Close()
-		for tagIdsRows.Next() {
-			var tag_id int
-			if err := tagIdsRows.Scan(&tag_id); err != nil {
-				return &apiError{
-					"authorTwitterRandomHandler.tagIdsRows.Scan",
-					err,
-					"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-					http.StatusInternalServerError,
-				}
-			}
-			tag_ids = append(tag_ids, tag_id)
-		}
-
-		// get the tags
-		var tags []Tag
-		for _, tag_id := range tag_ids {
-			var tag Tag
-			var mtag_id int
-			var mtag_label string
-			err := dbUtils.StatementTagById.QueryRow(tag_id).Scan(&mtag_id, &mtag_label)
-			if err == sql.ErrNoRows {
-				return &apiError{
-					"authorTwitterRandomHandler.tagIdsRows.StatementTagById.sql.ErrNoRows",
-					err,
-					"Author not found",
-					http.StatusNotFound,
-				}
-			}
-			if err != nil {
-				return &apiError{
-					"authorTwitterRandomHandler.tagIdsRows.StatementTagById.Err",
-					err,
-					"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-					http.StatusInternalServerError,
-				}
-			}
-			tag.Id = mtag_id
-			tag.Label = mtag_label
-			tags = append(tags, tag)
-		}
-
-		quote.Tags = tags
-		quote.Author = author
-		quotes = append(quotes, quote)
+	if len(quotes) == 0 {
+		return databaseError("authorTwitterRandomHandler.len(quotes)", errQuoteNotFound)
 	}
 
 	rand.Seed(time.Now().UTC().UnixNano())
 	random := rand.Intn(len(quotes))
-
-	// JSONP response
-	query := r.URL.Query()
-	jsonp := query.Get("jsonp")
-	callback := query.Get("callback")
-	if callback != "" || jsonp != "" {
-		jsonResult, err := json.Marshal(quotes[random])
-		if err != nil {
-			log.Println(err)
-		}
-		if callback != "" {
-			fmt.Fprintf(w, "%s(%s)", callback, jsonResult)
-			return nil
-		}
-
-		if jsonp != "" {
-			fmt.Fprintf(w, "%s(%s)", jsonp, jsonResult)
-			return nil
-		}
+	if apiErr := addShareIntents(r, "authorTwitterRandomHandler", quotes[random]); apiErr != nil {
+		return apiErr
 	}
 
-	// response JSON
-	quotesResp := json.NewEncoder(w)
-	err = quotesResp.Encode(quotes[random])
-	if err != nil {
-		return &apiError{
-			"authorTwitterRandomHandler.quotesResp.Err",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-	return nil
+	// response JSON or JSONP
+	return writeJSON(w, r, quotes[random], "authorTwitterRandomHandler.quotesResp")
 }
 
 // tags handler
 func tagsHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
 	// get the tags
-	var tags []Tag
-	tagsRows, err := dbUtils.StatementTags.Query()
+	tags, err := dbUtils.Tags()
 	if err != nil {
-		return &apiError{
-			"tagsHandler.tagsRows.err!=nil",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-	defer tagsRows.Close()
-	for tagsRows.Next() {
-		var tag Tag
-		var tag_id int
-		var tag_label string
-		if err := tagsRows.Scan(&tag_id, &tag_label); err != nil {
-			return &apiError{
-				"tagsHandler.tagsRows.Scan",
-				err,
-				"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-				http.StatusInternalServerError,
-			}
-		}
-		tag.Id = tag_id
-		tag.Label = tag_label
-		tags = append(tags, tag)
+		return databaseError("tagsHandler.Tags", err)
 	}
 
-	// JSONP response
-	query := r.URL.Query()
-	jsonp := query.Get("jsonp")
-	callback := query.Get("callback")
-	if callback != "" || jsonp != "" {
-		jsonResult, err := json.Marshal(tags)
-		if err != nil {
-			log.Println(err)
-		}
-		if callback != "" {
-			fmt.Fprintf(w, "%s(%s)", callback, jsonResult)
-			return nil
-		}
-
-		if jsonp != "" {
-			fmt.Fprintf(w, "%s(%s)", jsonp, jsonResult)
-			return nil
-		}
-	}
-
-	// response JSON
-	tagsResp := json.NewEncoder(w)
-	err = tagsResp.Encode(tags)
-	if err != nil {
-		return &apiError{
-			"tagsHandler.tagsResp.Err",
-			err,
-			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
-			http.StatusInternalServerError,
-		}
-	}
-	return nil
+	// response JSON or JSONP
+	return writeJSON(w, r, tags, "tagsHandler.tagsResp")
 }
 
 func main() {
@@ -787,92 +246,182 @@ func main() {
 
 	r := mux.NewRouter()
 	// index handler doesn't need database utils
-	r.Handle("/", ApiHandler{Handler: indexHandler})
-
-	// Random handler
-	// prepare a statement
-	stmtQueryRandomQuote, err := db.Prepare("SELECT * FROM quotes ORDER BY RANDOM() LIMIT 1")
-	if err != nil {
-		log.Println(err)
-	}
-
-	stmtQueryAuthor, err := db.Prepare("SELECT * FROM authors WHERE id = $1;")
-	if err != nil {
-		log.Println(err)
-	}
-
-	stmtQueryTagIdsByQuoteId, err := db.Prepare("SELECT tag_id FROM quotes_tags WHERE quote_id = $1")
-	if err != nil {
-		log.Println(err)
-	}
-
-	stmtQueryTagById, err := db.Prepare("SELECT * FROM tags WHERE id = $1")
-	if err != nil {
-		log.Println(err)
-	}
-
-	randomDBUtils := &DatabaseUtils{
-		StatementRandom:          stmtQueryRandomQuote,
-		StatementAuthorById:      stmtQueryAuthor,
-		StatementTagIdsByQuoteId: stmtQueryTagIdsByQuoteId,
-		StatementTagById:         stmtQueryTagById,
-	}
-	r.Handle("/v1/random", ApiHandler{randomDBUtils, randomHandler})
-
-	// Authors handler
-	stmtQueryAuthors, err := db.Prepare("SELECT * FROM authors")
-	if err != nil {
-		log.Println(err)
-	}
-
-	authorsDBUtils := &DatabaseUtils{
-		StatementAuthors: stmtQueryAuthors,
-	}
-
-	r.Handle("/v1/authors", ApiHandler{authorsDBUtils, authorsHandler})
+	r.Handle("/", MethodHandler{[]string{"GET"}, "redirect to documentation", ApiHandler{Handler: indexHandler}})
 
-	// /v1/author/twitter_username handler
-	stmtQueryAuthorByTwitterUsername, err := db.Prepare("SELECT * FROM authors WHERE twitter_username = $1")
+	// prepare statements used by handlers
+	dbUtils, err := NewDatabaseUtils(db)
 	if err != nil {
-		log.Println(err)
-	}
-
-	stmtQueryQuotesByAuthorId, err := db.Prepare("SELECT * FROM quotes WHERE author_id = $1")
-	if err != nil {
-		log.Println(err)
-	}
-
-	authorTwitterDBUtils := &DatabaseUtils{
-		StatementAuthorByTwitterUsername: stmtQueryAuthorByTwitterUsername,
-		StatementQuotesByAuthorId:        stmtQueryQuotesByAuthorId,
-		StatementTagIdsByQuoteId:         stmtQueryTagIdsByQuoteId,
-		StatementTagById:                 stmtQueryTagById,
-	}
-	r.Handle("/v1/author/{twitter_username}", ApiHandler{authorTwitterDBUtils, authorTwitterHandler})
-
-	authorTwitterRandomDBUtils := &DatabaseUtils{
-		StatementAuthorByTwitterUsername: stmtQueryAuthorByTwitterUsername,
-		StatementQuotesByAuthorId:        stmtQueryQuotesByAuthorId,
-		StatementTagIdsByQuoteId:         stmtQueryTagIdsByQuoteId,
-		StatementTagById:                 stmtQueryTagById,
+		log.Fatal(err)
 	}
-	r.Handle("/v1/author/{twitter_username}/random", ApiHandler{authorTwitterRandomDBUtils, authorTwitterRandomHandler})
 
-	// tags handler
-	stmtQueryTags, err := db.Prepare("SELECT * FROM tags")
-	if err != nil {
-		log.Println(err)
+	// `wisdom export` command
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(dbUtils, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	tagsDBUtils := &DatabaseUtils{
-		StatementTags: stmtQueryTags,
-	}
-	r.Handle("/v1/tags", ApiHandler{tagsDBUtils, tagsHandler})
+	// v1 handlers
+	r.Handle("/v1/random", MethodHandler{[]string{"GET"}, "return a random quote", ApiHandler{dbUtils, randomHandler}})
+	r.Handle("/v1/authors", MethodHandler{[]string{"GET"}, "return an array of authors", ApiHandler{dbUtils, authorsHandler}})
+	r.Handle("/v1/author/{twitter_username}", MethodHandler{[]string{"GET"}, "return an array of quotes by author", ApiHandler{dbUtils, authorTwitterHandler}})
+	r.Handle("/v1/author/{twitter_username}/random", MethodHandler{[]string{"GET"}, "return a random quote by author", ApiHandler{dbUtils, authorTwitterRandomHandler}})
+	r.Handle("/v1/tags", MethodHandler{[]string{"GET"}, "return an array of tags", ApiHandler{dbUtils, tagsHandler}})
+	// feeds
+	for _, format := range []string{"rss", "atom"} {
+		r.Handle("/v1/feed."+format, MethodHandler{[]string{"GET"}, "return a feed of newest quotes", ApiHandler{dbUtils, feedHandler}})
+		r.Handle("/v1/tag/{label}/feed."+format, MethodHandler{[]string{"GET"}, "return a feed of newest quotes with tag", ApiHandler{dbUtils, tagFeedHandler}})
+		r.Handle("/v1/author/{twitter_username}/feed."+format, MethodHandler{[]string{"GET"}, "return a feed of newest quotes by author", ApiHandler{dbUtils, authorFeedHandler}})
+		r.Handle("/v1/qotd."+format, MethodHandler{[]string{"GET"}, "return a feed of quotes of the day", ApiHandler{dbUtils, qotdFeedHandler}})
+	}
+	r.Handle("/v1/qotd.ics", MethodHandler{[]string{"GET"}, "return a calendar of quotes of the day", ApiHandler{dbUtils, qotdCalendarHandler}})
+	// cards
+	r.Handle("/v1/quotes/{id:[0-9]+}/card.png", MethodHandler{[]string{"GET"}, "return a card image of a quote", ApiHandler{dbUtils, quoteCardHandler}})
+	r.Handle("/v1/random/card.png", MethodHandler{[]string{"GET"}, "return a card image of a random quote", ApiHandler{dbUtils, randomCardHandler}})
+	r.Handle("/v1/quotes/{id:[0-9]+}/card.svg", MethodHandler{[]string{"GET"}, "return a SVG card of a quote", ApiHandler{dbUtils, quoteCardHandler}})
+	r.Handle("/v1/random/card.svg", MethodHandler{[]string{"GET"}, "return a SVG card of a random quote", ApiHandler{dbUtils, randomCardHandler}})
+	r.Handle("/v1/ws", MethodHandler{[]string{"GET"}, "interactive quote client over WebSocket", ApiHandler{dbUtils, wsHandler}})
+	r.Handle("/v1/stream", MethodHandler{[]string{"GET"}, "stream random quotes and quote changes", ApiHandler{dbUtils, streamHandler}})
+
+	// v2 handlers
+	r.Handle("/v2/random", MethodHandler{[]string{"GET"}, "return a random quote", ApiHandler{dbUtils, v2RandomHandler}})
+	r.Handle("/v2/quotes", MethodHandler{[]string{"GET"}, "return a page of quotes", ApiHandler{dbUtils, v2QuotesHandler}})
+	r.Handle("/v2/quotes/{id:[0-9]+}", MethodHandler{[]string{"GET"}, "return a quote", ApiHandler{dbUtils, v2QuoteHandler}})
+	r.Handle("/v2/authors", MethodHandler{[]string{"GET"}, "return a page of authors", ApiHandler{dbUtils, v2AuthorsHandler}})
+	r.Handle("/v2/author/{twitter_username}", MethodHandler{[]string{"GET"}, "return a page of quotes by author", ApiHandler{dbUtils, v2AuthorTwitterHandler}})
+	r.Handle("/v2/author/{twitter_username}/random", MethodHandler{[]string{"GET"}, "return a random quote by author", ApiHandler{dbUtils, v2AuthorTwitterRandomHandler}})
+	r.Handle("/v2/tags", MethodHandler{[]string{"GET"}, "return a page of tags", ApiHandler{dbUtils, v2TagsHandler}})
+
+	r.Handle("/v1/quotes/{id:[0-9]+}/text", MethodHandler{[]string{"GET"}, "return a quote as shareable text", ApiHandler{dbUtils, quoteTextHandler}})
+	// quote pages
+	r.Handle("/q/{id:[0-9]+}", MethodHandler{[]string{"GET"}, "return the HTML page of a quote", ApiHandler{dbUtils, quotePageHandler}})
+
+	// short links
+	r.Handle("/v1/links", MethodHandler{[]string{"POST"}, "create a short link", ApiHandler{dbUtils, shortLinksHandler}})
+	r.Handle("/v1/links/{code:[0-9A-Za-z]+}", MethodHandler{[]string{"GET"}, "return a short link", ApiHandler{dbUtils, shortLinkHandler}})
+	r.Handle("/s/{code:[0-9A-Za-z]+}", MethodHandler{[]string{"GET"}, "redirect a short link", ApiHandler{dbUtils, shortRedirectHandler}})
+
+	// embeddable widget
+	r.Handle("/widget.js", MethodHandler{[]string{"GET"}, "return the latest widget script", ApiHandler{dbUtils, widgetScriptHandler}})
+	r.Handle("/widget/v"+widgetVersion+"/widget.js", MethodHandler{[]string{"GET"}, "return the widget script", ApiHandler{dbUtils, widgetScriptHandler}})
+	r.Handle("/embed/random", MethodHandler{[]string{"GET"}, "return an embeddable page of a random quote", ApiHandler{dbUtils, embedRandomHandler}})
+	r.Handle("/embed/random.json", MethodHandler{[]string{"GET"}, "return a random quote for widgets", ApiHandler{dbUtils, embedRandomJSONHandler}})
+
+	// oEmbed provider
+	r.Handle("/oembed", MethodHandler{[]string{"GET"}, "return an embeddable quote of a quote URL", ApiHandler{dbUtils, oembedHandler}})
+
+	// Slack app
+	r.Handle("/slack/commands", MethodHandler{[]string{"POST"}, "respond to the /wisdom Slack slash command", ApiHandler{dbUtils, slackCommandHandler}})
+	r.Handle("/slack/interactions", MethodHandler{[]string{"POST"}, "respond to Slack message buttons", ApiHandler{dbUtils, slackInteractionHandler}})
+
+	// GraphQL handler
+	graphql := GraphQLHandler{newGraphQLSchema(dbUtils)}
+	r.Handle("/graphql", MethodHandler{[]string{"POST"}, "execute a GraphQL query", ApiHandler{dbUtils, graphql.graphqlHandler}})
+	r.Handle("/graphql/schema", MethodHandler{[]string{"GET"}, "return the GraphQL schema", ApiHandler{dbUtils, graphqlSchemaHandler}})
+
+	// JSON-RPC handler
+	r.Handle("/rpc", MethodHandler{[]string{"POST"}, "execute a JSON-RPC 2.0 request", ApiHandler{dbUtils, rpcHandler}})
+
+	// webhooks admin API
+	r.Handle("/v1/webhooks", MethodHandler{[]string{"GET", "POST"}, "list or create webhooks", ApiHandler{dbUtils, webhooksHandler}})
+	r.Handle("/v1/webhooks/{id:[0-9]+}", MethodHandler{[]string{"GET", "PATCH", "DELETE"}, "return, update or delete a webhook", ApiHandler{dbUtils, webhookHandler}})
+	r.Handle("/v1/webhooks/{id:[0-9]+}/ping", MethodHandler{[]string{"POST"}, "send a ping event to a webhook", ApiHandler{dbUtils, webhookPingHandler}})
+	r.Handle("/v1/webhooks/{id:[0-9]+}/deliveries", MethodHandler{[]string{"GET"}, "return the delivery log of a webhook", ApiHandler{dbUtils, webhookDeliveriesHandler}})
+	r.Handle("/v1/webhooks/{id:[0-9]+}/deliveries/{delivery_id:[0-9]+}", MethodHandler{[]string{"GET", "POST"}, "return or redeliver a webhook delivery", ApiHandler{dbUtils, webhookDeliveryHandler}})
 
 	// not found handler
 	r.NotFoundHandler = ApiHandler{Handler: notFoundHandler}
-	// server listener
-	http.Handle("/", r)
+	// gRPC server listener
+	var grpcServer *http.Server
+	if GRPC_PORT != "" {
+		grpcServer = newGRPCHTTPServer(":"+GRPC_PORT, NewGRPCServer(dbUtils))
+		go func() {
+			log.Printf("gRPC listening on %s", grpcServer.Addr)
+			if err := grpcServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// RFC 865 Quote of the Day server
+	if QOTD_ENABLED != "" {
+		go func() {
+			log.Fatal(serveQotd(QOTD_PORT, dbUtils))
+		}()
+	}
+
+	// DNS TXT server
+	if DNS_ZONE != "" {
+		go func() {
+			log.Fatal(serveDNS(DNS_PORT, DNS_ZONE, dbUtils))
+		}()
+	}
+
+	// RFC 1436 Gopher server
+	if GOPHER_ENABLED != "" {
+		go func() {
+			log.Fatal(serveGopher(GOPHER_PORT, dbUtils))
+		}()
+	}
+
+	// RFC 1288 Finger server
+	if FINGER_ENABLED != "" {
+		go func() {
+			log.Fatal(serveFinger(FINGER_PORT, dbUtils))
+		}()
+	}
+
+	// database changes listener
+	go listenChanges(DATABASE_URL, changes)
+	// webhook deliveries
+	if WEBHOOKS_DISABLED == "" {
+		webhookWorker = newWebhookDispatcher(dbUtils)
+		go webhookWorker.run()
+	}
+
+	// server listener, version negotiation happen before routing
+	http.Handle("/", VersionHandler{r})
+	server := &http.Server{Addr: ":" + PORT}
+	// closed when in-flight requests, streams of both servers and WebSocket
+	// connections are done, or after shutdownTimeout
+	done := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Println("Shutting down ...")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		var servers sync.WaitGroup
+		// long-lived handlers (streams) are not tracked by Shutdown, the gRPC
+		// server is shut down with the HTTP server
+		server.RegisterOnShutdown(func() {
+			close(shuttingDown)
+		})
+		if grpcServer != nil {
+			servers.Add(1)
+			server.RegisterOnShutdown(func() {
+				defer servers.Done()
+				if err := grpcServer.Shutdown(ctx); err != nil {
+					log.Printf("gRPC Shutdown: %s", err)
+				}
+			})
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: %s", err)
+		}
+		// hijacked WebSocket connections are closed by their handler
+		if err := wsConns.shutdown(ctx); err != nil {
+			log.Printf("WebSocket shutdown: %s", err)
+		}
+		servers.Wait()
+		close(done)
+	}()
 	log.Printf("Listening on :%s", PORT)
-	log.Fatal(http.ListenAndServe(":"+PORT, nil))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	// ListenAndServe return as soon as Shutdown start
+	<-done
 }
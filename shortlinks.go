@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	shortCodeLength      = 8
+	shortLinkMaxBodySize = 1 << 12
+	base62Alphabet       = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// ShortLink is a short link to a quote, or to a random quote with optional
+// tag and author filters
+type ShortLink struct {
+	Code          string     `json:"code"`
+	ShortUrl      string     `json:"short_url"`
+	QuoteId       int        `json:"quote_id,omitempty"`
+	Tag           string     `json:"tag,omitempty"`
+	Author        string     `json:"author,omitempty"`
+	Clicks        int        `json:"clicks"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastClickedAt *time.Time `json:"last_clicked_at,omitempty"`
+}
+
+// shortCode derive the code of a link target, it never change so links are
+// stable across restarts and databases
+func shortCode(link *ShortLink) string {
+	var target string
+	if link.QuoteId != 0 {
+		target = "quote\x00" + strconv.Itoa(link.QuoteId)
+	} else {
+		target = "random\x00" + link.Tag + "\x00" + link.Author
+	}
+	h := sha256.Sum256([]byte(target))
+	n := new(big.Int).SetBytes(h[:])
+	base := big.NewInt(int64(len(base62Alphabet)))
+	mod := new(big.Int)
+	code := make([]byte, shortCodeLength)
+	for i := range code {
+		n.DivMod(n, base, mod)
+		code[i] = base62Alphabet[mod.Int64()]
+	}
+	return string(code)
+}
+
+// sameTarget check two links have the same target
+func (link *ShortLink) sameTarget(other *ShortLink) bool {
+	return link.QuoteId == other.QuoteId && link.Tag == other.Tag && link.Author == other.Author
+}
+
+// shortLinkRequest define structure of create requests
+type shortLinkRequest struct {
+	QuoteId int    `json:"quote_id"`
+	Tag     string `json:"tag"`
+	Author  string `json:"author"`
+}
+
+// /v1/links endpoint. create the short link of a quote or of a filtered
+// random quote. Creating a link twice return the same link
+func shortLinksHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	req := &shortLinkRequest{}
+	decoder := json.NewDecoder(io.LimitReader(r.Body, shortLinkMaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		return invalidParameter("shortLinksHandler.Decode", errors.New("invalid JSON body: "+err.Error()))
+	}
+	if req.QuoteId != 0 && (req.Tag != "" || req.Author != "") {
+		return invalidParameter("shortLinksHandler.filters", errors.New("quote_id can't be combined with tag or author"))
+	}
+	if req.QuoteId < 0 {
+		return invalidParameter("shortLinksHandler.quote_id", errors.New("quote_id must be a positive integer"))
+	}
+	if req.QuoteId != 0 {
+		if _, err := dbUtils.QuoteById(req.QuoteId); err != nil {
+			return databaseError("shortLinksHandler.QuoteById", err)
+		}
+	}
+	if req.Tag != "" {
+		if _, err := dbUtils.TagByLabel(req.Tag); err != nil {
+			return databaseError("shortLinksHandler.TagByLabel", err)
+		}
+	}
+	if req.Author != "" {
+		if _, err := dbUtils.AuthorByTwitterUsername(req.Author); err != nil {
+			return databaseError("shortLinksHandler.AuthorByTwitterUsername", err)
+		}
+	}
+
+	link := &ShortLink{QuoteId: req.QuoteId, Tag: req.Tag, Author: req.Author}
+	link.Code = shortCode(link)
+	created, err := dbUtils.CreateShortLink(link)
+	if err != nil {
+		return databaseError("shortLinksHandler.CreateShortLink", err)
+	}
+	if !created.sameTarget(link) {
+		return &apiError{
+			"shortLinksHandler.sameTarget",
+			errors.New("short code " + link.Code + " collision"),
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+	created.ShortUrl = baseURL(r) + "/s/" + created.Code
+	w.Header().Set("Location", "/v1/links/"+created.Code)
+	w.WriteHeader(http.StatusCreated)
+	return writeJSON(w, r, created, "shortLinksHandler.resp")
+}
+
+// /v1/links/{code} endpoint. return a short link and its clicks
+func shortLinkHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	link, err := dbUtils.ShortLinkByCode(mux.Vars(r)["code"])
+	if err != nil {
+		return databaseError("shortLinkHandler.ShortLinkByCode", err)
+	}
+	link.ShortUrl = baseURL(r) + "/s/" + link.Code
+	return writeJSON(w, r, link, "shortLinkHandler.resp")
+}
+
+// /s/{code} endpoint. count a click and redirect to the quote page, random
+// links redirect to a new random quote on every click. UTM parameters are
+// passed to the target. HEAD requests of link checkers and unfurlers are
+// not counted
+func shortRedirectHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	var link *ShortLink
+	var err error
+	if r.Method == http.MethodGet {
+		link, err = dbUtils.ClickShortLink(mux.Vars(r)["code"])
+	} else {
+		link, err = dbUtils.ShortLinkByCode(mux.Vars(r)["code"])
+	}
+	if err != nil {
+		return databaseError("shortRedirectHandler.ClickShortLink", err)
+	}
+	quote_id := link.QuoteId
+	if quote_id == 0 {
+		quote, err := dbUtils.RandomQuoteFiltered(link.Tag, link.Author)
+		if err != nil {
+			return databaseError("shortRedirectHandler.RandomQuoteFiltered", err)
+		}
+		quote_id = quote.Id
+	}
+
+	target := baseURL(r) + "/q/" + strconv.Itoa(quote_id)
+	utm := url.Values{}
+	for name, values := range r.URL.Query() {
+		if strings.HasPrefix(strings.ToLower(name), "utm_") {
+			utm[name] = values
+		}
+	}
+	if len(utm) > 0 {
+		target += "?" + utm.Encode()
+	}
+	// not cached, so every click is counted
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", target)
+	w.WriteHeader(http.StatusFound)
+	return nil
+}
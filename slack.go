@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// signing secret of the Slack app, Slack endpoints are disabled if empty
+	SLACK_SIGNING_SECRET = os.Getenv("SLACK_SIGNING_SECRET")
+)
+
+const (
+	slackMaxBodySize = 1 << 20
+	// requests signed longer ago are rejected to prevent replay
+	slackSignatureTolerance = 5 * time.Minute
+	slackTimeout            = 5 * time.Second
+	slackAnotherAction      = "wisdom_another"
+	slackUsage              = "Usage: `/wisdom`, `/wisdom <tag>`, `/wisdom @<twitter_username>` or `/wisdom <tag> @<twitter_username>`"
+)
+
+// client posting interactive responses to Slack response URLs
+var slackClient = &http.Client{Timeout: slackTimeout}
+
+// slackText is a Block Kit text object
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackButton is a Block Kit button element
+type slackButton struct {
+	Type     string     `json:"type"`
+	Text     *slackText `json:"text,omitempty"`
+	ActionId string     `json:"action_id,omitempty"`
+	Value    string     `json:"value,omitempty"`
+}
+
+// slackBlock is a Block Kit layout block
+type slackBlock struct {
+	Type     string        `json:"type"`
+	Text     *slackText    `json:"text,omitempty"`
+	ImageUrl string        `json:"image_url,omitempty"`
+	AltText  string        `json:"alt_text,omitempty"`
+	Elements []interface{} `json:"elements,omitempty"`
+}
+
+// slackMessage define structure of slash command and interactive responses
+type slackMessage struct {
+	ResponseType    string        `json:"response_type,omitempty"`
+	ReplaceOriginal bool          `json:"replace_original,omitempty"`
+	Text            string        `json:"text"`
+	Blocks          []*slackBlock `json:"blocks,omitempty"`
+}
+
+// slackInteraction define the fields of interactive payloads we use
+type slackInteraction struct {
+	Type        string `json:"type"`
+	ResponseUrl string `json:"response_url"`
+	Actions     []struct {
+		ActionId string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// verifySlackSignature check `X-Slack-Signature: v0=<hex HMAC-SHA256>`
+// header value. HMAC is computed over `v0:<timestamp>:<body>`, timestamp
+// must be within slackSignatureTolerance of now
+func verifySlackSignature(secret, timestamp, signature string, body []byte, now time.Time) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed X-Slack-Request-Timestamp header")
+	}
+	signed := time.Unix(unix, 0)
+	if now.Sub(signed) > slackSignatureTolerance || signed.Sub(now) > slackSignatureTolerance {
+		return errors.New("request timestamp out of tolerance")
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(signature, "v0="))
+	if err != nil || !strings.HasPrefix(signature, "v0=") {
+		return errors.New("malformed X-Slack-Signature header")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	if !hmac.Equal(decoded, mac.Sum(nil)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// readSlackForm read and verify the form-encoded body of a Slack request
+func readSlackForm(r *http.Request, tag string) (url.Values, *apiError) {
+	if SLACK_SIGNING_SECRET == "" {
+		return nil, &apiError{
+			tag + ".readSlackForm",
+			errors.New("SLACK_SIGNING_SECRET is not set"),
+			"Slack integration is disabled",
+			http.StatusForbidden,
+			errCodeForbidden,
+		}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, slackMaxBodySize))
+	if err != nil {
+		return nil, invalidParameter(tag+".ReadAll", errors.New("can't read body"))
+	}
+	err = verifySlackSignature(SLACK_SIGNING_SECRET, r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature"), body, time.Now())
+	if err != nil {
+		return nil, &apiError{
+			tag + ".verifySlackSignature",
+			err,
+			"Invalid Slack signature",
+			http.StatusUnauthorized,
+			errCodeUnauthorized,
+		}
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, invalidParameter(tag+".ParseQuery", errors.New("invalid form body"))
+	}
+	return form, nil
+}
+
+// parseSlackCommand return the tag and author filters of the command text,
+// authors start with @
+func parseSlackCommand(text string) (tag_label, twitter_username string, err error) {
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "@") {
+			if twitter_username != "" {
+				return "", "", errors.New("more than one author")
+			}
+			twitter_username = strings.TrimPrefix(field, "@")
+		} else {
+			if tag_label != "" {
+				return "", "", errors.New("more than one tag")
+			}
+			tag_label = field
+		}
+	}
+	return tag_label, twitter_username, nil
+}
+
+// slackEscape escape control characters of mrkdwn text
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+// slackEphemeral return a message only visible to the user
+func slackEphemeral(text string) *slackMessage {
+	return &slackMessage{ResponseType: "ephemeral", Text: text}
+}
+
+// slackQuoteMessage return the Block Kit message of a quote, with its card
+// and a button to get another quote with the same filters
+func slackQuoteMessage(base string, quote *Quote, tag_label, twitter_username string) *slackMessage {
+	id := strconv.Itoa(quote.Id)
+	author := quote.Author.Name
+	if quote.Author.Company != "" {
+		author += ", " + quote.Author.Company
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(quote.Content), "\n") {
+		lines = append(lines, ">"+slackEscape(line))
+	}
+	filters := url.Values{}
+	if tag_label != "" {
+		filters.Set("tag", tag_label)
+	}
+	if twitter_username != "" {
+		filters.Set("author", twitter_username)
+	}
+	return &slackMessage{
+		ResponseType: "in_channel",
+		Text:         slackEscape("“" + quote.Content + "” — " + author),
+		Blocks: []*slackBlock{
+			{Type: "section", Text: &slackText{"mrkdwn", strings.Join(lines, "\n") + "\n— *" + slackEscape(author) + "*"}},
+			{Type: "image", ImageUrl: base + "/v1/quotes/" + id + "/card.png", AltText: "“" + quote.Content + "” — " + quote.Author.Name},
+			{Type: "context", Elements: []interface{}{
+				&slackText{"mrkdwn", "<" + base + "/q/" + id + "|wisdom #" + id + ">"},
+			}},
+			{Type: "actions", Elements: []interface{}{
+				&slackButton{"button", &slackText{"plain_text", "Another one"}, slackAnotherAction, filters.Encode()},
+			}},
+		},
+	}
+}
+
+// slackQuote return the message of a random quote with filters. Errors of
+// the user are responded as ephemeral messages
+func slackQuote(base string, dbUtils *DatabaseUtils, tag_label, twitter_username string) (*slackMessage, error) {
+	quote, err := dbUtils.RandomQuoteFiltered(tag_label, twitter_username)
+	switch err {
+	case nil:
+		return slackQuoteMessage(base, quote, tag_label, twitter_username), nil
+	case errQuoteNotFound, errAuthorNotFound, errTagNotFound:
+		return slackEphemeral("No quote found. " + slackUsage), nil
+	}
+	return nil, err
+}
+
+// /slack/commands endpoint. respond to the `/wisdom [tag] [@author]` slash
+// command with a random quote
+func slackCommandHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	form, apiErr := readSlackForm(r, "slackCommandHandler")
+	if apiErr != nil {
+		return apiErr
+	}
+	var message *slackMessage
+	tag_label, twitter_username, err := parseSlackCommand(form.Get("text"))
+	if err != nil || form.Get("text") == "help" {
+		message = slackEphemeral(slackUsage)
+	} else if message, err = slackQuote(baseURL(r), dbUtils, tag_label, twitter_username); err != nil {
+		return databaseError("slackCommandHandler.RandomQuoteFiltered", err)
+	}
+	return writeJSON(w, r, message, "slackCommandHandler.resp")
+}
+
+// validSlackResponseURL check response URL is a Slack URL, so requests
+// can't be sent elsewhere
+func validSlackResponseURL(response_url string) bool {
+	u, err := url.Parse(response_url)
+	return err == nil && u.Scheme == "https" && u.Host == "hooks.slack.com"
+}
+
+// postSlackResponse post message to a Slack response URL
+func postSlackResponse(response_url string, message *slackMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	resp, err := slackClient.Post(response_url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("response URL returned " + resp.Status)
+	}
+	return nil
+}
+
+// /slack/interactions endpoint. replace the message with another quote
+// when the "Another one" button is clicked. Slack expect an empty response
+// within 3 seconds, the new message is posted to the response URL
+func slackInteractionHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	form, apiErr := readSlackForm(r, "slackInteractionHandler")
+	if apiErr != nil {
+		return apiErr
+	}
+	interaction := &slackInteraction{}
+	if err := json.Unmarshal([]byte(form.Get("payload")), interaction); err != nil {
+		return invalidParameter("slackInteractionHandler.Unmarshal", errors.New("invalid payload: "+err.Error()))
+	}
+	if interaction.Type != "block_actions" || len(interaction.Actions) == 0 || interaction.Actions[0].ActionId != slackAnotherAction {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	if !validSlackResponseURL(interaction.ResponseUrl) {
+		return invalidParameter("slackInteractionHandler.response_url", errors.New("invalid response_url"))
+	}
+	filters, _ := url.ParseQuery(interaction.Actions[0].Value)
+	message, err := slackQuote(baseURL(r), dbUtils, filters.Get("tag"), filters.Get("author"))
+	if err != nil {
+		return databaseError("slackInteractionHandler.RandomQuoteFiltered", err)
+	}
+	message.ReplaceOriginal = message.ResponseType == "in_channel"
+	go func() {
+		if err := postSlackResponse(interaction.ResponseUrl, message); err != nil {
+			log.Printf("slackInteractionHandler.postSlackResponse: %s", err)
+		}
+	}()
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signing secret and timestamp the recorded signatures were computed with
+const (
+	testSlackSecret    = "8f742231b10e8888abcd99yyyzzz85a5"
+	testSlackTimestamp = "1531420618"
+)
+
+// readSlackPayload read a recorded payload of data/slack without its
+// trailing newline
+func readSlackPayload(t *testing.T, name string) []byte {
+	body, err := ioutil.ReadFile("data/slack/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.TrimRight(body, "\r\n")
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	signatures := map[string]string{
+		"command.form":     "v0=84e0ce327121a3bbf8f03f383d7da97952569c779b6bccb3d549404e265b9882",
+		"interaction.form": "v0=a57ad49958255875cc5ff196753ba6e3a81dcf16a84d23b61334037d9b868f20",
+	}
+	signed := time.Unix(1531420618, 0)
+	for name, signature := range signatures {
+		body := readSlackPayload(t, name)
+		tests := []struct {
+			desc      string
+			secret    string
+			timestamp string
+			signature string
+			body      []byte
+			now       time.Time
+			ok        bool
+		}{
+			{"valid", testSlackSecret, testSlackTimestamp, signature, body, signed, true},
+			{"valid within tolerance", testSlackSecret, testSlackTimestamp, signature, body, signed.Add(slackSignatureTolerance), true},
+			{"wrong secret", testSlackSecret + "x", testSlackTimestamp, signature, body, signed, false},
+			{"modified body", testSlackSecret, testSlackTimestamp, signature, append(body, '&'), signed, false},
+			{"other timestamp", testSlackSecret, "1531420619", signature, body, signed, false},
+			{"too old", testSlackSecret, testSlackTimestamp, signature, body, signed.Add(slackSignatureTolerance + time.Second), false},
+			{"in the future", testSlackSecret, testSlackTimestamp, signature, body, signed.Add(-slackSignatureTolerance - time.Second), false},
+			{"malformed timestamp", testSlackSecret, "yesterday", signature, body, signed, false},
+			{"missing version", testSlackSecret, testSlackTimestamp, strings.TrimPrefix(signature, "v0="), body, signed, false},
+			{"not hex", testSlackSecret, testSlackTimestamp, "v0=zz", body, signed, false},
+			{"empty", testSlackSecret, testSlackTimestamp, "", body, signed, false},
+		}
+		for _, test := range tests {
+			err := verifySlackSignature(test.secret, test.timestamp, test.signature, test.body, test.now)
+			if (err == nil) != test.ok {
+				t.Errorf("%s %s: verifySlackSignature() = %v, want ok %v", name, test.desc, err, test.ok)
+			}
+		}
+	}
+}
+
+func TestParseSlackCommand(t *testing.T) {
+	form, err := url.ParseQuery(string(readSlackPayload(t, "command.form")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text   string
+		tag    string
+		author string
+		ok     bool
+	}{
+		{form.Get("text"), "startup", "paulg", true},
+		{"", "", "", true},
+		{"startup", "startup", "", true},
+		{"@paulg", "", "paulg", true},
+		{"  @paulg   startup ", "startup", "paulg", true},
+		{"startup programming", "", "", false},
+		{"@paulg @pg", "", "", false},
+	}
+	for _, test := range tests {
+		tag, author, err := parseSlackCommand(test.text)
+		if (err == nil) != test.ok || tag != test.tag || author != test.author {
+			t.Errorf("parseSlackCommand(%q) = %q, %q, %v, want %q, %q, ok %v", test.text, tag, author, err, test.tag, test.author, test.ok)
+		}
+	}
+}
+
+func TestSlackInteractionPayload(t *testing.T) {
+	form, err := url.ParseQuery(string(readSlackPayload(t, "interaction.form")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	interaction := &slackInteraction{}
+	if err := json.Unmarshal([]byte(form.Get("payload")), interaction); err != nil {
+		t.Fatal(err)
+	}
+	if interaction.Type != "block_actions" || len(interaction.Actions) != 1 || interaction.Actions[0].ActionId != slackAnotherAction {
+		t.Fatalf("unexpected interaction %+v", interaction)
+	}
+	if !validSlackResponseURL(interaction.ResponseUrl) {
+		t.Errorf("validSlackResponseURL(%q) = false", interaction.ResponseUrl)
+	}
+	filters, err := url.ParseQuery(interaction.Actions[0].Value)
+	if err != nil || filters.Get("tag") != "startup" || filters.Get("author") != "paulg" {
+		t.Errorf("button value %q, want tag startup and author paulg", interaction.Actions[0].Value)
+	}
+
+	for _, response_url := range []string{
+		"http://hooks.slack.com/actions/T0001/1234/5678",
+		"https://hooks.slack.com.example.com/actions",
+		"https://example.com/hooks.slack.com",
+	} {
+		if validSlackResponseURL(response_url) {
+			t.Errorf("validSlackResponseURL(%q) = true", response_url)
+		}
+	}
+}
+
+func TestSlackQuoteMessage(t *testing.T) {
+	quote := &Quote{
+		Id:      42,
+		Author:  Author{Name: "Ben & Jerry", Company: "<Ice Cream>"},
+		Content: "Line one > line two\nif a < b && c",
+	}
+	message := slackQuoteMessage("https://wisdom.example.com", quote, "startup", "paulg")
+	// HTML characters are left unescaped to compare the text Slack decode
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(message); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"response_type":"in_channel","text":"“Line one &gt; line two\nif a &lt; b &amp;&amp; c” — Ben &amp; Jerry, &lt;Ice Cream&gt;",` +
+		`"blocks":[` +
+		`{"type":"section","text":{"type":"mrkdwn","text":">Line one &gt; line two\n>if a &lt; b &amp;&amp; c\n— *Ben &amp; Jerry, &lt;Ice Cream&gt;*"}},` +
+		`{"type":"image","image_url":"https://wisdom.example.com/v1/quotes/42/card.png","alt_text":"“Line one > line two\nif a < b && c” — Ben & Jerry"},` +
+		`{"type":"context","elements":[{"type":"mrkdwn","text":"<https://wisdom.example.com/q/42|wisdom #42>"}]},` +
+		`{"type":"actions","elements":[{"type":"button","text":{"type":"plain_text","text":"Another one"},"action_id":"wisdom_another","value":"author=paulg&tag=startup"}]}` +
+		`]}` + "\n"
+	if data.String() != want {
+		t.Errorf("slackQuoteMessage() =\n%s\nwant\n%s", data.String(), want)
+	}
+}
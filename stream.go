@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	// default seconds between random quotes of /v1/stream
+	STREAM_INTERVAL = os.Getenv("STREAM_INTERVAL")
+)
+
+const (
+	streamDefaultInterval = 60
+	streamMinInterval     = 5
+	streamMaxInterval     = 3600
+	streamHeartbeat       = 15 * time.Second
+	// milliseconds client wait before reconnecting
+	streamRetry = 5000
+)
+
+// quoteMatches check quote have tag label and is by author with twitter
+// username. Empty filter match every quote
+func quoteMatches(quote *Quote, tag_label, twitter_username string) bool {
+	if twitter_username != "" && quote.Author.Twitter != twitter_username {
+		return false
+	}
+	if tag_label == "" {
+		return true
+	}
+	for _, tag := range quote.Tags {
+		if tag.Label == tag_label {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteFilters read and check `tag` and `author` query parameters
+func quoteFilters(r *http.Request, dbUtils *DatabaseUtils, tag string) (string, string, *apiError) {
+	query := r.URL.Query()
+	tag_label := query.Get("tag")
+	twitter_username := query.Get("author")
+	if tag_label != "" {
+		if _, err := dbUtils.TagByLabel(tag_label); err != nil {
+			return "", "", databaseError(tag+".TagByLabel", err)
+		}
+	}
+	if twitter_username != "" {
+		if _, err := dbUtils.AuthorByTwitterUsername(twitter_username); err != nil {
+			return "", "", databaseError(tag+".AuthorByTwitterUsername", err)
+		}
+	}
+	return tag_label, twitter_username, nil
+}
+
+// streamInterval read `interval` query parameter, STREAM_INTERVAL is used
+// by default
+func streamInterval(r *http.Request) (time.Duration, error) {
+	interval, err := strconv.Atoi(STREAM_INTERVAL)
+	if err != nil {
+		interval = streamDefaultInterval
+	}
+	if value := r.URL.Query().Get("interval"); value != "" {
+		interval, err = strconv.Atoi(value)
+		if err != nil || interval < streamMinInterval || interval > streamMaxInterval {
+			return 0, fmt.Errorf("interval must be an integer between %d and %d", streamMinInterval, streamMaxInterval)
+		}
+	}
+	return time.Duration(interval) * time.Second, nil
+}
+
+// lastEventId read Last-Event-ID header, or `last_event_id` query parameter
+// for clients that can't set headers
+func lastEventId(r *http.Request) int64 {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// sseWriter write server-sent events
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// event write an event, data is encoded as JSON. Only changes have an id,
+// other events have id 0 and keep the last event id of the client
+func (s *sseWriter) event(id int64, name string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		if _, err := fmt.Fprintf(s.w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, b)
+	s.flusher.Flush()
+	return err
+}
+
+// comment write a comment, used as heartbeat
+func (s *sseWriter) comment(text string) error {
+	_, err := fmt.Fprintf(s.w, ": %s\n\n", text)
+	s.flusher.Flush()
+	return err
+}
+
+// /v1/stream endpoint. push a random quote every interval and every change
+// of quotes as server-sent events
+func streamHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return &apiError{
+			"streamHandler.Flusher",
+			errors.New("streaming unsupported"),
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+	interval, err := streamInterval(r)
+	if err != nil {
+		return invalidParameter("streamHandler.streamInterval", err)
+	}
+	tag_label, twitter_username, apiErr := quoteFilters(r, dbUtils, "streamHandler")
+	if apiErr != nil {
+		return apiErr
+	}
+
+	events, missed := changes.subscribe(lastEventId(r))
+	defer changes.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "HEAD" {
+		return nil
+	}
+	sse := &sseWriter{w, flusher}
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetry); err != nil {
+		return nil
+	}
+
+	// sendChange send a change of quotes matching the filters
+	sendChange := func(event *changeEvent) error {
+		if event.Table != "quotes" {
+			return nil
+		}
+		if event.Action == "DELETE" {
+			return sse.event(event.Seq, "quote.deleted", map[string]int{"id": event.Id})
+		}
+		quote, err := dbUtils.QuoteById(event.Id)
+		if err != nil {
+			return nil
+		}
+		if !quoteMatches(quote, tag_label, twitter_username) {
+			return nil
+		}
+		name := "quote.updated"
+		if event.Action == "INSERT" {
+			name = "quote.created"
+		}
+		return sse.event(event.Seq, name, quote)
+	}
+
+	// sendRandom send a random quote matching the filters
+	sendRandom := func() error {
+		quote, err := dbUtils.RandomQuoteFiltered(tag_label, twitter_username)
+		if err != nil {
+			return sse.comment("no quote available")
+		}
+		return sse.event(0, "quote", quote)
+	}
+
+	// resume missed changes, otherwise start with a random quote
+	if len(missed) > 0 {
+		for _, event := range missed {
+			if err := sendChange(event); err != nil {
+				return nil
+			}
+		}
+	} else if err := sendRandom(); err != nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-shuttingDown:
+			sse.event(0, "shutdown", map[string]int{"retry": streamRetry})
+			return nil
+		case event := <-events:
+			err = sendChange(event)
+		case <-ticker.C:
+			err = sendRandom()
+		case <-heartbeat.C:
+			err = sse.comment("heartbeat")
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// tweet length, in weighted characters
+	tweetMaxLength = 280
+	// every URL is shortened by t.co to 23 characters
+	tweetURLLength = 23
+
+	textDefaultWidth = 80
+	textMinWidth     = 20
+	textMaxWidth     = 200
+)
+
+// text styles by name, with their content type
+var textStyles = map[string]string{
+	"tweet":    "text/plain; charset=utf-8",
+	"markdown": "text/markdown; charset=utf-8",
+	"html":     "text/html; charset=utf-8",
+	"plain":    "text/plain; charset=utf-8",
+}
+
+// tweetWeight return weight of a character in a tweet. Latin and
+// punctuation count 1, other characters (e.g. CJK and emoji) count 2, see
+// twitter-text configuration v3
+func tweetWeight(r rune) int {
+	switch {
+	case r <= 0x10ff, r >= 0x2000 && r <= 0x200d, r >= 0x2010 && r <= 0x201f, r >= 0x2032 && r <= 0x2037:
+		return 1
+	}
+	return 2
+}
+
+// tweetLength return weighted length of text without URLs
+func tweetLength(text string) int {
+	length := 0
+	for _, r := range text {
+		length += tweetWeight(r)
+	}
+	return length
+}
+
+// truncateTweet truncate text to at most max weighted characters. Text is
+// cut at the last word that fit and ended with an ellipsis
+func truncateTweet(text string, max int) string {
+	if tweetLength(text) <= max {
+		return text
+	}
+	max -= tweetWeight('…')
+	length := 0
+	cut, lastSpace := 0, -1
+	for i, r := range text {
+		if length+tweetWeight(r) > max {
+			break
+		}
+		if unicode.IsSpace(r) {
+			lastSpace = i
+		}
+		length += tweetWeight(r)
+		cut = i + len(string(r))
+	}
+	// don't cut a word, unless it's the only one
+	if lastSpace > 0 && cut < len(text) {
+		cut = lastSpace
+	}
+	return strings.TrimRightFunc(text[:cut], func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…"
+}
+
+// quoteTweet return a tweet of quote, with the author's twitter username
+// and link. The quote is truncated so the tweet is at most 280 characters
+func quoteTweet(quote *Quote, page_url string) string {
+	author := quote.Author.Name
+	if quote.Author.Twitter != "" {
+		author = "@" + quote.Author.Twitter
+	}
+	suffix := "” — " + author
+	available := tweetMaxLength - tweetLength("“"+suffix)
+	if page_url != "" {
+		available -= 1 + tweetURLLength
+	}
+	tweet := "“" + truncateTweet(strings.Join(strings.Fields(quote.Content), " "), available) + suffix
+	if page_url != "" {
+		tweet += " " + page_url
+	}
+	return tweet
+}
+
+// markdownEscaper escape characters with a meaning in Markdown
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+// markdownLine escape a line of text. Line starting like a list item are
+// escaped too
+func markdownLine(line string) string {
+	line = markdownEscaper.Replace(line)
+	switch {
+	case strings.HasPrefix(line, "-"), strings.HasPrefix(line, "+"), strings.HasPrefix(line, "="):
+		line = `\` + line
+	default:
+		// ordered list item, e.g. `1. ` or `1) `
+		i := 0
+		for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+			i++
+		}
+		if i > 0 && i < len(line) && (line[i] == '.' || line[i] == ')') {
+			line = line[:i] + `\` + line[i:]
+		}
+	}
+	return line
+}
+
+// quoteMarkdown return a Markdown blockquote of quote
+func quoteMarkdown(quote *Quote, page_url string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(quote.Content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			b.WriteString(">\n")
+			continue
+		}
+		b.WriteString("> " + markdownLine(line) + "\n")
+	}
+	author := markdownLine(quote.Author.Name)
+	if page_url != "" {
+		author = "[" + author + "](<" + page_url + ">)"
+	}
+	b.WriteString(">\n> — " + author)
+	if quote.Author.Company != "" {
+		b.WriteString(", " + markdownLine(quote.Author.Company))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// quoteHTML return an HTML blockquote of quote
+func quoteHTML(quote *Quote, page_url string) string {
+	var b strings.Builder
+	b.WriteString("<blockquote")
+	if page_url != "" {
+		b.WriteString(` cite="` + html.EscapeString(page_url) + `"`)
+	}
+	b.WriteString(">\n")
+	for _, paragraph := range strings.Split(strings.TrimSpace(quote.Content), "\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			b.WriteString("  <p>" + html.EscapeString(paragraph) + "</p>\n")
+		}
+	}
+	b.WriteString("  <footer>— <cite>" + html.EscapeString(quote.Author.Name) + "</cite>")
+	if quote.Author.Company != "" {
+		b.WriteString(", " + html.EscapeString(quote.Author.Company))
+	}
+	b.WriteString("</footer>\n</blockquote>\n")
+	return b.String()
+}
+
+// plainText remove control characters, so text can be printed on terminals
+func plainText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && (unicode.IsSpace(r) || unicode.IsControl(r)) {
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// quotePlain return quote wrapped to width columns, followed by the author
+func quotePlain(quote *Quote, width int) string {
+	var b strings.Builder
+	for _, paragraph := range strings.Split(strings.TrimSpace(plainText(quote.Content)), "\n") {
+		for _, line := range wrapWords(paragraph, width) {
+			b.WriteString(line + "\n")
+		}
+	}
+	author := plainText(quote.Author.Name)
+	if quote.Author.Company != "" {
+		author += ", " + plainText(quote.Author.Company)
+	}
+	b.WriteString("    — " + author + "\n")
+	return b.String()
+}
+
+// shareIntents are prebuilt share URLs of a quote
+type shareIntents struct {
+	Url      string `json:"url"`
+	Twitter  string `json:"twitter"`
+	Facebook string `json:"facebook"`
+	LinkedIn string `json:"linkedin"`
+	Email    string `json:"email"`
+}
+
+// mailtoEscape escape a mailto header value, spaces must be %20, see RFC
+// 6068
+func mailtoEscape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
+
+// newShareIntents return share URLs of the quote page
+func newShareIntents(r *http.Request, quote *Quote) *shareIntents {
+	page_url := baseURL(r) + "/q/" + strconv.Itoa(quote.Id)
+	return &shareIntents{
+		Url:      page_url,
+		Twitter:  "https://twitter.com/intent/tweet?text=" + url.QueryEscape(quoteTweet(quote, page_url)),
+		Facebook: "https://www.facebook.com/sharer/sharer.php?u=" + url.QueryEscape(page_url),
+		LinkedIn: "https://www.linkedin.com/sharing/share-offsite/?url=" + url.QueryEscape(page_url),
+		Email: "mailto:?subject=" + mailtoEscape("Quote by "+quote.Author.Name) +
+			"&body=" + mailtoEscape(quotePlain(quote, textDefaultWidth)+"\n"+page_url),
+	}
+}
+
+// addShareIntents add share URLs to quotes when the request has
+// `share=true`
+func addShareIntents(r *http.Request, tag string, quotes ...*Quote) *apiError {
+	value := r.URL.Query().Get("share")
+	if value == "" {
+		return nil
+	}
+	share, err := strconv.ParseBool(value)
+	if err != nil {
+		return invalidParameter(tag+".share", errors.New("share must be true or false"))
+	}
+	if share {
+		for _, quote := range quotes {
+			quote.Share = newShareIntents(r, quote)
+		}
+	}
+	return nil
+}
+
+// /v1/quotes/{id}/text endpoint. return quote as text ready to be shared in
+// tweet, markdown, html or plain style
+func quoteTextHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	query := r.URL.Query()
+	style := query.Get("style")
+	if style == "" {
+		style = "plain"
+	}
+	content_type, ok := textStyles[style]
+	if !ok {
+		return invalidParameter("quoteTextHandler.style", errors.New("style must be tweet, markdown, html or plain"))
+	}
+	width := textDefaultWidth
+	if value := query.Get("width"); value != "" {
+		var err error
+		width, err = strconv.Atoi(value)
+		if err != nil || width < textMinWidth || width > textMaxWidth {
+			return invalidParameter("quoteTextHandler.width", fmt.Errorf("width must be an integer between %d and %d", textMinWidth, textMaxWidth))
+		}
+	}
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	quote, err := dbUtils.QuoteById(id)
+	if err != nil {
+		return databaseError("quoteTextHandler.QuoteById", err)
+	}
+
+	page_url := baseURL(r) + "/q/" + strconv.Itoa(quote.Id)
+	var text string
+	switch style {
+	case "tweet":
+		text = quoteTweet(quote, page_url)
+	case "markdown":
+		text = quoteMarkdown(quote, page_url)
+	case "html":
+		text = quoteHTML(quote, page_url)
+		// snippet to be copied, never run anything when opened directly
+		w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	case "plain":
+		text = quotePlain(quote, width)
+	}
+	w.Header().Set("Content-Type", content_type)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(text))
+	return nil
+}
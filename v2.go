@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// envelope define structure of every v2 response
+type envelope struct {
+	Data       interface{} `json:"data"`
+	Pagination *pagination `json:"pagination,omitempty"`
+}
+
+// pagination define structure of v2 list metadata
+type pagination struct {
+	Page       int    `json:"page"`
+	PerPage    int    `json:"per_page"`
+	Total      int    `json:"total"`
+	TotalPages int    `json:"total_pages"`
+	Next       string `json:"next,omitempty"`
+	Prev       string `json:"prev,omitempty"`
+}
+
+// pageParams parse `page` and `per_page` query parameters
+func pageParams(r *http.Request) (page, perPage int, err error) {
+	query := r.URL.Query()
+	page, perPage = 1, defaultPerPage
+	if value := query.Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return 0, 0, errors.New("page must be a positive integer")
+		}
+	}
+	if value := query.Get("per_page"); value != "" {
+		perPage, err = strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, errors.New("per_page must be an integer between 1 and " + strconv.Itoa(maxPerPage))
+		}
+	}
+	return page, perPage, nil
+}
+
+// newPagination create pagination metadata with next & prev page links
+func newPagination(r *http.Request, page, perPage, total int) *pagination {
+	totalPages := (total + perPage - 1) / perPage
+	p := &pagination{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: totalPages,
+	}
+	link := func(page int) string {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("per_page", strconv.Itoa(perPage))
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		return u.String()
+	}
+	if page < totalPages {
+		p.Next = link(page + 1)
+	}
+	if page > 1 && page <= totalPages {
+		p.Prev = link(page - 1)
+	}
+	return p
+}
+
+// /v2/random endpoint. return a random quote
+func v2RandomHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	quote, err := dbUtils.RandomQuote()
+	if err != nil {
+		return databaseError("v2RandomHandler.RandomQuote", err)
+	}
+	if apiErr := addShareIntents(r, "v2RandomHandler", quote); apiErr != nil {
+		return apiErr
+	}
+	return writeJSON(w, r, &envelope{Data: quote}, "v2RandomHandler.resp")
+}
+
+// /v2/quotes endpoint. return a page of quotes
+func v2QuotesHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	page, perPage, err := pageParams(r)
+	if err != nil {
+		return invalidParameter("v2QuotesHandler.pageParams", err)
+	}
+	quotes, total, err := dbUtils.Quotes(perPage, (page-1)*perPage)
+	if err != nil {
+		return databaseError("v2QuotesHandler.Quotes", err)
+	}
+	if quotes == nil {
+		quotes = []*Quote{}
+	}
+	if apiErr := addShareIntents(r, "v2QuotesHandler", quotes...); apiErr != nil {
+		return apiErr
+	}
+	resp := &envelope{quotes, newPagination(r, page, perPage, total)}
+	return writeJSON(w, r, resp, "v2QuotesHandler.resp")
+}
+
+// /v2/quotes/{id} endpoint. return a quote
+func v2QuoteHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return invalidParameter("v2QuoteHandler.Atoi", errors.New("id must be an integer"))
+	}
+	quote, err := dbUtils.QuoteById(id)
+	if err != nil {
+		return databaseError("v2QuoteHandler.QuoteById", err)
+	}
+	w.Header().Set("Link", oembedLinks(r, baseURL(r)+r.URL.Path))
+	if apiErr := addShareIntents(r, "v2QuoteHandler", quote); apiErr != nil {
+		return apiErr
+	}
+	return writeJSON(w, r, &envelope{Data: quote}, "v2QuoteHandler.resp")
+}
+
+// /v2/authors endpoint. return a page of authors
+func v2AuthorsHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	page, perPage, err := pageParams(r)
+	if err != nil {
+		return invalidParameter("v2AuthorsHandler.pageParams", err)
+	}
+	authors, total, err := dbUtils.AuthorsPage(perPage, (page-1)*perPage)
+	if err != nil {
+		return databaseError("v2AuthorsHandler.AuthorsPage", err)
+	}
+	if authors == nil {
+		authors = []Author{}
+	}
+	resp := &envelope{authors, newPagination(r, page, perPage, total)}
+	return writeJSON(w, r, resp, "v2AuthorsHandler.resp")
+}
+
+// /v2/author/{twitter_username} endpoint. return a page of quotes by author
+func v2AuthorTwitterHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	page, perPage, err := pageParams(r)
+	if err != nil {
+		return invalidParameter("v2AuthorTwitterHandler.pageParams", err)
+	}
+	author, err := dbUtils.AuthorByTwitterUsername(mux.Vars(r)["twitter_username"])
+	if err != nil {
+		return databaseError("v2AuthorTwitterHandler.AuthorByTwitterUsername", err)
+	}
+	quotes, total, err := dbUtils.QuotesByAuthorIdPage(author.Id, perPage, (page-1)*perPage)
+	if err != nil {
+		return databaseError("v2AuthorTwitterHandler.QuotesByAuthorIdPage", err)
+	}
+	if quotes == nil {
+		quotes = []*Quote{}
+	}
+	if apiErr := addShareIntents(r, "v2AuthorTwitterHandler", quotes...); apiErr != nil {
+		return apiErr
+	}
+	resp := &envelope{quotes, newPagination(r, page, perPage, total)}
+	return writeJSON(w, r, resp, "v2AuthorTwitterHandler.resp")
+}
+
+// /v2/author/{twitter_username}/random endpoint. return a random quote by
+// author
+func v2AuthorTwitterRandomHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	quotes, apiErr := authorQuotes(r, dbUtils, "v2AuthorTwitterRandomHandler")
+	if apiErr != nil {
+		return apiErr
+	}
+	if len(quotes) == 0 {
+		return databaseError("v2AuthorTwitterRandomHandler.len(quotes)", errQuoteNotFound)
+	}
+	quote := quotes[rand.Intn(len(quotes))]
+	if apiErr := addShareIntents(r, "v2AuthorTwitterRandomHandler", quote); apiErr != nil {
+		return apiErr
+	}
+	return writeJSON(w, r, &envelope{Data: quote}, "v2AuthorTwitterRandomHandler.resp")
+}
+
+// /v2/tags endpoint. return a page of tags
+func v2TagsHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	page, perPage, err := pageParams(r)
+	if err != nil {
+		return invalidParameter("v2TagsHandler.pageParams", err)
+	}
+	tags, total, err := dbUtils.TagsPage(perPage, (page-1)*perPage)
+	if err != nil {
+		return databaseError("v2TagsHandler.TagsPage", err)
+	}
+	if tags == nil {
+		tags = []Tag{}
+	}
+	resp := &envelope{tags, newPagination(r, page, perPage, total)}
+	return writeJSON(w, r, resp, "v2TagsHandler.resp")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// date (YYYY-MM-DD) when v1 was deprecated, send Deprecation header on
+	// v1 responses if set
+	V1_DEPRECATION = os.Getenv("V1_DEPRECATION")
+	// date (YYYY-MM-DD) when v1 will be removed, send Sunset header on v1
+	// responses if set
+	V1_SUNSET = os.Getenv("V1_SUNSET")
+)
+
+// latest API version
+const latestApiVersion = 2
+
+// vendor media type prefix, e.g. application/vnd.wisdom.v2+json
+const vendorMediaTypePrefix = "application/vnd.wisdom.v"
+
+// apiVersion return API version of request based on the path prefix
+func apiVersion(r *http.Request) int {
+	for version := latestApiVersion; version > 1; version-- {
+		if strings.HasPrefix(r.URL.Path, "/v"+strconv.Itoa(version)+"/") {
+			return version
+		}
+	}
+	return 1
+}
+
+// acceptVersion return API version asked in Accept header, 0 if the client
+// doesn't ask for a specific version
+func acceptVersion(r *http.Request) int {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil || !strings.HasPrefix(mediaType, vendorMediaTypePrefix) {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(mediaType, vendorMediaTypePrefix), "+json"))
+		if err == nil && version >= 1 && version <= latestApiVersion {
+			return version
+		}
+	}
+	return 0
+}
+
+// VersionHandler route request to the API version asked in Accept header.
+// `GET /v1/random` with `Accept: application/vnd.wisdom.v2+json` is served
+// by `/v2/random`. Endpoints without the asked version, e.g. `/v1/qotd.ics`,
+// are served by the version of the path
+type VersionHandler struct {
+	Router *mux.Router
+}
+
+func (v VersionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+
+	version := acceptVersion(r)
+	if version != 0 {
+		current := "/v" + strconv.Itoa(apiVersion(r)) + "/"
+		if strings.HasPrefix(r.URL.Path, current) {
+			rewritten := *r
+			u := *r.URL
+			u.Path = "/v" + strconv.Itoa(version) + "/" + strings.TrimPrefix(r.URL.Path, current)
+			rewritten.URL = &u
+			if v.Router.Match(&rewritten, &mux.RouteMatch{}) {
+				r = &rewritten
+			}
+		}
+	}
+	v.Router.ServeHTTP(w, r)
+}
+
+// deprecationConfig represent deprecation policy of v1
+type deprecationConfig struct {
+	Deprecation time.Time
+	Sunset      time.Time
+}
+
+// deprecation is v1 deprecation policy loaded from environment variables
+var deprecation = newDeprecationConfig(V1_DEPRECATION, V1_SUNSET)
+
+// newDeprecationConfig parse deprecation and sunset dates, invalid dates
+// are ignored
+func newDeprecationConfig(deprecationDate, sunsetDate string) *deprecationConfig {
+	config := &deprecationConfig{}
+	if date, err := time.Parse("2006-01-02", deprecationDate); err == nil {
+		config.Deprecation = date
+	}
+	if date, err := time.Parse("2006-01-02", sunsetDate); err == nil {
+		config.Sunset = date
+	}
+	return config
+}
+
+// addHeaders add Deprecation (RFC 9745), Sunset (RFC 8594) and link to the
+// successor version
+func (d *deprecationConfig) addHeaders(w http.ResponseWriter, r *http.Request) {
+	if d.Deprecation.IsZero() && d.Sunset.IsZero() {
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v1/") {
+		return
+	}
+	if !d.Deprecation.IsZero() {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.Deprecation.Unix(), 10))
+	}
+	if !d.Sunset.IsZero() {
+		w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	successor := "/v" + strconv.Itoa(latestApiVersion) + "/" + strings.TrimPrefix(r.URL.Path, "/v1/")
+	w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// bearer token required by admin endpoints, admin endpoints are disabled
+	// when empty
+	ADMIN_TOKEN = os.Getenv("ADMIN_TOKEN")
+	// disable webhook deliveries of this instance
+	WEBHOOKS_DISABLED = os.Getenv("WEBHOOKS_DISABLED")
+	// number of attempts before a delivery is dead-lettered
+	WEBHOOK_MAX_ATTEMPTS = os.Getenv("WEBHOOK_MAX_ATTEMPTS")
+)
+
+const (
+	webhookDefaultMaxAttempts = 8
+	// first retry delay, doubled on every attempt up to webhookMaxBackoff
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+	webhookTimeout     = 10 * time.Second
+	// claimed deliveries are skipped by other instances during the lease
+	webhookLease        = time.Minute
+	webhookPollInterval = 5 * time.Second
+	webhookBatchSize    = 10
+	webhookMaxBodySize  = 1 << 16
+	// response body or error kept in delivery log
+	webhookMaxErrorSize = 512
+	// signature header, `t=<unix timestamp>,v1=<hex HMAC-SHA256>`
+	webhookSignatureHeader = "X-Wisdom-Signature"
+)
+
+// events a webhook can subscribe to, `*` and `table.*` are wildcards
+var webhookEvents = map[string]bool{
+	"quotes.created": true, "quotes.updated": true, "quotes.deleted": true,
+	"authors.created": true, "authors.updated": true, "authors.deleted": true,
+	"tags.created": true, "tags.updated": true, "tags.deleted": true,
+}
+
+// Webhook is a subscription to corpus changes. Secret is only responded
+// when the webhook is created
+type Webhook struct {
+	Id        int       `json:"id"`
+	Url       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is an entry of the delivery log
+type WebhookDelivery struct {
+	Id            int             `json:"id"`
+	WebhookId     int             `json:"webhook_id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// webhookAttempt is a claimed delivery with its webhook url and secret
+type webhookAttempt struct {
+	DeliveryId int
+	Event      string
+	Payload    []byte
+	Attempts   int
+	Url        string
+	Secret     string
+}
+
+// webhookEvent is a change queued in webhook_events, Data is the snapshot
+// of the changed object taken by the trigger
+type webhookEvent struct {
+	Id        int64
+	Change    changeEvent
+	Data      json.RawMessage
+	CreatedAt time.Time
+}
+
+// webhookPayload is the body POSTed to webhooks
+type webhookPayload struct {
+	Event     string      `json:"event"`
+	Id        int         `json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// subscribed check webhook subscribed to event
+func (webhook *Webhook) subscribed(event string) bool {
+	table := strings.SplitN(event, ".", 2)[0]
+	for _, e := range webhook.Events {
+		if e == "*" || e == event || e == table+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+// validWebhookEvent check event name or wildcard
+func validWebhookEvent(event string) bool {
+	if event == "*" || webhookEvents[event] {
+		return true
+	}
+	table := strings.TrimSuffix(event, ".*")
+	return table != event && webhookEvents[table+".created"]
+}
+
+// signWebhook return signature header value of body sent at timestamp
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// webhookBackoff return delay before retrying after attempts failed
+// attempts, with up to 10% jitter
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookMaxBackoff
+	if attempts < 20 {
+		backoff = webhookBaseBackoff << uint(attempts-1)
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+	return backoff + time.Duration(mathrand.Int63n(int64(backoff)/10+1))
+}
+
+func webhookMaxAttempts() int {
+	max, err := strconv.Atoi(WEBHOOK_MAX_ATTEMPTS)
+	if err != nil || max <= 0 {
+		return webhookDefaultMaxAttempts
+	}
+	return max
+}
+
+// webhookDispatcher queue corpus changes for subscribed webhooks and
+// deliver them
+type webhookDispatcher struct {
+	DBUtils *DatabaseUtils
+	Client  *http.Client
+	wake    chan struct{}
+}
+
+// webhookWorker is the dispatcher of this instance, nil when webhooks are
+// disabled
+var webhookWorker *webhookDispatcher
+
+func newWebhookDispatcher(dbUtils *DatabaseUtils) *webhookDispatcher {
+	return &webhookDispatcher{
+		DBUtils: dbUtils,
+		Client:  &http.Client{Timeout: webhookTimeout},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// run queue changes and deliver due deliveries until shutdown. Changes are
+// queued in the database by triggers, notifications only wake up the
+// delivery loop so a missed notification delay the delivery to the next
+// poll
+func (d *webhookDispatcher) run() {
+	events, _ := changes.subscribe(changes.lastSeq())
+	defer changes.unsubscribe(events)
+	go d.deliverLoop()
+	for {
+		select {
+		case <-events:
+			d.notify()
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// notify wake up delivery loop
+func (d *webhookDispatcher) notify() {
+	if d == nil {
+		return
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queueEvents create deliveries of queued change events for subscribed
+// webhooks
+func (d *webhookDispatcher) queueEvents() {
+	for {
+		webhooks, err := d.DBUtils.ActiveWebhooks()
+		if err != nil {
+			log.Printf("webhookDispatcher.ActiveWebhooks: %s", err)
+			return
+		}
+		n, err := d.DBUtils.ProcessWebhookEvents(webhookBatchSize, func(event *webhookEvent, deliver func(int, string, []byte) error) error {
+			return d.queue(webhooks, event, deliver)
+		})
+		if err != nil {
+			log.Printf("webhookDispatcher.ProcessWebhookEvents: %s", err)
+			return
+		}
+		if n < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// queue create a delivery of change event for every subscribed webhook
+func (d *webhookDispatcher) queue(webhooks []*Webhook, event *webhookEvent, deliver func(int, string, []byte) error) error {
+	name := webhookEventName(&event.Change)
+	if name == "" {
+		return nil
+	}
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.subscribed(name) {
+			continue
+		}
+		// payload is built once, only if a webhook is subscribed
+		if payload == nil {
+			var err error
+			payload, err = json.Marshal(&webhookPayload{name, event.Change.Id, event.CreatedAt.UTC(), event.Data})
+			if err != nil {
+				return err
+			}
+		}
+		if err := deliver(webhook.Id, name, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookEventName return `table.action` event name of a change
+func webhookEventName(event *changeEvent) string {
+	actions := map[string]string{"INSERT": "created", "UPDATE": "updated", "DELETE": "deleted"}
+	name := event.Table + "." + actions[event.Action]
+	if !webhookEvents[name] {
+		return ""
+	}
+	return name
+}
+
+// deliverLoop deliver due deliveries every poll interval or when woken up
+func (d *webhookDispatcher) deliverLoop() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		case <-shuttingDown:
+			return
+		}
+		d.queueEvents()
+		for {
+			attempts, err := d.DBUtils.ClaimWebhookDeliveries(webhookBatchSize, webhookLease)
+			if err != nil {
+				log.Printf("webhookDispatcher.ClaimWebhookDeliveries: %s", err)
+				break
+			}
+			done := make(chan struct{})
+			for _, attempt := range attempts {
+				go func(attempt *webhookAttempt) {
+					d.deliver(attempt)
+					done <- struct{}{}
+				}(attempt)
+			}
+			for range attempts {
+				<-done
+			}
+			if len(attempts) < webhookBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// deliver POST a delivery and record the result
+func (d *webhookDispatcher) deliver(attempt *webhookAttempt) {
+	code, err := d.post(attempt)
+	if err == nil {
+		if err := d.DBUtils.RecordWebhookDelivered(attempt.DeliveryId, code); err != nil {
+			log.Printf("webhookDispatcher.RecordWebhookDelivered: %s", err)
+		}
+		return
+	}
+	attempts := attempt.Attempts + 1
+	dead := attempts >= webhookMaxAttempts()
+	if dead {
+		log.Printf("webhook delivery %d dead-lettered after %d attempts: %s", attempt.DeliveryId, attempts, err)
+	}
+	if err := d.DBUtils.RecordWebhookFailed(attempt.DeliveryId, code, err.Error(), webhookBackoff(attempts), dead); err != nil {
+		log.Printf("webhookDispatcher.RecordWebhookFailed: %s", err)
+	}
+}
+
+// post send a signed delivery, any non 2xx response is an error
+func (d *webhookDispatcher) post(attempt *webhookAttempt) (int, error) {
+	req, err := http.NewRequest("POST", attempt.Url, bytes.NewReader(attempt.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Wisdom-Webhook/1.0")
+	req.Header.Set("X-Wisdom-Event", attempt.Event)
+	req.Header.Set("X-Wisdom-Delivery", strconv.Itoa(attempt.DeliveryId))
+	req.Header.Set(webhookSignatureHeader, signWebhook(attempt.Secret, time.Now().Unix(), attempt.Payload))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, truncateError(err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, webhookMaxErrorSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, truncateError(resp.Status + ": " + string(body))
+	}
+	return resp.StatusCode, nil
+}
+
+func truncateError(message string) error {
+	if len(message) > webhookMaxErrorSize {
+		message = message[:webhookMaxErrorSize]
+	}
+	return errors.New(message)
+}
+
+// --- admin API ---
+
+// requireAdmin check `Authorization: Bearer <ADMIN_TOKEN>` header
+func requireAdmin(w http.ResponseWriter, r *http.Request, tag string) *apiError {
+	if ADMIN_TOKEN == "" {
+		return &apiError{
+			tag + ".requireAdmin",
+			errors.New("ADMIN_TOKEN is not set"),
+			"Admin API is disabled",
+			http.StatusForbidden,
+			errCodeForbidden,
+		}
+	}
+	authorization := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(ADMIN_TOKEN)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="wisdom"`)
+		return &apiError{
+			tag + ".requireAdmin",
+			errors.New("invalid admin token"),
+			"Invalid or missing bearer token",
+			http.StatusUnauthorized,
+			errCodeUnauthorized,
+		}
+	}
+	return nil
+}
+
+// webhookRequest define structure of create and update requests. Omitted
+// fields are unchanged on update
+type webhookRequest struct {
+	Url    *string  `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+	Secret *string  `json:"secret"`
+}
+
+// decodeWebhookRequest decode and check request body
+func decodeWebhookRequest(r *http.Request) (*webhookRequest, error) {
+	req := &webhookRequest{}
+	decoder := json.NewDecoder(io.LimitReader(r.Body, webhookMaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		return nil, errors.New("invalid JSON body: " + err.Error())
+	}
+	if req.Url != nil {
+		u, err := url.Parse(*req.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New("url must be an absolute http or https URL")
+		}
+	}
+	for _, event := range req.Events {
+		if !validWebhookEvent(event) {
+			return nil, fmt.Errorf("unknown event %q", event)
+		}
+	}
+	if req.Secret != nil && len(*req.Secret) < 16 {
+		return nil, errors.New("secret must be at least 16 characters")
+	}
+	return req, nil
+}
+
+// newWebhookSecret generate a random secret
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// webhookId read `id` route variable
+func webhookId(r *http.Request, name string) int {
+	id, _ := strconv.Atoi(mux.Vars(r)[name])
+	return id
+}
+
+// /v1/webhooks endpoint. list or create webhooks
+func webhooksHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	if err := requireAdmin(w, r, "webhooksHandler"); err != nil {
+		return err
+	}
+	if r.Method != "POST" {
+		webhooks, err := dbUtils.Webhooks()
+		if err != nil {
+			return databaseError("webhooksHandler.Webhooks", err)
+		}
+		for _, webhook := range webhooks {
+			webhook.Secret = ""
+		}
+		return writeJSON(w, r, webhooks, "webhooksHandler")
+	}
+
+	req, err := decodeWebhookRequest(r)
+	if err != nil {
+		return invalidParameter("webhooksHandler.decodeWebhookRequest", err)
+	}
+	if req.Url == nil {
+		return invalidParameter("webhooksHandler.url", errors.New("url is required"))
+	}
+	events := req.Events
+	if len(events) == 0 {
+		events = []string{"*"}
+	}
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+	var secret string
+	if req.Secret != nil {
+		secret = *req.Secret
+	} else if secret, err = newWebhookSecret(); err != nil {
+		return &apiError{
+			"webhooksHandler.newWebhookSecret",
+			err,
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+	webhook, err := dbUtils.CreateWebhook(*req.Url, secret, events, active)
+	if err != nil {
+		return databaseError("webhooksHandler.CreateWebhook", err)
+	}
+	w.Header().Set("Location", "/v1/webhooks/"+strconv.Itoa(webhook.Id))
+	w.WriteHeader(http.StatusCreated)
+	return writeJSON(w, r, webhook, "webhooksHandler")
+}
+
+// /v1/webhooks/{id} endpoint. return, update or delete a webhook
+func webhookHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	if err := requireAdmin(w, r, "webhookHandler"); err != nil {
+		return err
+	}
+	webhook, err := dbUtils.WebhookById(webhookId(r, "id"))
+	if err != nil {
+		return databaseError("webhookHandler.WebhookById", err)
+	}
+
+	switch r.Method {
+	case "PATCH":
+		req, err := decodeWebhookRequest(r)
+		if err != nil {
+			return invalidParameter("webhookHandler.decodeWebhookRequest", err)
+		}
+		if req.Secret != nil {
+			return invalidParameter("webhookHandler.secret", errors.New("secret can't be changed, create a new webhook"))
+		}
+		if req.Url != nil {
+			webhook.Url = *req.Url
+		}
+		if req.Events != nil {
+			if len(req.Events) == 0 {
+				return invalidParameter("webhookHandler.events", errors.New("events can't be empty, use [\"*\"] for every event"))
+			}
+			webhook.Events = req.Events
+		}
+		if req.Active != nil {
+			webhook.Active = *req.Active
+		}
+		webhook, err = dbUtils.UpdateWebhook(webhook.Id, webhook.Url, webhook.Events, webhook.Active)
+		if err != nil {
+			return databaseError("webhookHandler.UpdateWebhook", err)
+		}
+	case "DELETE":
+		if err := dbUtils.DeleteWebhook(webhook.Id); err != nil {
+			return databaseError("webhookHandler.DeleteWebhook", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	webhook.Secret = ""
+	return writeJSON(w, r, webhook, "webhookHandler")
+}
+
+// /v1/webhooks/{id}/ping endpoint. queue a ping delivery
+func webhookPingHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	if err := requireAdmin(w, r, "webhookPingHandler"); err != nil {
+		return err
+	}
+	webhook, err := dbUtils.WebhookById(webhookId(r, "id"))
+	if err != nil {
+		return databaseError("webhookPingHandler.WebhookById", err)
+	}
+	payload, _ := json.Marshal(&webhookPayload{"ping", webhook.Id, time.Now().UTC(), map[string]int{"webhook_id": webhook.Id}})
+	delivery, err := dbUtils.CreateWebhookDelivery(webhook.Id, "ping", payload)
+	if err != nil {
+		return databaseError("webhookPingHandler.CreateWebhookDelivery", err)
+	}
+	webhookWorker.notify()
+	w.WriteHeader(http.StatusAccepted)
+	return writeJSON(w, r, delivery, "webhookPingHandler")
+}
+
+// /v1/webhooks/{id}/deliveries endpoint. return the delivery log, newest
+// first, optionally filtered by `status`
+func webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	if err := requireAdmin(w, r, "webhookDeliveriesHandler"); err != nil {
+		return err
+	}
+	webhook, err := dbUtils.WebhookById(webhookId(r, "id"))
+	if err != nil {
+		return databaseError("webhookDeliveriesHandler.WebhookById", err)
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "pending" && status != "delivered" && status != "dead" {
+		return invalidParameter("webhookDeliveriesHandler.status", errors.New("status must be pending, delivered or dead"))
+	}
+	page, perPage, err := pageParams(r)
+	if err != nil {
+		return invalidParameter("webhookDeliveriesHandler.pageParams", err)
+	}
+	deliveries, err := dbUtils.WebhookDeliveries(webhook.Id, status, perPage, (page-1)*perPage)
+	if err != nil {
+		return databaseError("webhookDeliveriesHandler.WebhookDeliveries", err)
+	}
+	return writeJSON(w, r, deliveries, "webhookDeliveriesHandler")
+}
+
+// /v1/webhooks/{id}/deliveries/{delivery_id} endpoint. return a delivery,
+// POST queue it again, e.g. to redeliver a dead-lettered delivery
+func webhookDeliveryHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	if err := requireAdmin(w, r, "webhookDeliveryHandler"); err != nil {
+		return err
+	}
+	var delivery *WebhookDelivery
+	var err error
+	if r.Method == "POST" {
+		delivery, err = dbUtils.RetryWebhookDelivery(webhookId(r, "id"), webhookId(r, "delivery_id"))
+		webhookWorker.notify()
+	} else {
+		delivery, err = dbUtils.WebhookDeliveryById(webhookId(r, "id"), webhookId(r, "delivery_id"))
+	}
+	if err != nil {
+		return databaseError("webhookDeliveryHandler", err)
+	}
+	return writeJSON(w, r, delivery, "webhookDeliveryHandler")
+}
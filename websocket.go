@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// This file implement the server side of WebSocket protocol (RFC 6455)
+// for /v1/ws. Extensions and subprotocols are not supported.
+
+var (
+	// maximum number of concurrent WebSocket connections
+	WS_MAX_CONNECTIONS = os.Getenv("WS_MAX_CONNECTIONS")
+)
+
+const (
+	wsDefaultMaxConnections = 100
+	// GUID appended to Sec-WebSocket-Key, see RFC 6455 section 1.3
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maximum size of a client message, commands are short
+	wsMaxMessageSize = 1024
+	wsPingPeriod     = 30 * time.Second
+	// connection is closed if nothing is received during wsPongWait
+	wsPongWait  = 60 * time.Second
+	wsWriteWait = 10 * time.Second
+)
+
+// frame opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// close status codes
+const (
+	wsCloseNormal          = 1000
+	wsCloseGoingAway       = 1001
+	wsCloseProtocolError   = 1002
+	wsCloseUnsupportedData = 1003
+	wsCloseInvalidPayload  = 1007
+	wsCloseTooBig          = 1009
+)
+
+// wsCloseError is returned by readMessage when the connection must be
+// closed with status code
+type wsCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return "websocket: close " + strconv.Itoa(e.Code) + " " + e.Reason
+}
+
+// wsConnections count open connections
+var wsConnections int64
+
+// wsTracker track hijacked connections, http.Server.Shutdown doesn't wait
+// for them
+type wsTracker struct {
+	mu      sync.Mutex
+	conns   map[*wsConn]bool
+	closing bool
+	wg      sync.WaitGroup
+}
+
+var wsConns = &wsTracker{conns: map[*wsConn]bool{}}
+
+// add track a connection, false is returned when shutting down
+func (t *wsTracker) add(c *wsConn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return false
+	}
+	t.conns[c] = true
+	t.wg.Add(1)
+	return true
+}
+
+// remove stop tracking a connection once its handler is done with it
+func (t *wsTracker) remove(c *wsConn) {
+	t.mu.Lock()
+	delete(t.conns, c)
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+// shutdown wait for handlers to close their connection with 1001 on
+// shuttingDown. Remaining connections are closed when ctx is done
+func (t *wsTracker) shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.closing = true
+	t.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		for c := range t.conns {
+			c.conn.Close()
+		}
+		t.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func wsMaxConnections() int64 {
+	max, err := strconv.Atoi(WS_MAX_CONNECTIONS)
+	if err != nil || max <= 0 {
+		return wsDefaultMaxConnections
+	}
+	return int64(max)
+}
+
+// headerContains check comma separated header value contains token
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsAccept compute Sec-WebSocket-Accept from Sec-WebSocket-Key
+func wsAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+wsGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// wsConn is a server side WebSocket connection. Writes are safe for
+// concurrent use, reads must happen in a single goroutine
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex
+}
+
+// upgradeRequired respond 426 with the upgrade the client must request
+func upgradeRequired(w http.ResponseWriter, err error) *apiError {
+	w.Header().Set("Upgrade", "websocket")
+	w.Header().Set("Connection", "Upgrade")
+	w.Header().Set("Sec-WebSocket-Version", "13")
+	return &apiError{
+		"upgradeWebSocket.upgradeRequired",
+		err,
+		err.Error(),
+		http.StatusUpgradeRequired,
+		errCodeUpgradeRequired,
+	}
+}
+
+// upgradeWebSocket check handshake request and hijack the connection. A
+// missing upgrade or an unsupported version is responded with 426, a
+// malformed handshake with 400
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, *apiError) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, upgradeRequired(w, errors.New("websocket upgrade required"))
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, upgradeRequired(w, errors.New("unsupported websocket version, only 13 is supported"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, invalidParameter("upgradeWebSocket.key", errors.New("missing or invalid Sec-WebSocket-Key"))
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, &apiError{
+			"upgradeWebSocket.Hijacker",
+			errors.New("websocket unsupported"),
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("upgradeWebSocket.Hijack: %s", err)
+		return nil, nil
+	}
+	// response headers set by ApiHandler are lost, only the handshake and
+	// the request id are sent
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n" +
+		"X-Request-Id: " + w.Header().Get("X-Request-Id") + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, nil
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// writeFrame write a single unfragmented, unmasked frame
+func (c *wsConn) writeFrame(opcode int, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header := []byte{0x80 | byte(opcode), 0}
+	switch length := len(payload); {
+	case length < 126:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeJSON write v as a text message
+func (c *wsConn) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, b)
+}
+
+// close send a close frame and close the connection. The server close the
+// TCP connection first, see RFC 6455 section 7.1.1
+func (c *wsConn) close(code int, reason string) {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	c.writeFrame(wsOpClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// readFrame read a frame and unmask its payload
+func (c *wsConn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		err = &wsCloseError{wsCloseProtocolError, "reserved bits set"}
+		return
+	}
+	// client frames must be masked, see RFC 6455 section 5.1
+	if header[1]&0x80 == 0 {
+		err = &wsCloseError{wsCloseProtocolError, "frame not masked"}
+		return
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if opcode >= wsOpClose && (length > 125 || !fin) {
+		err = &wsCloseError{wsCloseProtocolError, "invalid control frame"}
+		return
+	}
+	if length > wsMaxMessageSize {
+		err = &wsCloseError{wsCloseTooBig, "message too big"}
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// readMessage return the next text message. Ping are answered, fragmented
+// messages are reassembled. io.EOF is returned when the client close the
+// connection
+func (c *wsConn) readMessage() (string, error) {
+	var message []byte
+	fragmented := false
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return "", err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return "", err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			return "", io.EOF
+		case wsOpBinary:
+			return "", &wsCloseError{wsCloseUnsupportedData, "binary messages are not supported"}
+		case wsOpText:
+			if fragmented {
+				return "", &wsCloseError{wsCloseProtocolError, "expected continuation frame"}
+			}
+		case wsOpContinuation:
+			if !fragmented {
+				return "", &wsCloseError{wsCloseProtocolError, "unexpected continuation frame"}
+			}
+		default:
+			return "", &wsCloseError{wsCloseProtocolError, "unknown opcode"}
+		}
+		message = append(message, payload...)
+		if len(message) > wsMaxMessageSize {
+			return "", &wsCloseError{wsCloseTooBig, "message too big"}
+		}
+		if !fin {
+			fragmented = true
+			continue
+		}
+		if !utf8.Valid(message) {
+			return "", &wsCloseError{wsCloseInvalidPayload, "invalid UTF-8"}
+		}
+		return string(message), nil
+	}
+}
+
+// wsMessage is a reply to a command which is not a quote
+type wsMessage struct {
+	Type    string     `json:"type"`
+	Message string     `json:"message,omitempty"`
+	Code    string     `json:"code,omitempty"`
+	Filters *wsFilters `json:"filters,omitempty"`
+}
+
+// wsFilters is the filter state of a connection
+type wsFilters struct {
+	Tag        string `json:"tag"`
+	Author     string `json:"author"`
+	Subscribed bool   `json:"subscribed"`
+}
+
+// wsError create an error reply from an apiError
+func wsError(err *apiError) *wsMessage {
+	return &wsMessage{Type: "error", Message: err.Message, Code: err.ErrorCode}
+}
+
+// wsSession hold the state of a /v1/ws connection
+type wsSession struct {
+	conn    *wsConn
+	dbUtils *DatabaseUtils
+	filters wsFilters
+}
+
+// setFilters parse `tag=label` and `author=twitter_username` arguments
+func (s *wsSession) setFilters(args []string) *apiError {
+	filters := s.filters
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return invalidParameter("wsSession.setFilters", fmt.Errorf("invalid argument %q, expected tag=label or author=twitter_username", arg))
+		}
+		switch key {
+		case "tag":
+			if value != "" {
+				if _, err := s.dbUtils.TagByLabel(value); err != nil {
+					return databaseError("wsSession.TagByLabel", err)
+				}
+			}
+			filters.Tag = value
+		case "author":
+			if value != "" {
+				if _, err := s.dbUtils.AuthorByTwitterUsername(value); err != nil {
+					return databaseError("wsSession.AuthorByTwitterUsername", err)
+				}
+			}
+			filters.Author = value
+		default:
+			return invalidParameter("wsSession.setFilters", fmt.Errorf("unknown filter %q", key))
+		}
+	}
+	s.filters = filters
+	return nil
+}
+
+// command execute a client command and return the reply
+func (s *wsSession) command(text string) interface{} {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return wsError(invalidParameter("wsSession.command", errors.New("empty command")))
+	}
+	switch name := strings.ToLower(fields[0]); {
+	case name == "next":
+		if err := s.setFilters(fields[1:]); err != nil {
+			return wsError(err)
+		}
+		quote, err := s.dbUtils.RandomQuoteFiltered(s.filters.Tag, s.filters.Author)
+		if err != nil {
+			return wsError(databaseError("wsSession.RandomQuoteFiltered", err))
+		}
+		return quote
+	case name == "subscribe":
+		if err := s.setFilters(fields[1:]); err != nil {
+			return wsError(err)
+		}
+		s.filters.Subscribed = true
+		return &wsMessage{Type: "filters", Filters: &s.filters}
+	case name == "unsubscribe":
+		s.filters = wsFilters{}
+		return &wsMessage{Type: "filters", Filters: &s.filters}
+	case name == "filters":
+		return &wsMessage{Type: "filters", Filters: &s.filters}
+	case strings.Contains(name, "="):
+		// filters without command, e.g. `author=paulg`
+		if err := s.setFilters(fields); err != nil {
+			return wsError(err)
+		}
+		return &wsMessage{Type: "filters", Filters: &s.filters}
+	default:
+		return wsError(invalidParameter("wsSession.command", fmt.Errorf("unknown command %q, expected next, subscribe, unsubscribe or filters", fields[0])))
+	}
+}
+
+// change return the quote to push to a subscribed session, nil if the
+// change doesn't match its filters
+func (s *wsSession) change(event *changeEvent) interface{} {
+	if !s.filters.Subscribed || event.Table != "quotes" || event.Action == "DELETE" {
+		return nil
+	}
+	quote, err := s.dbUtils.QuoteById(event.Id)
+	if err != nil || !quoteMatches(quote, s.filters.Tag, s.filters.Author) {
+		return nil
+	}
+	return quote
+}
+
+// /v1/ws endpoint. Interactive quote client over WebSocket
+func wsHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	// initial filters can be given as query parameters
+	tag_label, twitter_username, apiErr := quoteFilters(r, dbUtils, "wsHandler")
+	if apiErr != nil {
+		return apiErr
+	}
+
+	if atomic.AddInt64(&wsConnections, 1) > wsMaxConnections() {
+		atomic.AddInt64(&wsConnections, -1)
+		w.Header().Set("Retry-After", "30")
+		return &apiError{
+			"wsHandler.maxConnections",
+			errors.New("too many websocket connections"),
+			"Too many connections, retry later",
+			http.StatusServiceUnavailable,
+			errCodeUnavailable,
+		}
+	}
+	defer atomic.AddInt64(&wsConnections, -1)
+
+	conn, apiErr := upgradeWebSocket(w, r)
+	if conn == nil {
+		// nil error when the connection is hijacked, nothing can be responded
+		return apiErr
+	}
+	if !wsConns.add(conn) {
+		conn.close(wsCloseGoingAway, "server shutting down")
+		return nil
+	}
+	defer wsConns.remove(conn)
+
+	session := &wsSession{conn: conn, dbUtils: dbUtils}
+	session.filters.Tag = tag_label
+	session.filters.Author = twitter_username
+	events, _ := changes.subscribe(changes.lastSeq())
+	defer changes.unsubscribe(events)
+
+	// messages are read in their own goroutine, commands are executed by
+	// the loop below so session state isn't shared
+	messages := make(chan string)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			message, err := conn.readMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case messages <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		var reply interface{}
+		select {
+		case message := <-messages:
+			reply = session.command(message)
+		case event := <-events:
+			reply = session.change(event)
+		case <-ping.C:
+			if err := conn.writeFrame(wsOpPing, nil); err != nil {
+				conn.conn.Close()
+				return nil
+			}
+		case <-shuttingDown:
+			conn.close(wsCloseGoingAway, "server shutting down")
+			return nil
+		case err := <-readErr:
+			if closeErr, ok := err.(*wsCloseError); ok {
+				conn.close(closeErr.Code, closeErr.Reason)
+			} else if err == io.EOF {
+				conn.close(wsCloseNormal, "")
+			} else {
+				log.Printf("wsHandler.readMessage: %s", err)
+				conn.conn.Close()
+			}
+			return nil
+		}
+		if reply == nil {
+			continue
+		}
+		if err := conn.writeJSON(reply); err != nil {
+			conn.conn.Close()
+			return nil
+		}
+	}
+}
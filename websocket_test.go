@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClientFrame return a masked client frame
+func wsClientFrame(fin bool, opcode int, payload []byte) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, 0x80|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 0x80|126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	frame = append(frame, mask...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	return frame
+}
+
+// wsPipe return a connection reading input, and the server side of a pipe
+// whose client side receives what the connection writes
+func wsPipe(input []byte) (*wsConn, net.Conn) {
+	server, client := net.Pipe()
+	c := &wsConn{conn: server, br: bufio.NewReader(bytes.NewReader(input))}
+	return c, client
+}
+
+func TestWSAccept(t *testing.T) {
+	// example of RFC 6455 section 1.3
+	if got, want := wsAccept("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("wsAccept() = %q, want %q", got, want)
+	}
+}
+
+func TestWSWriteFrame(t *testing.T) {
+	tests := []struct {
+		opcode int
+		length int
+		header []byte
+	}{
+		{wsOpText, 5, []byte{0x81, 5}},
+		{wsOpText, 125, []byte{0x81, 125}},
+		{wsOpText, 126, []byte{0x81, 126, 0, 126}},
+		{wsOpText, 0xffff, []byte{0x81, 126, 0xff, 0xff}},
+		{wsOpText, 0x10000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+		{wsOpPing, 0, []byte{0x89, 0}},
+	}
+	for _, test := range tests {
+		c, client := wsPipe(nil)
+		payload := bytes.Repeat([]byte("a"), test.length)
+		go func() {
+			c.writeFrame(test.opcode, payload)
+			c.conn.Close()
+		}()
+		got, _ := io.ReadAll(client)
+		if want := append(test.header, payload...); !bytes.Equal(got, want) {
+			t.Errorf("writeFrame(%d, %d bytes) wrote header %x, want %x", test.opcode, test.length, got[:len(test.header)], test.header)
+		}
+	}
+}
+
+func TestWSReadMessage(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	tests := []struct {
+		desc  string
+		input []byte
+		want  string
+		code  int
+	}{
+		{"text", wsClientFrame(true, wsOpText, []byte("next")), "next", 0},
+		{"16 bit length", wsClientFrame(true, wsOpText, []byte(long)), long, 0},
+		{"fragmented", append(wsClientFrame(false, wsOpText, []byte("ne")), wsClientFrame(true, wsOpContinuation, []byte("xt"))...), "next", 0},
+		{"pong between fragments", append(append(wsClientFrame(false, wsOpText, []byte("ne")), wsClientFrame(true, wsOpPong, nil)...), wsClientFrame(true, wsOpContinuation, []byte("xt"))...), "next", 0},
+		{"unmasked", []byte{0x81, 4, 'n', 'e', 'x', 't'}, "", wsCloseProtocolError},
+		{"reserved bits", append([]byte{0xc1}, wsClientFrame(true, wsOpText, []byte("next"))[1:]...), "", wsCloseProtocolError},
+		{"binary", wsClientFrame(true, wsOpBinary, []byte{1}), "", wsCloseUnsupportedData},
+		{"unknown opcode", wsClientFrame(true, 0x3, nil), "", wsCloseProtocolError},
+		{"unexpected continuation", wsClientFrame(true, wsOpContinuation, []byte("xt")), "", wsCloseProtocolError},
+		{"text in fragmented message", append(wsClientFrame(false, wsOpText, []byte("ne")), wsClientFrame(true, wsOpText, []byte("xt"))...), "", wsCloseProtocolError},
+		{"fragmented control frame", wsClientFrame(false, wsOpPong, nil), "", wsCloseProtocolError},
+		{"long control frame", wsClientFrame(true, wsOpPong, make([]byte, 126)), "", wsCloseProtocolError},
+		{"frame too big", wsClientFrame(true, wsOpText, make([]byte, wsMaxMessageSize+1)), "", wsCloseTooBig},
+		{"64 bit length", wsClientFrame(true, wsOpText, make([]byte, 0x10000)), "", wsCloseTooBig},
+		{"message too big", append(wsClientFrame(false, wsOpText, make([]byte, wsMaxMessageSize)), wsClientFrame(true, wsOpContinuation, []byte("a"))...), "", wsCloseTooBig},
+		{"invalid UTF-8", wsClientFrame(true, wsOpText, []byte{0xff}), "", wsCloseInvalidPayload},
+	}
+	for _, test := range tests {
+		c, client := wsPipe(test.input)
+		message, err := c.readMessage()
+		client.Close()
+		code := 0
+		if closeErr, ok := err.(*wsCloseError); ok {
+			code = closeErr.Code
+		} else if err != nil {
+			t.Errorf("%s: readMessage() = %v", test.desc, err)
+			continue
+		}
+		if message != test.want || code != test.code {
+			t.Errorf("%s: readMessage() = %q, close code %d, want %q, close code %d", test.desc, message, code, test.want, test.code)
+		}
+	}
+
+	// close frame and truncated frames end the connection
+	for desc, input := range map[string][]byte{
+		"close":     wsClientFrame(true, wsOpClose, []byte{0x03, 0xe8}),
+		"truncated": wsClientFrame(true, wsOpText, []byte("next"))[:7],
+	} {
+		c, client := wsPipe(input)
+		if _, err := c.readMessage(); err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: readMessage() = %v, want EOF", desc, err)
+		}
+		client.Close()
+	}
+}
+
+func TestWSReadMessagePing(t *testing.T) {
+	input := append(wsClientFrame(true, wsOpPing, []byte("hi")), wsClientFrame(true, wsOpText, []byte("next"))...)
+	c, client := wsPipe(input)
+	defer client.Close()
+	pong := make(chan []byte)
+	go func() {
+		b := make([]byte, 4)
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		io.ReadFull(client, b)
+		pong <- b
+	}()
+	if message, err := c.readMessage(); err != nil || message != "next" {
+		t.Errorf("readMessage() = %q, %v", message, err)
+	}
+	if got, want := <-pong, []byte{0x8a, 2, 'h', 'i'}; !bytes.Equal(got, want) {
+		t.Errorf("ping answered with %x, want %x", got, want)
+	}
+}
+
+func TestWSClose(t *testing.T) {
+	c, client := wsPipe(nil)
+	go c.close(wsCloseGoingAway, "server shutting down")
+	got, _ := io.ReadAll(client)
+	want := append([]byte{0x88, 22, 0x03, 0xe9}, "server shutting down"...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("close() wrote %x, want %x", got, want)
+	}
+}
+
+func TestUpgradeWebSocketErrors(t *testing.T) {
+	tests := []struct {
+		desc    string
+		headers map[string]string
+		status  int
+	}{
+		{"plain request", map[string]string{}, http.StatusUpgradeRequired},
+		{"no Connection upgrade", map[string]string{"Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, http.StatusUpgradeRequired},
+		{"version 8", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, http.StatusUpgradeRequired},
+		{"missing key", map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13"}, http.StatusBadRequest},
+		{"short key", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/v1/ws", nil)
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		conn, err := upgradeWebSocket(w, r)
+		if conn != nil || err == nil || err.Code != test.status {
+			t.Errorf("%s: upgradeWebSocket() = %v, %v, want status %d", test.desc, conn, err, test.status)
+			continue
+		}
+		if test.status == http.StatusUpgradeRequired && (w.Header().Get("Upgrade") != "websocket" || w.Header().Get("Sec-WebSocket-Version") != "13") {
+			t.Errorf("%s: 426 headers %v", test.desc, w.Header())
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// version of widget.js, bump it on incompatible changes of data
+	// attributes. Old versions keep being served
+	widgetVersion = "1"
+	// refresh interval of widgets, in seconds
+	widgetMinRefresh = 10
+	widgetMaxRefresh = 86400
+)
+
+// widgetScript render every `.wisdom-widget` element of the page with a
+// random quote. The script only use textContent so quotes can't inject
+// markup
+var widgetScript = `/* Wisdom widget v` + widgetVersion + ` */
+(function () {
+  "use strict";
+  var script = document.currentScript;
+  var origin = script ? new URL(script.src).origin : "";
+  var themes = {
+    light: {background: "#fafaf7", text: "#222222", accent: "#888888"},
+    dark: {background: "#1e1e24", text: "#eeeeee", accent: "#9999a5"}
+  };
+
+  function render(el, quote) {
+    var theme = themes[el.getAttribute("data-theme")] || themes.light;
+    var blockquote = document.createElement("blockquote");
+    blockquote.style.cssText = "box-sizing:border-box;margin:0;padding:1em 1.25em;border-left:4px solid " + theme.accent +
+      ";background:" + theme.background + ";color:" + theme.text + ";font:1.1em/1.5 Georgia,serif";
+    var p = document.createElement("p");
+    p.style.margin = "0 0 .5em";
+    p.textContent = quote.content;
+    var footer = document.createElement("footer");
+    footer.style.cssText = "font-size:.85em;color:" + theme.accent;
+    var cite = document.createElement("cite");
+    cite.style.fontStyle = "normal";
+    cite.textContent = quote.author.name;
+    footer.appendChild(document.createTextNode("— "));
+    footer.appendChild(cite);
+    if (quote.author.company) {
+      footer.appendChild(document.createTextNode(", " + quote.author.company));
+    }
+    blockquote.appendChild(p);
+    blockquote.appendChild(footer);
+    el.textContent = "";
+    el.appendChild(blockquote);
+  }
+
+  function load(el) {
+    var params = new URLSearchParams();
+    ["tag", "author"].forEach(function (name) {
+      var value = el.getAttribute("data-" + name);
+      if (value) {
+        params.set(name, value);
+      }
+    });
+    fetch(origin + "/embed/random.json?" + params, {mode: "cors", credentials: "omit"})
+      .then(function (resp) {
+        if (!resp.ok) {
+          throw new Error("wisdom widget: HTTP " + resp.status);
+        }
+        return resp.json();
+      })
+      .then(function (quote) { render(el, quote); })
+      .catch(function (err) { console.error(err); });
+  }
+
+  var widgets = document.querySelectorAll(".wisdom-widget");
+  for (var i = 0; i < widgets.length; i++) {
+    var el = widgets[i];
+    if (el.getAttribute("data-wisdom-loaded")) {
+      continue;
+    }
+    el.setAttribute("data-wisdom-loaded", "true");
+    load(el);
+    var refresh = parseInt(el.getAttribute("data-refresh"), 10);
+    if (refresh > 0) {
+      setInterval(load, Math.max(refresh, ` + strconv.Itoa(widgetMinRefresh) + `) * 1000, el);
+    }
+  }
+})();
+`
+
+// widgetETag identify the widget script, it change when the script change
+var widgetETag = func() string {
+	h := sha256.Sum256([]byte(widgetScript))
+	return `"` + hex.EncodeToString(h[:8]) + `"`
+}()
+
+// embedTemplate is the iframe-able page of a random quote. Styles use a
+// nonce so the page doesn't need `unsafe-inline`
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+{{if .Refresh}}<meta http-equiv="refresh" content="{{.Refresh}}">
+{{end}}<title>{{.Quote.Author.Name}} — Wisdom</title>
+<style nonce="{{.Nonce}}">
+html, body { margin: 0; background: {{.Background}}; }
+blockquote { box-sizing: border-box; margin: 0; padding: 1em 1.25em; border-left: 4px solid {{.Accent}}; color: {{.Text}}; font: 1.1em/1.5 Georgia, serif; }
+p { margin: 0 0 .5em; }
+footer { font-size: .85em; color: {{.Accent}}; }
+cite { font-style: normal; }
+a { color: inherit; }
+</style>
+</head>
+<body>
+<blockquote>
+<p>{{.Quote.Content}}</p>
+<footer>— <cite>{{if .Quote.Permalink}}<a href="{{.Quote.Permalink}}" target="_blank" rel="noopener">{{.Quote.Author.Name}}</a>{{else}}{{.Quote.Author.Name}}{{end}}</cite>{{if .Quote.Author.Company}}, {{.Quote.Author.Company}}{{end}}</footer>
+</blockquote>
+</body>
+</html>
+`))
+
+// widgetRefresh read `refresh` query parameter, 0 when missing
+func widgetRefresh(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("refresh")
+	if value == "" {
+		return 0, nil
+	}
+	refresh, err := strconv.Atoi(value)
+	if err != nil || refresh < widgetMinRefresh || refresh > widgetMaxRefresh {
+		return 0, fmt.Errorf("refresh must be an integer between %d and %d", widgetMinRefresh, widgetMaxRefresh)
+	}
+	return refresh, nil
+}
+
+// /widget.js and /widget/v1/widget.js endpoints. return the widget script,
+// the versioned script can be cached longer
+func widgetScriptHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.URL.Path == "/widget.js" {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=604800")
+	}
+	w.Header().Set("ETag", widgetETag)
+	if r.Header.Get("If-None-Match") == widgetETag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Write([]byte(widgetScript))
+	return nil
+}
+
+// /embed/random.json endpoint. return a random quote for widgets, readable
+// from any origin
+func embedRandomJSONHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	// public data without credentials, any page can embed a widget
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-store")
+	tag_label, twitter_username, apiErr := quoteFilters(r, dbUtils, "embedRandomJSONHandler")
+	if apiErr != nil {
+		return apiErr
+	}
+	quote, err := dbUtils.RandomQuoteFiltered(tag_label, twitter_username)
+	if err != nil {
+		return databaseError("embedRandomJSONHandler.RandomQuoteFiltered", err)
+	}
+	return writeJSON(w, r, quote, "embedRandomJSONHandler.resp")
+}
+
+// /embed/random endpoint. return a self-contained HTML page of a random
+// quote, to be embedded in an iframe
+func embedRandomHandler(w http.ResponseWriter, r *http.Request, dbUtils *DatabaseUtils) *apiError {
+	theme_name := r.URL.Query().Get("theme")
+	if theme_name == "" {
+		theme_name = "light"
+	}
+	theme, ok := cardThemes[theme_name]
+	if !ok {
+		return invalidParameter("embedRandomHandler.theme", errors.New("theme must be light or dark"))
+	}
+	refresh, err := widgetRefresh(r)
+	if err != nil {
+		return invalidParameter("embedRandomHandler.widgetRefresh", err)
+	}
+	tag_label, twitter_username, apiErr := quoteFilters(r, dbUtils, "embedRandomHandler")
+	if apiErr != nil {
+		return apiErr
+	}
+	quote, err := dbUtils.RandomQuoteFiltered(tag_label, twitter_username)
+	if err != nil {
+		return databaseError("embedRandomHandler.RandomQuoteFiltered", err)
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return &apiError{
+			"embedRandomHandler.newNonce",
+			err,
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+	var buf bytes.Buffer
+	err = embedTemplate.Execute(&buf, map[string]interface{}{
+		"Quote":      quote,
+		"Refresh":    refresh,
+		"Nonce":      nonce,
+		"Background": template.CSS(svgColor(theme.Background)),
+		"Text":       template.CSS(svgColor(theme.Text)),
+		"Accent":     template.CSS(svgColor(theme.Accent)),
+	})
+	if err != nil {
+		return &apiError{
+			"embedRandomHandler.Execute",
+			err,
+			"OOOOOPPPSSSS! error happen. don't panic! we will be back soon :)",
+			http.StatusInternalServerError,
+			errCodeInternal,
+		}
+	}
+
+	// no script, no external resource, only the styles of this response.
+	// any page can frame it
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'nonce-"+nonce+"'; base-uri 'none'; form-action 'none'; frame-ancestors *")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+	return nil
+}